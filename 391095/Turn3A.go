@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	host             = "localhost"
+	port             = "8080"
+	configPathEnv    = "CLIENT_CONFIG"
+	maxFrameSize     = 1 << 20 // 1 MiB
+	frameHeaderSize  = 4
+	configPollPeriod = 2 * time.Second
+)
+
+// Environment variables that override values from the config file.
+const (
+	dialTimeoutEnv  = "DIAL_TIMEOUT_SECONDS"
+	readTimeoutEnv  = "READ_TIMEOUT_SECONDS"
+	writeTimeoutEnv = "WRITE_TIMEOUT_SECONDS"
+	timeoutEnv      = "TIMEOUT_SECONDS" // legacy: applies to dial, read and write
+)
+
+// Duration is a time.Duration that is written as a string ("5s") in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ClientConfig holds every network setting the client uses.
+type ClientConfig struct {
+	DialTimeout      Duration `json:"dial_timeout"`
+	ReadTimeout      Duration `json:"read_timeout"`
+	WriteTimeout     Duration `json:"write_timeout"`
+	BackoffInitial   Duration `json:"backoff_initial"`
+	BackoffMax       Duration `json:"backoff_max"`
+	MaxRetryAttempts int      `json:"max_retry_attempts"`
+}
+
+// defaultClientConfig returns the settings used when nothing else is configured.
+func defaultClientConfig() ClientConfig {
+	return ClientConfig{
+		DialTimeout:      Duration(5 * time.Second),
+		ReadTimeout:      Duration(10 * time.Second),
+		WriteTimeout:     Duration(5 * time.Second),
+		BackoffInitial:   Duration(500 * time.Millisecond),
+		BackoffMax:       Duration(16 * time.Second),
+		MaxRetryAttempts: 5,
+	}
+}
+
+// Validate reports the first setting that is out of range.
+func (c ClientConfig) Validate() error {
+	switch {
+	case c.DialTimeout <= 0:
+		return errors.New("dial_timeout must be positive")
+	case c.ReadTimeout <= 0:
+		return errors.New("read_timeout must be positive")
+	case c.WriteTimeout <= 0:
+		return errors.New("write_timeout must be positive")
+	case c.BackoffInitial <= 0:
+		return errors.New("backoff_initial must be positive")
+	case c.BackoffMax < c.BackoffInitial:
+		return errors.New("backoff_max must not be smaller than backoff_initial")
+	case c.MaxRetryAttempts <= 0:
+		return errors.New("max_retry_attempts must be a positive integer")
+	}
+	return nil
+}
+
+// Backoff returns the delay before retry number attempt (starting at 0).
+func (c ClientConfig) Backoff(attempt int) time.Duration {
+	d := time.Duration(c.BackoffInitial)
+	for i := 0; i < attempt; i++ {
+		d *= 2
+		if d >= time.Duration(c.BackoffMax) {
+			return time.Duration(c.BackoffMax)
+		}
+	}
+	return d
+}
+
+// loadClientConfig builds a config from the defaults, the JSON file at path
+// (if path is not empty) and finally the environment.
+func loadClientConfig(path string) (ClientConfig, error) {
+	cfg := defaultClientConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ClientConfig{}, fmt.Errorf("reading config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return ClientConfig{}, fmt.Errorf("parsing config file: %w", err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return ClientConfig{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return ClientConfig{}, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+func applyEnv(cfg *ClientConfig) error {
+	overrides := []struct {
+		name   string
+		fields []*Duration
+	}{
+		{timeoutEnv, []*Duration{&cfg.DialTimeout, &cfg.ReadTimeout, &cfg.WriteTimeout}},
+		{dialTimeoutEnv, []*Duration{&cfg.DialTimeout}},
+		{readTimeoutEnv, []*Duration{&cfg.ReadTimeout}},
+		{writeTimeoutEnv, []*Duration{&cfg.WriteTimeout}},
+	}
+	for _, o := range overrides {
+		s, ok := os.LookupEnv(o.name)
+		if !ok || s == "" {
+			continue
+		}
+		secs, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid %s environment variable: %w", o.name, err)
+		}
+		for _, f := range o.fields {
+			*f = Duration(time.Duration(secs) * time.Second)
+		}
+	}
+	return nil
+}
+
+// ConfigStore hands out the current config and swaps in new ones atomically,
+// so readers never see a half-applied reload.
+type ConfigStore struct {
+	path    string
+	current atomic.Pointer[ClientConfig]
+}
+
+// NewConfigStore loads the initial config from path and the environment.
+func NewConfigStore(path string) (*ConfigStore, error) {
+	cfg, err := loadClientConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &ConfigStore{path: path}
+	s.current.Store(&cfg)
+	return s, nil
+}
+
+// Load returns a snapshot of the current config.
+func (s *ConfigStore) Load() ClientConfig {
+	return *s.current.Load()
+}
+
+// Reload re-reads the config. An invalid config is rejected and the previous
+// one stays in effect.
+func (s *ConfigStore) Reload() error {
+	cfg, err := loadClientConfig(s.path)
+	if err != nil {
+		return err
+	}
+	s.current.Store(&cfg)
+	return nil
+}
+
+// Watch reloads the config on SIGHUP and whenever the config file's
+// modification time changes. It returns when ctx is done.
+func (s *ConfigStore) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configPollPeriod)
+	defer ticker.Stop()
+	lastMod := s.modTime()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			s.reloadAndLog("SIGHUP")
+		case <-ticker.C:
+			if mod := s.modTime(); !mod.Equal(lastMod) {
+				lastMod = mod
+				s.reloadAndLog("file change")
+			}
+		}
+	}
+}
+
+func (s *ConfigStore) modTime() time.Time {
+	if s.path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (s *ConfigStore) reloadAndLog(reason string) {
+	if err := s.Reload(); err != nil {
+		log.Printf("Config reload (%s) rejected, keeping previous config: %v", reason, err)
+		return
+	}
+	log.Printf("Config reloaded (%s): %+v", reason, s.Load())
+}
+
+// writeFrame writes payload prefixed with its length as a 4-byte big-endian integer.
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds limit of %d", len(payload), maxFrameSize)
+	}
+	buf := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[frameHeaderSize:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readFrame reads one length-prefixed frame written by writeFrame.
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit of %d", size, maxFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// NewClientWithConfig dials addr using the dial timeout of the current config.
+func NewClientWithConfig(addr string, store *ConfigStore) (net.Conn, error) {
+	cfg := store.Load()
+	dialer := &net.Dialer{Timeout: time.Duration(cfg.DialTimeout)}
+	return dialer.Dial("tcp", addr)
+}
+
+// sendRequest writes one framed request using the write timeout in effect
+// when the call starts.
+func sendRequest(conn net.Conn, store *ConfigStore, request []byte) error {
+	cfg := store.Load()
+	if err := conn.SetWriteDeadline(time.Now().Add(time.Duration(cfg.WriteTimeout))); err != nil {
+		return err
+	}
+	return writeFrame(conn, request)
+}
+
+// readResponse reads one framed response using the read timeout in effect
+// when the call starts.
+func readResponse(conn net.Conn, store *ConfigStore) ([]byte, error) {
+	cfg := store.Load()
+	if err := conn.SetReadDeadline(time.Now().Add(time.Duration(cfg.ReadTimeout))); err != nil {
+		return nil, err
+	}
+	return readFrame(conn)
+}
+
+// roundTrip dials, sends one request and returns its response.
+func roundTrip(addr string, store *ConfigStore, request []byte) ([]byte, error) {
+	conn, err := NewClientWithConfig(addr, store)
+	if err != nil {
+		return nil, fmt.Errorf("connection failed: %w", err)
+	}
+	defer conn.Close()
+
+	if err := sendRequest(conn, store, request); err != nil {
+		return nil, fmt.Errorf("sending request failed: %w", err)
+	}
+	response, err := readResponse(conn, store)
+	if err != nil {
+		return nil, fmt.Errorf("reading response failed: %w", err)
+	}
+	return response, nil
+}
+
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.As(err, &nerr) && nerr.Timeout()
+}
+
+func main() {
+	store, err := NewConfigStore(os.Getenv(configPathEnv))
+	if err != nil {
+		log.Fatalf("Loading client config: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Watch(ctx)
+
+	addr := net.JoinHostPort(host, port)
+	fmt.Println("Connecting to", addr)
+
+	for attempt := 0; ; attempt++ {
+		// The retry budget is read on every pass so a reload takes effect immediately.
+		cfg := store.Load()
+		if attempt >= cfg.MaxRetryAttempts {
+			log.Fatalf("Giving up after %d attempts", attempt)
+		}
+
+		response, err := roundTrip(addr, store, []byte("Hello, World!"))
+		if err == nil {
+			fmt.Println(string(response))
+			fmt.Println("Success!")
+			return
+		}
+
+		backoff := cfg.Backoff(attempt)
+		if isTimeout(err) {
+			log.Printf("%v - timeout, retrying in %v", err, backoff)
+		} else {
+			log.Printf("%v - retrying in %v", err, backoff)
+		}
+		time.Sleep(backoff)
+	}
+}
@@ -0,0 +1,672 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock abstracts time so the scheduler can be driven by a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the subset of *time.Timer the scheduler needs.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (r realTimer) C() <-chan time.Time { return r.t.C }
+func (r realTimer) Stop() bool          { return r.t.Stop() }
+
+// Schedule computes when a job should run next.
+type Schedule interface {
+	// Next returns the first run time after t, or the zero time if there is none.
+	Next(t time.Time) time.Time
+}
+
+// FixedRate runs a job every interval measured from the previous scheduled start.
+type FixedRate time.Duration
+
+func (r FixedRate) Next(t time.Time) time.Time { return t.Add(time.Duration(r)) }
+
+// FixedDelay runs a job a fixed delay after the previous run has finished.
+type FixedDelay time.Duration
+
+func (d FixedDelay) Next(t time.Time) time.Time { return t.Add(time.Duration(d)) }
+
+// CronSchedule is a standard five-field cron expression:
+// minute hour day-of-month month day-of-week.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week, 0 and 7 are Sunday
+}
+
+// ParseCron parses expressions such as "*/15 9-17 * * 1-5".
+func ParseCron(expr string) (*CronSchedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, got %d", expr, len(parts))
+	}
+	var bits [5]uint64
+	for i, p := range parts {
+		b, err := parseCronField(p, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+		bits[i] = b
+	}
+	// Fold Sunday=7 onto Sunday=0.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+		bits[4] &^= 1 << 7
+	}
+	return &CronSchedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}, nil
+}
+
+func parseCronField(s string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(s, ",") {
+		rangePart, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", item)
+			}
+			rangePart, step = item[:i], n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rangePart)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				hi = f.max
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", item, f.min, f.max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// cronSearchLimit bounds the search for expressions that never match, like "0 0 30 2 *".
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// Next returns the first minute after t that matches the expression.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron semantics: when both day fields are restricted,
+// matching either one is enough.
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domOK := c.dom&(1<<uint(t.Day())) != 0
+	dowOK := c.dow&(1<<uint(t.Weekday())) != 0
+	if !c.domAny && !c.dowAny {
+		return domOK || dowOK
+	}
+	return domOK && dowOK
+}
+
+// OverlapPolicy decides what happens when a job is due while MaxConcurrent
+// runs are already in progress.
+type OverlapPolicy int
+
+const (
+	OverlapSkip  OverlapPolicy = iota // drop the run and record it as skipped
+	OverlapQueue                      // start it as soon as a running instance finishes
+)
+
+// JobSpec describes a periodic job.
+type JobSpec struct {
+	ID            string
+	Schedule      Schedule
+	Run           func(ctx context.Context) error
+	MaxRetries    int           // extra attempts after the first failure
+	RetryBackoff  time.Duration // delay before the first retry, doubled each time
+	MaxBackoff    time.Duration // upper bound for the retry delay, 0 means no bound
+	MaxConcurrent int           // defaults to 1
+	Overlap       OverlapPolicy
+}
+
+// RunStatus is the outcome of a single scheduled run.
+type RunStatus string
+
+const (
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	RunSkipped   RunStatus = "skipped"
+	RunCanceled  RunStatus = "canceled"
+)
+
+// RunRecord is one entry of a job's run history.
+type RunRecord struct {
+	JobID     string
+	Scheduled time.Time
+	Started   time.Time
+	Finished  time.Time
+	Attempts  int
+	Status    RunStatus
+	Err       string
+}
+
+// JobInfo is a point-in-time view of a job.
+type JobInfo struct {
+	ID      string
+	NextRun time.Time
+	Paused  bool
+	Running int
+	Queued  int
+}
+
+var ErrJobNotFound = errors.New("job not found")
+
+const defaultHistorySize = 100
+
+type job struct {
+	spec    JobSpec
+	next    time.Time // zero while not scheduled
+	paused  bool
+	running int
+	queued  int
+	history []RunRecord
+}
+
+func (j *job) record(r RunRecord, limit int) {
+	if len(j.history) == limit {
+		copy(j.history, j.history[1:])
+		j.history = j.history[:limit-1]
+	}
+	j.history = append(j.history, r)
+}
+
+// Scheduler runs periodic jobs and lets their schedules change at runtime.
+type Scheduler struct {
+	clock       Clock
+	historySize int
+
+	mu   sync.Mutex
+	jobs map[string]*job
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler driven by clock. A nil clock uses real time.
+func NewScheduler(clock Clock) *Scheduler {
+	if clock == nil {
+		clock = realClock{}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		clock:       clock,
+		historySize: defaultHistorySize,
+		jobs:        make(map[string]*job),
+		wake:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// Start launches the dispatch loop.
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop cancels running jobs and waits for them and the dispatch loop to exit.
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Add registers a job. Its first run is one schedule step from now.
+func (s *Scheduler) Add(spec JobSpec) error {
+	if spec.ID == "" || spec.Schedule == nil || spec.Run == nil {
+		return errors.New("job needs an ID, a schedule and a run function")
+	}
+	if spec.MaxConcurrent <= 0 {
+		spec.MaxConcurrent = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.jobs[spec.ID]; exists {
+		return fmt.Errorf("job %q already exists", spec.ID)
+	}
+	s.jobs[spec.ID] = &job{spec: spec, next: spec.Schedule.Next(s.clock.Now())}
+	s.signal()
+	return nil
+}
+
+// Remove unregisters a job. Runs already in progress are left to finish.
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	// Runs already in progress finish, but nothing queued behind them starts.
+	j.queued = 0
+	delete(s.jobs, id)
+	s.signal()
+	return nil
+}
+
+// SetSchedule replaces a job's schedule; the next run is recomputed from now.
+func (s *Scheduler) SetSchedule(id string, sched Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	j.spec.Schedule = sched
+	if _, delay := sched.(FixedDelay); delay && j.running > 0 {
+		// Rescheduled when the current run completes.
+		j.next = time.Time{}
+	} else {
+		j.next = sched.Next(s.clock.Now())
+	}
+	s.signal()
+	return nil
+}
+
+// Pause stops a job from being scheduled and drops its queued runs.
+func (s *Scheduler) Pause(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	j.paused = true
+	j.queued = 0
+	s.signal()
+	return nil
+}
+
+// Resume re-enables a paused job; its next run is one schedule step from now.
+func (s *Scheduler) Resume(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+	if j.paused {
+		j.paused = false
+		j.next = j.spec.Schedule.Next(s.clock.Now())
+		s.signal()
+	}
+	return nil
+}
+
+// History returns the most recent runs of a job, oldest first.
+func (s *Scheduler) History(id string) ([]RunRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return append([]RunRecord(nil), j.history...), nil
+}
+
+// Jobs returns a snapshot of every registered job.
+func (s *Scheduler) Jobs() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]JobInfo, 0, len(s.jobs))
+	for id, j := range s.jobs {
+		infos = append(infos, JobInfo{ID: id, NextRun: j.next, Paused: j.paused, Running: j.running, Queued: j.queued})
+	}
+	return infos
+}
+
+// signal wakes the dispatch loop so it recomputes its timer.
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		now := s.clock.Now()
+		s.dispatchDueLocked(now)
+		next, ok := s.earliestLocked()
+		s.mu.Unlock()
+
+		var timer Timer
+		var fire <-chan time.Time
+		if ok {
+			timer = s.clock.NewTimer(next.Sub(now))
+			fire = timer.C()
+		}
+
+		select {
+		case <-s.ctx.Done():
+		case <-s.wake:
+		case <-fire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) earliestLocked() (time.Time, bool) {
+	var earliest time.Time
+	for _, j := range s.jobs {
+		if j.paused || j.next.IsZero() {
+			continue
+		}
+		if earliest.IsZero() || j.next.Before(earliest) {
+			earliest = j.next
+		}
+	}
+	return earliest, !earliest.IsZero()
+}
+
+func (s *Scheduler) dispatchDueLocked(now time.Time) {
+	for _, j := range s.jobs {
+		if j.paused || j.next.IsZero() || j.next.After(now) {
+			continue
+		}
+		scheduled := j.next
+
+		if _, delay := j.spec.Schedule.(FixedDelay); delay {
+			j.next = time.Time{}
+		} else {
+			// Runs missed while the process was busy are coalesced into one.
+			j.next = j.spec.Schedule.Next(scheduled)
+			for !j.next.IsZero() && !j.next.After(now) {
+				j.next = j.spec.Schedule.Next(j.next)
+			}
+		}
+
+		switch {
+		case j.running < j.spec.MaxConcurrent:
+			s.startLocked(j, scheduled)
+		case j.spec.Overlap == OverlapQueue:
+			j.queued++
+		default:
+			j.record(RunRecord{
+				JobID:     j.spec.ID,
+				Scheduled: scheduled,
+				Started:   now,
+				Finished:  now,
+				Status:    RunSkipped,
+			}, s.historySize)
+			if _, delay := j.spec.Schedule.(FixedDelay); delay {
+				j.next = j.spec.Schedule.Next(now)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) startLocked(j *job, scheduled time.Time) {
+	j.running++
+	s.wg.Add(1)
+	go s.execute(j, scheduled)
+}
+
+func (s *Scheduler) execute(j *job, scheduled time.Time) {
+	defer s.wg.Done()
+
+	s.mu.Lock()
+	spec := j.spec
+	s.mu.Unlock()
+
+	rec := RunRecord{JobID: spec.ID, Scheduled: scheduled, Started: s.clock.Now()}
+	err := s.runWithRetry(spec, &rec)
+	rec.Finished = s.clock.Now()
+	switch {
+	case err == nil:
+		rec.Status = RunSucceeded
+	case errors.Is(err, context.Canceled):
+		rec.Status = RunCanceled
+		rec.Err = err.Error()
+	default:
+		rec.Status = RunFailed
+		rec.Err = err.Error()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.running--
+	j.record(rec, s.historySize)
+	if s.jobs[spec.ID] != j || j.paused || s.ctx.Err() != nil {
+		return
+	}
+	if _, delay := j.spec.Schedule.(FixedDelay); delay && j.next.IsZero() {
+		j.next = j.spec.Schedule.Next(rec.Finished)
+	}
+	if j.queued > 0 && j.running < j.spec.MaxConcurrent {
+		j.queued--
+		s.startLocked(j, rec.Finished)
+	}
+	s.signal()
+}
+
+func (s *Scheduler) runWithRetry(spec JobSpec, rec *RunRecord) error {
+	backoff := spec.RetryBackoff
+	for {
+		rec.Attempts++
+		err := spec.Run(s.ctx)
+		if err == nil || rec.Attempts > spec.MaxRetries || s.ctx.Err() != nil {
+			return err
+		}
+
+		timer := s.clock.NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-s.ctx.Done():
+			timer.Stop()
+			return s.ctx.Err()
+		}
+		backoff *= 2
+		if spec.MaxBackoff > 0 && backoff > spec.MaxBackoff {
+			backoff = spec.MaxBackoff
+		}
+	}
+}
+
+// simulateWork is a task that fails now and then.
+func simulateWork(ctx context.Context) error {
+	if rand.Intn(10) == 0 {
+		return fmt.Errorf("work failed randomly")
+	}
+	return nil
+}
+
+// parseSchedule accepts "rate <duration>", "delay <duration>" or "cron <expr>".
+func parseSchedule(kind, arg string) (Schedule, error) {
+	switch kind {
+	case "rate", "delay":
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid duration %q", arg)
+		}
+		if kind == "rate" {
+			return FixedRate(d), nil
+		}
+		return FixedDelay(d), nil
+	case "cron":
+		return ParseCron(arg)
+	}
+	return nil, fmt.Errorf("unknown schedule kind %q", kind)
+}
+
+func handleCommand(s *Scheduler, line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	var err error
+	switch fields[0] {
+	case "exit":
+		return false
+	case "jobs":
+		for _, info := range s.Jobs() {
+			fmt.Printf("%s next=%s paused=%t running=%d queued=%d\n",
+				info.ID, info.NextRun.Format(time.TimeOnly), info.Paused, info.Running, info.Queued)
+		}
+	case "set":
+		if len(fields) < 4 {
+			fmt.Println("Usage: set <job> rate|delay|cron <value>")
+			return true
+		}
+		var sched Schedule
+		if sched, err = parseSchedule(fields[2], strings.Join(fields[3:], " ")); err == nil {
+			err = s.SetSchedule(fields[1], sched)
+		}
+	case "pause", "resume", "history":
+		if len(fields) != 2 {
+			fmt.Printf("Usage: %s <job>\n", fields[0])
+			return true
+		}
+		switch fields[0] {
+		case "pause":
+			err = s.Pause(fields[1])
+		case "resume":
+			err = s.Resume(fields[1])
+		default:
+			var history []RunRecord
+			if history, err = s.History(fields[1]); err == nil {
+				for _, r := range history {
+					fmt.Printf("%s %s attempts=%d %s\n", r.Started.Format(time.TimeOnly), r.Status, r.Attempts, r.Err)
+				}
+			}
+		}
+	default:
+		fmt.Println("Commands: jobs | set <job> rate|delay|cron <value> | pause <job> | resume <job> | history <job> | exit")
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+	return true
+}
+
+func main() {
+	s := NewScheduler(nil)
+	s.Start()
+	defer s.Stop()
+
+	for i := 1; i <= 3; i++ {
+		id := fmt.Sprintf("worker-%d", i)
+		err := s.Add(JobSpec{
+			ID:       id,
+			Schedule: FixedRate(time.Second),
+			Run: func(ctx context.Context) error {
+				if err := simulateWork(ctx); err != nil {
+					return err
+				}
+				fmt.Printf("%s: performing work...\n", id)
+				return nil
+			},
+			MaxRetries:   3,
+			RetryBackoff: 500 * time.Millisecond,
+			MaxBackoff:   5 * time.Second,
+		})
+		if err != nil {
+			fmt.Println("Error adding job:", err)
+			return
+		}
+	}
+
+	fmt.Println("Scheduler running. Type 'help' for commands or 'exit' to stop.")
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if !handleCommand(s, scanner.Text()) {
+			return
+		}
+	}
+}
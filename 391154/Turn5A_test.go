@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when Advance is called.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	ch    chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward and fires every timer that is now due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- c.now
+	}
+	c.timers = pending
+}
+
+// BlockUntil waits until at least n timers are pending.
+func (c *fakeClock) BlockUntil(t *testing.T, n int) {
+	t.Helper()
+	waitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.timers) >= n
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func historyOf(t *testing.T, s *Scheduler, id string) []RunRecord {
+	t.Helper()
+	h, err := s.History(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestParseCronNext(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+30*60)
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 7, 30, 0, time.UTC), time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", time.Date(2024, 1, 5, 17, 30, 0, 0, time.UTC), time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC)},
+		{"30 2 1 * *", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"0 11 * * *", time.Date(2024, 1, 1, 9, 10, 0, 0, ist), time.Date(2024, 1, 1, 11, 0, 0, 0, ist)},
+	}
+	for _, tt := range tests {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		if got := c.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%v) = %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(bad); err == nil {
+			t.Errorf("ParseCron(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestFixedRateAndRuntimeIntervalChange(t *testing.T) {
+	clk := newFakeClock()
+	s := NewScheduler(clk)
+	s.Start()
+	defer s.Stop()
+
+	var runs atomic.Int32
+	if err := s.Add(JobSpec{ID: "tick", Schedule: FixedRate(10 * time.Second), Run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		clk.BlockUntil(t, 1)
+		clk.Advance(10 * time.Second)
+		waitFor(t, func() bool { return len(historyOf(t, s, "tick")) == i })
+	}
+
+	if err := s.SetSchedule("tick", FixedRate(2*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	clk.BlockUntil(t, 1)
+	clk.Advance(2 * time.Second)
+	waitFor(t, func() bool { return len(historyOf(t, s, "tick")) == 4 })
+
+	if got := runs.Load(); got != 4 {
+		t.Fatalf("runs = %d, want 4", got)
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	clk := newFakeClock()
+	s := NewScheduler(clk)
+	s.Start()
+	defer s.Stop()
+
+	var attempts atomic.Int32
+	err := s.Add(JobSpec{
+		ID:       "flaky",
+		Schedule: FixedRate(time.Hour),
+		Run: func(context.Context) error {
+			if attempts.Add(1) < 3 {
+				return errors.New("transient")
+			}
+			return nil
+		},
+		MaxRetries:   3,
+		RetryBackoff: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk.BlockUntil(t, 1)
+	clk.Advance(time.Hour)
+
+	// The dispatch timer plus one backoff timer; the second backoff is twice as long.
+	clk.BlockUntil(t, 2)
+	clk.Advance(time.Second)
+	clk.BlockUntil(t, 2)
+	clk.Advance(time.Second)
+	if attempts.Load() != 2 {
+		t.Fatalf("retry fired before backoff elapsed: attempts = %d", attempts.Load())
+	}
+	clk.Advance(time.Second)
+
+	waitFor(t, func() bool { return len(historyOf(t, s, "flaky")) == 1 })
+	rec := historyOf(t, s, "flaky")[0]
+	if rec.Status != RunSucceeded || rec.Attempts != 3 {
+		t.Fatalf("record = %+v, want succeeded after 3 attempts", rec)
+	}
+	if got := rec.Finished.Sub(rec.Started); got != 3*time.Second {
+		t.Fatalf("run took %v, want 3s of backoff", got)
+	}
+}
+
+func TestOverlapPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy OverlapPolicy
+		want   []RunStatus
+	}{
+		{"skip", OverlapSkip, []RunStatus{RunSkipped, RunSucceeded}},
+		{"queue", OverlapQueue, []RunStatus{RunSucceeded, RunSucceeded}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clk := newFakeClock()
+			s := NewScheduler(clk)
+			s.Start()
+			defer s.Stop()
+
+			release := make(chan struct{})
+			var started atomic.Int32
+			err := s.Add(JobSpec{
+				ID:       "slow",
+				Schedule: FixedRate(time.Second),
+				Run: func(context.Context) error {
+					if started.Add(1) == 1 {
+						<-release
+					}
+					return nil
+				},
+				Overlap: tc.policy,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clk.BlockUntil(t, 1)
+			clk.Advance(time.Second)
+			waitFor(t, func() bool { return started.Load() == 1 })
+			clk.BlockUntil(t, 1)
+			clk.Advance(time.Second)
+			waitFor(t, func() bool {
+				for _, info := range s.Jobs() {
+					if info.Queued == 1 {
+						return true
+					}
+				}
+				return len(historyOf(t, s, "slow")) == 1
+			})
+			close(release)
+
+			waitFor(t, func() bool { return len(historyOf(t, s, "slow")) == len(tc.want) })
+			for i, rec := range historyOf(t, s, "slow") {
+				if rec.Status != tc.want[i] {
+					t.Errorf("history[%d].Status = %s, want %s", i, rec.Status, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveDropsQueuedRuns(t *testing.T) {
+	clk := newFakeClock()
+	s := NewScheduler(clk)
+	s.Start()
+	defer s.Stop()
+
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	var started atomic.Int32
+	err := s.Add(JobSpec{
+		ID:       "slow",
+		Schedule: FixedRate(time.Second),
+		Run: func(context.Context) error {
+			if started.Add(1) == 1 {
+				defer close(finished)
+				<-release
+			}
+			return nil
+		},
+		Overlap: OverlapQueue,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk.BlockUntil(t, 1)
+	clk.Advance(time.Second)
+	waitFor(t, func() bool { return started.Load() == 1 })
+	clk.BlockUntil(t, 1)
+	clk.Advance(time.Second)
+	waitFor(t, func() bool {
+		jobs := s.Jobs()
+		return len(jobs) == 1 && jobs[0].Queued == 1
+	})
+
+	if err := s.Remove("slow"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-finished
+	time.Sleep(50 * time.Millisecond)
+	if n := started.Load(); n != 1 {
+		t.Errorf("removed job ran %d times, want 1", n)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	clk := newFakeClock()
+	s := NewScheduler(clk)
+	s.Start()
+	defer s.Stop()
+
+	var runs atomic.Int32
+	if err := s.Add(JobSpec{ID: "p", Schedule: FixedDelay(5 * time.Second), Run: func(context.Context) error {
+		runs.Add(1)
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Pause("p"); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if runs.Load() != 0 {
+		t.Fatalf("paused job ran %d times", runs.Load())
+	}
+
+	if err := s.Resume("p"); err != nil {
+		t.Fatal(err)
+	}
+	clk.BlockUntil(t, 1)
+	clk.Advance(5 * time.Second)
+	waitFor(t, func() bool { return runs.Load() == 1 })
+
+	if err := s.Pause("missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("Pause(missing) = %v, want ErrJobNotFound", err)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// Frame types sent by clients.
+const (
+	TypeJoin        = "join"
+	TypeLeave       = "leave"
+	TypeRoomMessage = "room_message"
+	TypeDirect      = "dm"
+	TypeTyping      = "typing"
+	TypeHistory     = "history"
+)
+
+// Frame types sent by the server. Room messages, DMs and typing
+// indicators are relayed with the same type they were sent with.
+const (
+	TypeAck      = "ack"
+	TypeError    = "error"
+	TypeJoined   = "joined"
+	TypeLeft     = "left"
+	TypeMessages = "messages"
+)
+
+// Error codes carried by error frames.
+const (
+	ErrBadFrame    = "bad_frame"
+	ErrUnknownType = "unknown_type"
+	ErrNotInRoom   = "not_in_room"
+	ErrNoSuchUser  = "no_such_user"
+	ErrInvalid     = "invalid_request"
+)
+
+const (
+	historyPerRoom = 100
+	sendBufferSize = 64
+	writeWait      = 10 * time.Second
+)
+
+// Message is the single frame format used in both directions.
+type Message struct {
+	Type     string          `json:"type"`
+	ID       string          `json:"id,omitempty"`  // client-chosen request ID, echoed in acks and errors
+	Seq      uint64          `json:"seq,omitempty"` // server-assigned message ID
+	Room     string          `json:"room,omitempty"`
+	To       string          `json:"to,omitempty"`
+	Username string          `json:"username,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Limit    int             `json:"limit,omitempty"`
+	Time     *time.Time      `json:"time,omitempty"` // set on server events, absent from acks
+}
+
+// now returns the current time for a Message's Time field.
+func now() *time.Time {
+	t := time.Now()
+	return &t
+}
+
+// ErrorData is the payload of an error frame.
+type ErrorData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// HistoryData is the payload of a messages frame.
+type HistoryData struct {
+	Messages []Message `json:"messages"`
+}
+
+// RingBuffer keeps the most recent messages up to a fixed capacity.
+type RingBuffer struct {
+	items []Message
+	start int
+	size  int
+}
+
+func NewRingBuffer(capacity int) *RingBuffer {
+	return &RingBuffer{items: make([]Message, capacity)}
+}
+
+// Push adds a message, overwriting the oldest one when full.
+func (r *RingBuffer) Push(m Message) {
+	end := (r.start + r.size) % len(r.items)
+	r.items[end] = m
+	if r.size < len(r.items) {
+		r.size++
+	} else {
+		r.start = (r.start + 1) % len(r.items)
+	}
+}
+
+// Last returns up to n of the newest messages, oldest first.
+func (r *RingBuffer) Last(n int) []Message {
+	if n <= 0 || n > r.size {
+		n = r.size
+	}
+	out := make([]Message, n)
+	for i := 0; i < n; i++ {
+		out[i] = r.items[(r.start+r.size-n+i)%len(r.items)]
+	}
+	return out
+}
+
+// Room is a named channel with its members and recent history.
+type Room struct {
+	name    string
+	members map[*Client]bool
+	history *RingBuffer
+}
+
+// Client is one connected user. All writes go through send so the
+// connection only ever has one writer.
+type Client struct {
+	username string
+	conn     *websocket.Conn
+	send     chan Message
+	rooms    map[string]bool
+}
+
+// Server holds the connected users and their rooms.
+type Server struct {
+	mu      sync.Mutex
+	clients map[string]*Client
+	rooms   map[string]*Room
+	nextSeq atomic.Uint64
+}
+
+func NewServer() *Server {
+	return &Server{
+		clients: make(map[string]*Client),
+		rooms:   make(map[string]*Room),
+	}
+}
+
+func (s *Server) handleConnections(w http.ResponseWriter, r *http.Request) {
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		http.Error(w, "username query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Error upgrading connection:", err)
+		return
+	}
+
+	client := &Client{
+		username: username,
+		conn:     conn,
+		send:     make(chan Message, sendBufferSize),
+		rooms:    make(map[string]bool),
+	}
+
+	s.mu.Lock()
+	_, taken := s.clients[username]
+	if !taken {
+		s.clients[username] = client
+	}
+	s.mu.Unlock()
+
+	if taken {
+		conn.WriteJSON(errorFrame("", ErrInvalid, "username already in use"))
+		conn.Close()
+		return
+	}
+
+	go client.writePump()
+	s.readPump(client)
+}
+
+func (s *Server) readPump(c *Client) {
+	defer s.disconnect(c)
+
+	for {
+		_, raw, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("Error reading message:", err)
+			}
+			return
+		}
+
+		var msg Message
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			s.sendTo(c, errorFrame("", ErrBadFrame, "frame is not valid JSON"))
+			continue
+		}
+		s.handleMessage(c, msg)
+	}
+}
+
+func (c *Client) writePump() {
+	defer c.conn.Close()
+	for msg := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.conn.WriteJSON(msg); err != nil {
+			log.Println("Error writing message:", err)
+			return
+		}
+	}
+	c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+}
+
+func (s *Server) handleMessage(c *Client, msg Message) {
+	switch msg.Type {
+	case TypeJoin:
+		s.join(c, msg)
+	case TypeLeave:
+		s.leave(c, msg)
+	case TypeRoomMessage:
+		s.roomMessage(c, msg)
+	case TypeDirect:
+		s.directMessage(c, msg)
+	case TypeTyping:
+		s.typing(c, msg)
+	case TypeHistory:
+		s.history(c, msg)
+	default:
+		s.sendTo(c, errorFrame(msg.ID, ErrUnknownType, "unknown message type "+msg.Type))
+	}
+}
+
+func (s *Server) join(c *Client, msg Message) {
+	if msg.Room == "" {
+		s.sendTo(c, errorFrame(msg.ID, ErrInvalid, "room is required"))
+		return
+	}
+
+	s.mu.Lock()
+	room, ok := s.rooms[msg.Room]
+	if !ok {
+		room = &Room{name: msg.Room, members: make(map[*Client]bool), history: NewRingBuffer(historyPerRoom)}
+		s.rooms[msg.Room] = room
+	}
+	room.members[c] = true
+	c.rooms[msg.Room] = true
+	s.mu.Unlock()
+
+	s.ack(c, msg.ID, 0)
+	s.broadcastRoom(msg.Room, Message{Type: TypeJoined, Room: msg.Room, Username: c.username, Time: now()}, nil)
+}
+
+func (s *Server) leave(c *Client, msg Message) {
+	if !s.removeFromRoom(c, msg.Room) {
+		s.sendTo(c, errorFrame(msg.ID, ErrNotInRoom, "not a member of "+msg.Room))
+		return
+	}
+	s.ack(c, msg.ID, 0)
+	s.broadcastRoom(msg.Room, Message{Type: TypeLeft, Room: msg.Room, Username: c.username, Time: now()}, nil)
+}
+
+func (s *Server) roomMessage(c *Client, msg Message) {
+	if !s.isMember(c, msg.Room) {
+		s.sendTo(c, errorFrame(msg.ID, ErrNotInRoom, "join "+msg.Room+" before sending to it"))
+		return
+	}
+
+	out := Message{
+		Type:     TypeRoomMessage,
+		Seq:      s.nextSeq.Add(1),
+		Room:     msg.Room,
+		Username: c.username,
+		Data:     msg.Data,
+		Time:     now(),
+	}
+
+	s.mu.Lock()
+	if room, ok := s.rooms[msg.Room]; ok {
+		room.history.Push(out)
+	}
+	s.mu.Unlock()
+
+	s.ack(c, msg.ID, out.Seq)
+	s.broadcastRoom(msg.Room, out, nil)
+}
+
+func (s *Server) directMessage(c *Client, msg Message) {
+	s.mu.Lock()
+	target, ok := s.clients[msg.To]
+	s.mu.Unlock()
+	if !ok {
+		s.sendTo(c, errorFrame(msg.ID, ErrNoSuchUser, "user "+msg.To+" is not connected"))
+		return
+	}
+
+	out := Message{
+		Type:     TypeDirect,
+		Seq:      s.nextSeq.Add(1),
+		To:       msg.To,
+		Username: c.username,
+		Data:     msg.Data,
+		Time:     now(),
+	}
+	s.sendTo(target, out)
+	s.ack(c, msg.ID, out.Seq)
+}
+
+// typing relays the indicator to the room without acknowledging or storing it.
+func (s *Server) typing(c *Client, msg Message) {
+	if !s.isMember(c, msg.Room) {
+		s.sendTo(c, errorFrame(msg.ID, ErrNotInRoom, "not a member of "+msg.Room))
+		return
+	}
+	s.broadcastRoom(msg.Room, Message{Type: TypeTyping, Room: msg.Room, Username: c.username, Data: msg.Data}, c)
+}
+
+func (s *Server) history(c *Client, msg Message) {
+	s.mu.Lock()
+	room, ok := s.rooms[msg.Room]
+	member := ok && room.members[c]
+	var messages []Message
+	if member {
+		messages = room.history.Last(msg.Limit)
+	}
+	s.mu.Unlock()
+
+	if !member {
+		s.sendTo(c, errorFrame(msg.ID, ErrNotInRoom, "join "+msg.Room+" to read its history"))
+		return
+	}
+	data, _ := json.Marshal(HistoryData{Messages: messages})
+	s.sendTo(c, Message{Type: TypeMessages, ID: msg.ID, Room: msg.Room, Data: data})
+}
+
+func (s *Server) isMember(c *Client, room string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.rooms[room]
+}
+
+func (s *Server) removeFromRoom(c *Client, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[name]
+	if !ok || !room.members[c] {
+		return false
+	}
+	delete(room.members, c)
+	delete(c.rooms, name)
+	if len(room.members) == 0 {
+		delete(s.rooms, name)
+	}
+	return true
+}
+
+func (s *Server) disconnect(c *Client) {
+	s.mu.Lock()
+	rooms := make([]string, 0, len(c.rooms))
+	for name := range c.rooms {
+		rooms = append(rooms, name)
+	}
+	s.mu.Unlock()
+
+	for _, name := range rooms {
+		if s.removeFromRoom(c, name) {
+			s.broadcastRoom(name, Message{Type: TypeLeft, Room: name, Username: c.username, Time: now()}, nil)
+		}
+	}
+
+	s.mu.Lock()
+	delete(s.clients, c.username)
+	close(c.send)
+	s.mu.Unlock()
+}
+
+// broadcastRoom sends msg to every member of room except skip.
+func (s *Server) broadcastRoom(room string, msg Message, skip *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.rooms[room]
+	if !ok {
+		return
+	}
+	for member := range r.members {
+		if member != skip {
+			s.sendLocked(member, msg)
+		}
+	}
+}
+
+func (s *Server) sendTo(c *Client, msg Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sendLocked(c, msg)
+}
+
+// sendLocked queues msg for c. A client whose buffer is full is too slow to
+// keep up, so the frame is dropped rather than blocking everyone else.
+func (s *Server) sendLocked(c *Client, msg Message) {
+	if s.clients[c.username] != c {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		log.Printf("Dropping %s frame for slow client %s", msg.Type, c.username)
+	}
+}
+
+func (s *Server) ack(c *Client, id string, seq uint64) {
+	if id == "" {
+		return
+	}
+	s.sendTo(c, Message{Type: TypeAck, ID: id, Seq: seq})
+}
+
+func errorFrame(id, code, text string) Message {
+	data, _ := json.Marshal(ErrorData{Code: code, Message: text})
+	return Message{Type: TypeError, ID: id, Data: data}
+}
+
+func main() {
+	server := NewServer()
+
+	http.HandleFunc("/ws", server.handleConnections)
+	log.Println("Server started on :8080")
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
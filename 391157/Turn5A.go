@@ -0,0 +1,403 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	lowPriority  = 1
+	highPriority = 5 // Higher priority value for urgent updates
+
+	// Time allowed to write a message to the peer.
+	writeWait = 10 * time.Second
+
+	// Maximum message size allowed from peer.
+	maxMessageSize = 512
+
+	defaultClientQueueSize = 256
+)
+
+// SlowConsumerPolicy decides what happens when a client's queue is full.
+type SlowConsumerPolicy int
+
+const (
+	// DropLowest discards the lowest-priority message, which may be the new one.
+	DropLowest SlowConsumerPolicy = iota
+	// Coalesce replaces a queued message with the same topic; without one it
+	// falls back to DropLowest.
+	Coalesce
+	// Disconnect closes the client.
+	Disconnect
+)
+
+var errSlowConsumer = errors.New("client queue full")
+
+// message struct with priority and data
+type message struct {
+	data     string
+	priority int
+	topic    string    // messages with the same topic can be coalesced
+	enqueued time.Time // when the message entered the client queue
+	seq      uint64    // keeps FIFO order within one priority
+	index    int
+}
+
+// messageHeap orders by priority, highest first, then by arrival.
+type messageHeap []*message
+
+func (h messageHeap) Len() int { return len(h) }
+func (h messageHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h messageHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *messageHeap) Push(x interface{}) {
+	m := x.(*message)
+	m.index = len(*h)
+	*h = append(*h, m)
+}
+func (h *messageHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
+
+// ClientMetrics is a snapshot of one client's delivery state.
+type ClientMetrics struct {
+	ID        string        `json:"id"`
+	Queued    int           `json:"queued"`
+	Delivered uint64        `json:"delivered"`
+	Dropped   uint64        `json:"dropped"`
+	Coalesced uint64        `json:"coalesced"`
+	Lag       time.Duration `json:"lag"`      // age of the oldest undelivered message
+	LastLag   time.Duration `json:"last_lag"` // queue time of the last delivered message
+	MaxLag    time.Duration `json:"max_lag"`
+}
+
+// clientQueue is a bounded priority queue owned by a single client.
+type clientQueue struct {
+	mu      sync.Mutex
+	ready   chan struct{}
+	items   messageHeap
+	limit   int
+	policy  SlowConsumerPolicy
+	nextSeq uint64
+	closed  bool
+
+	delivered, dropped, coalesced uint64
+	lastLag, maxLag               time.Duration
+}
+
+func newClientQueue(limit int, policy SlowConsumerPolicy) *clientQueue {
+	return &clientQueue{ready: make(chan struct{}, 1), limit: limit, policy: policy}
+}
+
+// push adds m, applying the slow-consumer policy when the queue is full.
+// It returns errSlowConsumer when the client should be disconnected.
+func (q *clientQueue) push(m message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+
+	m.enqueued = time.Now()
+	if q.policy == Coalesce && m.topic != "" {
+		for _, queued := range q.items {
+			if queued.topic == m.topic {
+				// Keep the original enqueue time and position so lag stays honest.
+				queued.data = m.data
+				if m.priority > queued.priority {
+					queued.priority = m.priority
+					heap.Fix(&q.items, queued.index)
+				}
+				q.coalesced++
+				return nil
+			}
+		}
+	}
+
+	if len(q.items) >= q.limit {
+		if q.policy == Disconnect {
+			return errSlowConsumer
+		}
+		lowest := q.lowest()
+		if lowest.priority >= m.priority {
+			q.dropped++
+			return nil
+		}
+		heap.Remove(&q.items, lowest.index)
+		q.dropped++
+	}
+
+	q.nextSeq++
+	m.seq = q.nextSeq
+	heap.Push(&q.items, &m)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// lowest returns the lowest-priority message, newest first among equals.
+func (q *clientQueue) lowest() *message {
+	low := q.items[0]
+	for _, m := range q.items[1:] {
+		if m.priority < low.priority || (m.priority == low.priority && m.seq > low.seq) {
+			low = m
+		}
+	}
+	return low
+}
+
+// pop blocks until a message is available or done is closed.
+func (q *clientQueue) pop(done <-chan struct{}) (*message, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			m := heap.Pop(&q.items).(*message)
+			q.mu.Unlock()
+			return m, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-done:
+			return nil, false
+		}
+	}
+}
+
+func (q *clientQueue) recordDelivery(m *message) {
+	lag := time.Since(m.enqueued)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.delivered++
+	q.lastLag = lag
+	if lag > q.maxLag {
+		q.maxLag = lag
+	}
+}
+
+func (q *clientQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.items = nil
+}
+
+func (q *clientQueue) metrics() ClientMetrics {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m := ClientMetrics{
+		Queued:    len(q.items),
+		Delivered: q.delivered,
+		Dropped:   q.dropped,
+		Coalesced: q.coalesced,
+		LastLag:   q.lastLag,
+		MaxLag:    q.maxLag,
+	}
+	for _, item := range q.items {
+		if lag := time.Since(item.enqueued); lag > m.Lag {
+			m.Lag = lag
+		}
+	}
+	return m
+}
+
+// messageWriter is the part of *websocket.Conn the write pump uses.
+type messageWriter interface {
+	WriteMessage(messageType int, data []byte) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+type client struct {
+	id    string
+	conn  messageWriter
+	queue *clientQueue
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (c *client) shutdown() {
+	c.once.Do(func() {
+		close(c.done)
+		c.queue.close()
+		c.conn.Close()
+	})
+}
+
+// writePump delivers queued messages to the connection, highest priority first.
+func (c *client) writePump() {
+	defer c.shutdown()
+	for {
+		m, ok := c.queue.pop(c.done)
+		if !ok {
+			return
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.conn.WriteMessage(websocket.TextMessage, []byte(m.data)); err != nil {
+			log.Printf("Client %s: write failed: %v", c.id, err)
+			return
+		}
+		c.queue.recordDelivery(m)
+	}
+}
+
+// Hub fans broadcast messages out to every client's own queue.
+type Hub struct {
+	mu        sync.Mutex
+	clients   map[string]*client
+	queueSize int
+	policy    SlowConsumerPolicy
+}
+
+func NewHub(queueSize int, policy SlowConsumerPolicy) *Hub {
+	return &Hub{clients: make(map[string]*client), queueSize: queueSize, policy: policy}
+}
+
+// Register adds a connection and starts its write pump.
+func (h *Hub) Register(id string, conn messageWriter) *client {
+	c := &client{
+		id:    id,
+		conn:  conn,
+		queue: newClientQueue(h.queueSize, h.policy),
+		done:  make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.clients[id] = c
+	log.Printf("New client connected. Total clients: %d\n", len(h.clients))
+	h.mu.Unlock()
+
+	go c.writePump()
+	return c
+}
+
+// Unregister removes a client and closes its connection.
+func (h *Hub) Unregister(c *client) {
+	h.mu.Lock()
+	if h.clients[c.id] == c {
+		delete(h.clients, c.id)
+		log.Printf("Client disconnected. Total clients: %d\n", len(h.clients))
+	}
+	h.mu.Unlock()
+	c.shutdown()
+}
+
+// Broadcast queues m for every client. A stalled client never blocks the others.
+func (h *Hub) Broadcast(m message) {
+	h.mu.Lock()
+	targets := make([]*client, 0, len(h.clients))
+	for _, c := range h.clients {
+		targets = append(targets, c)
+	}
+	h.mu.Unlock()
+
+	for _, c := range targets {
+		if err := c.queue.push(m); err != nil {
+			log.Printf("Client %s: %v, disconnecting", c.id, err)
+			h.Unregister(c)
+		}
+	}
+}
+
+// Metrics returns a snapshot for every connected client.
+func (h *Hub) Metrics() []ClientMetrics {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]ClientMetrics, 0, len(h.clients))
+	for id, c := range h.clients {
+		m := c.queue.metrics()
+		m.ID = id
+		out = append(out, m)
+	}
+	return out
+}
+
+// inboundMessage is the optional JSON form of a client message. Plain text
+// is accepted as well and treated like the previous turns did.
+type inboundMessage struct {
+	Topic    string `json:"topic"`
+	Data     string `json:"data"`
+	Priority int    `json:"priority"`
+}
+
+func parseMessage(raw []byte) message {
+	var in inboundMessage
+	if err := json.Unmarshal(raw, &in); err == nil && in.Data != "" {
+		if in.Priority == 0 {
+			in.Priority = lowPriority
+		}
+		return message{data: in.Data, priority: in.Priority, topic: in.Topic}
+	}
+	priority := lowPriority
+	if string(raw) == "urgent" {
+		priority = highPriority
+	}
+	return message{data: string(raw), priority: priority}
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true // Allow all origins for simplicity
+	},
+}
+
+// WebSocket connection handler
+func (h *Hub) handleConnections(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	c := h.Register(conn.RemoteAddr().String(), conn)
+	defer h.Unregister(c)
+
+	conn.SetReadLimit(maxMessageSize)
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("error: %v", err)
+			}
+			return
+		}
+		h.Broadcast(parseMessage(raw))
+	}
+}
+
+func (h *Hub) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Metrics())
+}
+
+func main() {
+	hub := NewHub(defaultClientQueueSize, DropLowest)
+
+	http.HandleFunc("/ws", hub.handleConnections)
+	http.HandleFunc("/metrics", hub.handleMetrics)
+	log.Println("WebSocket server is running on port 8080")
+	if err := http.ListenAndServe(":8080", nil); err != nil {
+		log.Fatal(err)
+	}
+}
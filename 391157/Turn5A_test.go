@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeConn records writes. While stalled, WriteMessage blocks until the
+// connection is released or closed, like a peer that stopped reading.
+type fakeConn struct {
+	mu      sync.Mutex
+	written []string
+	gate    chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newFakeConn(stalled bool) *fakeConn {
+	c := &fakeConn{gate: make(chan struct{}), closed: make(chan struct{})}
+	if !stalled {
+		close(c.gate)
+	}
+	return c
+}
+
+func (c *fakeConn) WriteMessage(_ int, data []byte) error {
+	select {
+	case <-c.gate:
+	case <-c.closed:
+		return errors.New("use of closed connection")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, string(data))
+	return nil
+}
+
+func (c *fakeConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *fakeConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakeConn) release() { close(c.gate) }
+
+func (c *fakeConn) messages() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.written...)
+}
+
+func (c *fakeConn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func metricsFor(h *Hub, id string) (ClientMetrics, bool) {
+	for _, m := range h.Metrics() {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return ClientMetrics{}, false
+}
+
+// stallClient registers a stalled client and waits until its write pump is
+// blocked on the first message, so later broadcasts stay in the queue.
+func stallClient(t *testing.T, h *Hub, id string) *fakeConn {
+	t.Helper()
+	conn := newFakeConn(true)
+	h.Register(id, conn)
+	h.Broadcast(message{data: "first", priority: lowPriority})
+	waitFor(t, func() bool {
+		m, _ := metricsFor(h, id)
+		return m.Queued == 0
+	})
+	return conn
+}
+
+// broadcastPaced sends one message and waits for the healthy client to
+// receive it, so only the stalled client ever falls behind.
+func broadcastPaced(t *testing.T, h *Hub, healthy *fakeConn, data string) {
+	t.Helper()
+	want := len(healthy.messages()) + 1
+	h.Broadcast(message{data: data, priority: lowPriority})
+	waitFor(t, func() bool { return len(healthy.messages()) == want })
+}
+
+func TestStalledClientDoesNotBlockOthers(t *testing.T) {
+	h := NewHub(4, DropLowest)
+	healthy := newFakeConn(false)
+	h.Register("healthy", healthy)
+	stalled := stallClient(t, h, "stalled")
+
+	for i := 0; i < 20; i++ {
+		broadcastPaced(t, h, healthy, strconv.Itoa(i))
+	}
+
+	m, _ := metricsFor(h, "stalled")
+	if m.Queued != 4 || m.Dropped != 16 {
+		t.Fatalf("stalled metrics = %+v, want 4 queued and 16 dropped", m)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if m, _ := metricsFor(h, "stalled"); m.Lag <= 0 {
+		t.Fatalf("stalled lag = %v, want > 0", m.Lag)
+	}
+	stalled.release()
+}
+
+func TestDropLowestKeepsHighPriority(t *testing.T) {
+	h := NewHub(3, DropLowest)
+	conn := stallClient(t, h, "c")
+
+	h.Broadcast(message{data: "low-1", priority: lowPriority})
+	h.Broadcast(message{data: "high-1", priority: highPriority})
+	h.Broadcast(message{data: "low-2", priority: lowPriority})
+	h.Broadcast(message{data: "high-2", priority: highPriority})
+	h.Broadcast(message{data: "low-3", priority: lowPriority})
+
+	conn.release()
+	waitFor(t, func() bool { return len(conn.messages()) == 4 })
+
+	want := []string{"first", "high-1", "high-2", "low-1"}
+	for i, got := range conn.messages() {
+		if got != want[i] {
+			t.Fatalf("delivered %v, want %v", conn.messages(), want)
+		}
+	}
+}
+
+func TestCoalesceReplacesSameTopic(t *testing.T) {
+	h := NewHub(2, Coalesce)
+	conn := stallClient(t, h, "c")
+
+	for i := 0; i < 10; i++ {
+		h.Broadcast(message{data: "price=" + strconv.Itoa(i), priority: lowPriority, topic: "price"})
+	}
+	h.Broadcast(message{data: "news", priority: lowPriority, topic: "news"})
+
+	m, _ := metricsFor(h, "c")
+	if m.Coalesced != 9 || m.Dropped != 0 {
+		t.Fatalf("metrics = %+v, want 9 coalesced and none dropped", m)
+	}
+
+	conn.release()
+	waitFor(t, func() bool { return len(conn.messages()) == 3 })
+	want := []string{"first", "price=9", "news"}
+	for i, got := range conn.messages() {
+		if got != want[i] {
+			t.Fatalf("delivered %v, want %v", conn.messages(), want)
+		}
+	}
+}
+
+func TestDisconnectPolicyDropsSlowClient(t *testing.T) {
+	h := NewHub(2, Disconnect)
+	healthy := newFakeConn(false)
+	h.Register("healthy", healthy)
+	stalled := stallClient(t, h, "stalled")
+
+	for i := 0; i < 3; i++ {
+		broadcastPaced(t, h, healthy, strconv.Itoa(i))
+	}
+
+	if !stalled.isClosed() {
+		t.Fatal("stalled client was not disconnected")
+	}
+	if _, ok := metricsFor(h, "stalled"); ok {
+		t.Fatal("stalled client is still registered")
+	}
+	if got := len(healthy.messages()); got != 4 {
+		t.Fatalf("healthy client got %d messages, want 4", got)
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	if m := parseMessage([]byte("urgent")); m.priority != highPriority {
+		t.Errorf("plain urgent priority = %d, want %d", m.priority, highPriority)
+	}
+	m := parseMessage([]byte(`{"topic":"t","data":"d","priority":3}`))
+	if m.topic != "t" || m.data != "d" || m.priority != 3 {
+		t.Errorf("parsed JSON message = %+v", m)
+	}
+}
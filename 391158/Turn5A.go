@@ -0,0 +1,529 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// Task represents a task to be executed by a worker
+type Task struct {
+	Priority   int             `json:"priority"` // Higher value means higher priority
+	Attempts   int             `json:"attempts"`
+	MaxRetries int             `json:"max_retries"`
+	Kind       string          `json:"kind"` // handler name; tasks without one cannot be persisted
+	Payload    json.RawMessage `json:"payload,omitempty"`
+	NotBefore  time.Time       `json:"not_before"` // earliest time a retry may run; zero means now
+
+	fn       func() error
+	enqueued time.Time
+	seq      uint64
+}
+
+// readyQueue orders tasks by priority, then by submission order.
+type readyQueue []*Task
+
+func (q readyQueue) Len() int { return len(q) }
+func (q readyQueue) Less(i, j int) bool {
+	if q[i].Priority != q[j].Priority {
+		return q[i].Priority > q[j].Priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q readyQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *readyQueue) Push(x interface{}) { *q = append(*q, x.(*Task)) }
+func (q *readyQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	t := old[n-1]
+	*q = old[:n-1]
+	return t
+}
+
+// delayQueue orders tasks waiting for a retry by the time they become due.
+type delayQueue []*Task
+
+func (q delayQueue) Len() int            { return len(q) }
+func (q delayQueue) Less(i, j int) bool  { return q[i].NotBefore.Before(q[j].NotBefore) }
+func (q delayQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *delayQueue) Push(x interface{}) { *q = append(*q, x.(*Task)) }
+func (q *delayQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	t := old[n-1]
+	*q = old[:n-1]
+	return t
+}
+
+// PoolConfig tunes the pool's scaling and retry behaviour.
+type PoolConfig struct {
+	MinWorkers int
+	MaxWorkers int
+	// TargetLatency is how long a task may wait in the queue before the pool
+	// adds a worker. Workers are removed once waits drop well below it.
+	TargetLatency time.Duration
+	ScaleInterval time.Duration
+	RetryBase     time.Duration // delay before the first retry, doubled each time
+	RetryMax      time.Duration
+}
+
+// Stats is a snapshot of the pool's state.
+type Stats struct {
+	Workers    int
+	Busy       int
+	Queued     int
+	Delayed    int
+	Submitted  uint64
+	Completed  uint64
+	Failed     uint64
+	Retried    uint64
+	OldestWait time.Duration // age of the oldest task still in the ready queue
+	AvgWait    time.Duration // moving average of queue wait for started tasks
+}
+
+var ErrPoolClosed = errors.New("worker pool is shut down")
+
+const waitSmoothing = 0.2 // weight of the newest sample in AvgWait
+
+// WorkerPool manages a pool of worker Goroutines
+type WorkerPool struct {
+	cfg      PoolConfig
+	handlers map[string]func(payload json.RawMessage) error
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	ready    readyQueue
+	delayed  delayQueue
+	nextSeq  uint64
+	workers  int
+	desired  int
+	busy     int
+	closed   bool // no new tasks accepted
+	halt     bool // workers exit as soon as they are idle
+	stopped  bool // shutdown has been closed
+	stats    Stats
+	wg       sync.WaitGroup
+	shutdown chan struct{}
+}
+
+// NewWorkerPool creates a pool with default latency and retry settings.
+func NewWorkerPool(minWorkers, maxWorkers int) *WorkerPool {
+	return NewWorkerPoolWithConfig(PoolConfig{MinWorkers: minWorkers, MaxWorkers: maxWorkers})
+}
+
+// NewWorkerPoolWithConfig creates a pool and starts its minimum workers.
+func NewWorkerPoolWithConfig(cfg PoolConfig) *WorkerPool {
+	if cfg.MinWorkers <= 0 {
+		cfg.MinWorkers = 1
+	}
+	if cfg.MaxWorkers < cfg.MinWorkers {
+		cfg.MaxWorkers = cfg.MinWorkers
+	}
+	if cfg.TargetLatency <= 0 {
+		cfg.TargetLatency = 100 * time.Millisecond
+	}
+	if cfg.ScaleInterval <= 0 {
+		cfg.ScaleInterval = 250 * time.Millisecond
+	}
+	if cfg.RetryBase <= 0 {
+		cfg.RetryBase = 100 * time.Millisecond
+	}
+	if cfg.RetryMax < cfg.RetryBase {
+		cfg.RetryMax = 10 * time.Second
+	}
+
+	pool := &WorkerPool{
+		cfg:      cfg,
+		handlers: make(map[string]func(json.RawMessage) error),
+		shutdown: make(chan struct{}),
+	}
+	pool.cond = sync.NewCond(&pool.mu)
+
+	pool.mu.Lock()
+	for i := 0; i < cfg.MinWorkers; i++ {
+		pool.startWorkerLocked()
+	}
+	pool.desired = cfg.MinWorkers
+	pool.mu.Unlock()
+
+	go pool.adjustWorkers()
+	go pool.promoteDelayed()
+	return pool
+}
+
+// RegisterHandler names a task function so tasks of that kind can be
+// persisted on shutdown and restored with LoadPending.
+func (pool *WorkerPool) RegisterHandler(kind string, fn func(payload json.RawMessage) error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.handlers[kind] = fn
+}
+
+// AddTask adds a task to the worker pool with retry capabilities and priority
+func (pool *WorkerPool) AddTask(priority int, maxRetries int, taskFunc func() error) error {
+	return pool.submit(&Task{Priority: priority, MaxRetries: maxRetries, fn: taskFunc})
+}
+
+// AddNamedTask adds a task run by the handler registered for kind.
+func (pool *WorkerPool) AddNamedTask(kind string, payload json.RawMessage, priority, maxRetries int) error {
+	return pool.submit(&Task{Priority: priority, MaxRetries: maxRetries, Kind: kind, Payload: payload})
+}
+
+func (pool *WorkerPool) submit(t *Task) error {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.closed {
+		return ErrPoolClosed
+	}
+	if t.fn == nil {
+		if _, ok := pool.handlers[t.Kind]; !ok {
+			return fmt.Errorf("no handler registered for task kind %q", t.Kind)
+		}
+	}
+	pool.stats.Submitted++
+	if !t.NotBefore.IsZero() && time.Now().Before(t.NotBefore) {
+		heap.Push(&pool.delayed, t)
+		return nil
+	}
+	pool.enqueueLocked(t)
+	return nil
+}
+
+func (pool *WorkerPool) enqueueLocked(t *Task) {
+	pool.nextSeq++
+	t.seq = pool.nextSeq
+	t.enqueued = time.Now()
+	heap.Push(&pool.ready, t)
+	pool.cond.Signal()
+}
+
+func (pool *WorkerPool) startWorkerLocked() {
+	pool.workers++
+	pool.wg.Add(1)
+	go pool.worker()
+}
+
+// worker processes tasks from the priority queue
+func (pool *WorkerPool) worker() {
+	defer pool.wg.Done()
+	for {
+		pool.mu.Lock()
+		for len(pool.ready) == 0 && !pool.halt && pool.workers <= pool.desired {
+			pool.cond.Wait()
+		}
+		if pool.halt || (len(pool.ready) == 0 && pool.workers > pool.desired) {
+			pool.workers--
+			pool.mu.Unlock()
+			return
+		}
+		task := heap.Pop(&pool.ready).(*Task)
+		pool.busy++
+		pool.recordWaitLocked(time.Since(task.enqueued))
+		run := task.fn
+		if run == nil {
+			handler := pool.handlers[task.Kind]
+			payload := task.Payload
+			run = func() error { return handler(payload) }
+		}
+		pool.mu.Unlock()
+
+		err := run()
+
+		pool.mu.Lock()
+		pool.busy--
+		pool.finishLocked(task, err)
+		pool.cond.Broadcast()
+		pool.mu.Unlock()
+	}
+}
+
+func (pool *WorkerPool) recordWaitLocked(wait time.Duration) {
+	if pool.stats.AvgWait == 0 {
+		pool.stats.AvgWait = wait
+		return
+	}
+	pool.stats.AvgWait = time.Duration(waitSmoothing*float64(wait) + (1-waitSmoothing)*float64(pool.stats.AvgWait))
+}
+
+// finishLocked records the outcome of a run and schedules a retry on failure.
+// The retry sits in the delayed queue so no worker is blocked while it waits.
+func (pool *WorkerPool) finishLocked(task *Task, err error) {
+	if err == nil {
+		pool.stats.Completed++
+		return
+	}
+	task.Attempts++
+	if task.Attempts > task.MaxRetries {
+		pool.stats.Failed++
+		fmt.Printf("Task failed after %d attempts: %v\n", task.Attempts, err)
+		return
+	}
+	pool.stats.Retried++
+	task.NotBefore = time.Now().Add(pool.backoff(task.Attempts))
+	heap.Push(&pool.delayed, task)
+}
+
+func (pool *WorkerPool) backoff(attempt int) time.Duration {
+	d := pool.cfg.RetryBase << (attempt - 1)
+	if d <= 0 || d > pool.cfg.RetryMax {
+		d = pool.cfg.RetryMax
+	}
+	// Jitter keeps retries of tasks that failed together from stampeding.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// promoteDelayed moves retries whose backoff has elapsed into the ready queue.
+func (pool *WorkerPool) promoteDelayed() {
+	timer := time.NewTimer(pool.cfg.RetryBase)
+	defer timer.Stop()
+	for {
+		select {
+		case <-pool.shutdown:
+			return
+		case <-timer.C:
+		}
+
+		pool.mu.Lock()
+		now := time.Now()
+		for len(pool.delayed) > 0 && !pool.delayed[0].NotBefore.After(now) {
+			pool.enqueueLocked(heap.Pop(&pool.delayed).(*Task))
+		}
+		next := pool.cfg.RetryBase
+		if len(pool.delayed) > 0 {
+			next = time.Until(pool.delayed[0].NotBefore)
+		}
+		pool.cond.Broadcast()
+		pool.mu.Unlock()
+		timer.Reset(next)
+	}
+}
+
+// adjustWorkers scales on queue latency: a task that has waited longer than
+// the target means workers are not keeping up, however short the queue is.
+func (pool *WorkerPool) adjustWorkers() {
+	ticker := time.NewTicker(pool.cfg.ScaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		pool.mu.Lock()
+		oldest := pool.oldestWaitLocked()
+		switch {
+		case oldest > pool.cfg.TargetLatency && pool.desired < pool.cfg.MaxWorkers:
+			pool.desired++
+			fmt.Printf("Adding worker: oldest task waited %v (target %v)\n", oldest, pool.cfg.TargetLatency)
+			pool.startWorkerLocked()
+		case len(pool.ready) == 0 && pool.busy < pool.desired &&
+			pool.stats.AvgWait < pool.cfg.TargetLatency/4 && pool.desired > pool.cfg.MinWorkers:
+			pool.desired--
+			fmt.Println("Removing worker: queue is keeping up")
+			pool.cond.Broadcast()
+		}
+		pool.mu.Unlock()
+	}
+}
+
+func (pool *WorkerPool) oldestWaitLocked() time.Duration {
+	var oldest time.Duration
+	for _, t := range pool.ready {
+		if wait := time.Since(t.enqueued); wait > oldest {
+			oldest = wait
+		}
+	}
+	return oldest
+}
+
+// Stats returns a snapshot of the pool.
+func (pool *WorkerPool) Stats() Stats {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	s := pool.stats
+	s.Workers = pool.workers
+	s.Busy = pool.busy
+	s.Queued = len(pool.ready)
+	s.Delayed = len(pool.delayed)
+	s.OldestWait = pool.oldestWaitLocked()
+	return s
+}
+
+// Shutdown stops accepting tasks and waits until every queued task,
+// including pending retries, has finished. Calling it again is a no-op.
+func (pool *WorkerPool) Shutdown() {
+	pool.mu.Lock()
+	if pool.stopped {
+		pool.mu.Unlock()
+		return
+	}
+	pool.closed = true
+	for len(pool.ready) > 0 || len(pool.delayed) > 0 || pool.busy > 0 {
+		pool.cond.Wait()
+	}
+	pool.stopLocked()
+}
+
+// ShutdownAndPersist stops accepting tasks, lets running tasks finish and
+// writes everything still queued to path. It returns the number of tasks
+// written; tasks added with AddTask have no handler name and are reported
+// in the error instead. Once the pool is stopped it returns ErrPoolClosed
+// and leaves path alone.
+func (pool *WorkerPool) ShutdownAndPersist(path string) (int, error) {
+	pool.mu.Lock()
+	if pool.stopped {
+		pool.mu.Unlock()
+		return 0, ErrPoolClosed
+	}
+	pool.closed = true
+	pool.halt = true
+	pool.cond.Broadcast()
+	for pool.busy > 0 {
+		pool.cond.Wait()
+	}
+	pending := make([]*Task, 0, len(pool.ready)+len(pool.delayed))
+	pending = append(pending, pool.ready...)
+	pending = append(pending, pool.delayed...)
+	pool.ready, pool.delayed = nil, nil
+	pool.stopLocked()
+
+	var persist []*Task
+	unnamed := 0
+	for _, t := range pending {
+		if t.Kind == "" {
+			unnamed++
+			continue
+		}
+		persist = append(persist, t)
+	}
+
+	if err := writeFileAtomic(path, persist); err != nil {
+		return 0, err
+	}
+	if unnamed > 0 {
+		return len(persist), fmt.Errorf("%d anonymous tasks could not be persisted", unnamed)
+	}
+	return len(persist), nil
+}
+
+// stopLocked releases the workers and background goroutines. It unlocks
+// pool.mu. Concurrent shutdowns may both get here; only the first closes.
+func (pool *WorkerPool) stopLocked() {
+	pool.halt = true
+	pool.cond.Broadcast()
+	first := !pool.stopped
+	pool.stopped = true
+	pool.mu.Unlock()
+	if first {
+		close(pool.shutdown)
+	}
+	pool.wg.Wait()
+}
+
+func writeFileAtomic(path string, tasks []*Task) error {
+	data, err := json.MarshalIndent(tasks, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadPending re-submits tasks saved by ShutdownAndPersist and removes the
+// file. A missing file is not an error.
+func (pool *WorkerPool) LoadPending(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var tasks []*Task
+	if err := json.Unmarshal(data, &tasks); err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, t := range tasks {
+		if err := pool.submit(t); err != nil {
+			return i, err
+		}
+	}
+	return len(tasks), os.Remove(path)
+}
+
+const pendingFile = "pending_tasks.json"
+
+func main() {
+	pool := NewWorkerPoolWithConfig(PoolConfig{
+		MinWorkers:    2,
+		MaxWorkers:    10,
+		TargetLatency: 200 * time.Millisecond,
+	})
+
+	pool.RegisterHandler("simulate", func(payload json.RawMessage) error {
+		var job struct {
+			Priority int           `json:"priority"`
+			Duration time.Duration `json:"duration"`
+		}
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return err
+		}
+		time.Sleep(job.Duration)
+		if rand.Intn(2) == 0 { // Simulate random failure
+			return fmt.Errorf("simulated error")
+		}
+		fmt.Printf("Task with priority %d completed successfully.\n", job.Priority)
+		return nil
+	})
+
+	if n, err := pool.LoadPending(pendingFile); err != nil {
+		fmt.Println("Restoring pending tasks:", err)
+	} else if n > 0 {
+		fmt.Printf("Restored %d tasks from %s\n", n, pendingFile)
+	}
+
+	for i := 0; i < 50; i++ {
+		priority := rand.Intn(10) + 1
+		payload, _ := json.Marshal(map[string]interface{}{
+			"priority": priority,
+			"duration": time.Duration(rand.Intn(500)) * time.Millisecond,
+		})
+		if err := pool.AddNamedTask("simulate", payload, priority, 3); err != nil {
+			fmt.Println("Adding task:", err)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(time.Second)
+		fmt.Printf("Stats: %+v\n", pool.Stats())
+	}
+
+	// Whatever has not run yet is saved and picked up on the next start.
+	n, err := pool.ShutdownAndPersist(pendingFile)
+	if err != nil {
+		fmt.Println("Persisting pending tasks:", err)
+	}
+	fmt.Printf("Persisted %d pending tasks to %s\n", n, pendingFile)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	_ "embed"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/websocket"
+)
+
+//go:embed turn5openapi.yaml
+var openapiSpec []byte
+
+//go:embed turn5asyncapi.yaml
+var asyncapiSpec []byte
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// Message is the frame format described by ChatMessage in turn5asyncapi.yaml.
+type Message struct {
+	From    string `json:"from"`
+	Message string `json:"message"`
+}
+
+// Manage WebSocket connections
+type Chat struct {
+	Clients map[*websocket.Conn]string // map websocket connection to username
+	Send    chan Message               // Channel for sending messages
+	Lock    sync.RWMutex
+}
+
+func NewChat() *Chat {
+	return &Chat{
+		Clients: make(map[*websocket.Conn]string),
+		Send:    make(chan Message),
+	}
+}
+
+// Run broadcasts messages from Send. It is the only goroutine that writes to
+// client connections, so writes never race.
+func (c *Chat) Run() {
+	for msg := range c.Send {
+		c.Broadcast(msg)
+	}
+}
+
+func (c *Chat) Broadcast(msg Message) {
+	var failed []*websocket.Conn
+
+	c.Lock.RLock()
+	for conn := range c.Clients {
+		if err := conn.WriteJSON(msg); err != nil {
+			log.Println("Error writing to client:", err)
+			failed = append(failed, conn)
+		}
+	}
+	c.Lock.RUnlock()
+
+	for _, conn := range failed {
+		c.Remove(conn)
+	}
+}
+
+func (c *Chat) Add(conn *websocket.Conn, username string) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	c.Clients[conn] = username
+	log.Println("Client", username, "connected")
+}
+
+func (c *Chat) Remove(conn *websocket.Conn) {
+	c.Lock.Lock()
+	defer c.Lock.Unlock()
+
+	if username, ok := c.Clients[conn]; ok {
+		delete(c.Clients, conn)
+		conn.Close()
+		log.Println("Client", username, "disconnected")
+	}
+}
+
+// authenticate validates the token and returns its subject as the username.
+func authenticate(jwtKey []byte, tokenStr string) (string, error) {
+	claims := &jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return jwtKey, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid {
+		return "", jwt.ErrSignatureInvalid
+	}
+	if claims.Subject == "" {
+		return "anonymous", nil
+	}
+	return claims.Subject, nil
+}
+
+// chatHandler implements GET /chat. Its documented responses are 101 on a
+// successful upgrade, 401 for a missing or invalid token and 400 (written by
+// the upgrader) for a request that is not a WebSocket handshake.
+func chatHandler(chat *Chat, jwtKey []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, err := authenticate(jwtKey, r.URL.Query().Get("token"))
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Println("Upgrade error:", err)
+			return
+		}
+
+		chat.Add(conn, username)
+		defer chat.Remove(conn)
+
+		for {
+			var message Message
+			if err := conn.ReadJSON(&message); err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+					log.Println("Read error:", err)
+				}
+				return
+			}
+			if message.Message == "" {
+				log.Println("Ignoring empty message from", username)
+				continue
+			}
+
+			message.From = username
+			chat.Send <- message
+		}
+	}
+}
+
+func serveSpec(spec []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(spec)
+	}
+}
+
+// newServer wires the chat endpoint and the two spec documents.
+func newServer(chat *Chat, jwtKey []byte) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat", chatHandler(chat, jwtKey))
+	mux.HandleFunc("/openapi.yaml", serveSpec(openapiSpec))
+	mux.HandleFunc("/asyncapi.yaml", serveSpec(asyncapiSpec))
+	return mux
+}
+
+func main() {
+	secret, exists := os.LookupEnv("JWT_SECRET")
+	if !exists {
+		log.Fatal("JWT_SECRET environment variable is required")
+	}
+
+	chat := NewChat()
+	go chat.Run()
+
+	log.Println("Server running on :8080")
+	log.Fatal(http.ListenAndServe(":8080", newServer(chat, []byte(secret))))
+}
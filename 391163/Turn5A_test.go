@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/websocket"
+	"gopkg.in/yaml.v3"
+)
+
+var testKey = []byte("test_secret_key")
+
+// spec is a parsed YAML document with support for local $ref lookups.
+type spec map[string]interface{}
+
+func loadSpec(t *testing.T, path string) spec {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Decoding into a plain map keeps nested nodes as map[string]interface{};
+	// decoding into spec directly would give them the spec type instead.
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("parsing %s: %v", path, err)
+	}
+	return spec(doc)
+}
+
+// lookup follows a path of keys through nested maps.
+func (s spec) lookup(keys ...string) (map[string]interface{}, bool) {
+	node := map[string]interface{}(s)
+	for _, k := range keys {
+		next, ok := node[k].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		node = next
+	}
+	return node, true
+}
+
+// resolve replaces a {"$ref": "#/a/b"} node with its target.
+func (s spec) resolve(node map[string]interface{}) map[string]interface{} {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		target, found := s.lookup(strings.Split(strings.TrimPrefix(ref, "#/"), "/")...)
+		if !found {
+			panic("unresolvable $ref " + ref)
+		}
+		node = target
+	}
+}
+
+// messageSchema returns the payload schema of a channel operation
+// ("publish" for client frames, "subscribe" for server frames).
+func (s spec) messageSchema(t *testing.T, channel, operation string) map[string]interface{} {
+	t.Helper()
+	op, ok := s.lookup("channels", channel, operation)
+	if !ok {
+		t.Fatalf("asyncapi: no %s operation on %s", operation, channel)
+	}
+	msg := s.resolve(op["message"].(map[string]interface{}))
+	return s.resolve(msg["payload"].(map[string]interface{}))
+}
+
+// validate checks value against the subset of JSON Schema used by the spec.
+func (s spec) validate(schema map[string]interface{}, value interface{}, path string) []string {
+	schema = s.resolve(schema)
+	var errs []string
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: want object, got %T", path, value)}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		if req, ok := schema["required"].([]interface{}); ok {
+			for _, name := range req {
+				if _, present := obj[name.(string)]; !present {
+					errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+				}
+			}
+		}
+		for name, v := range obj {
+			propSchema, known := props[name].(map[string]interface{})
+			if !known {
+				if schema["additionalProperties"] == false {
+					errs = append(errs, fmt.Sprintf("%s: undocumented property %q", path, name))
+				}
+				continue
+			}
+			errs = append(errs, s.validate(propSchema, v, path+"."+name)...)
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want string, got %T", path, value)}
+		}
+		if min, ok := schema["minLength"].(int); ok && len(str) < min {
+			errs = append(errs, fmt.Sprintf("%s: shorter than minLength %d", path, min))
+		}
+		if max, ok := schema["maxLength"].(int); ok && len(str) > max {
+			errs = append(errs, fmt.Sprintf("%s: longer than maxLength %d", path, max))
+		}
+	case "integer", "number":
+		if _, ok := value.(float64); !ok {
+			errs = append(errs, fmt.Sprintf("%s: want %s, got %T", path, schema["type"], value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%s: want boolean, got %T", path, value))
+		}
+	}
+	return errs
+}
+
+// conformance tracks which documented response codes a test run produced.
+type conformance struct {
+	documented   map[string]map[string]bool // "GET /chat" -> code -> exercised
+	undocumented []string
+}
+
+func newConformance(t *testing.T, openapi spec) *conformance {
+	t.Helper()
+	c := &conformance{documented: make(map[string]map[string]bool)}
+	paths, ok := openapi.lookup("paths")
+	if !ok {
+		t.Fatal("openapi: no paths")
+	}
+	for path, item := range paths {
+		for method, op := range item.(map[string]interface{}) {
+			responses, ok := op.(map[string]interface{})["responses"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			key := strings.ToUpper(method) + " " + path
+			c.documented[key] = make(map[string]bool)
+			for code := range responses {
+				c.documented[key][code] = false
+			}
+		}
+	}
+	return c
+}
+
+func (c *conformance) record(method, path string, status int) {
+	key := method + " " + path
+	code := fmt.Sprint(status)
+	codes, ok := c.documented[key]
+	if _, documented := codes[code]; !ok || !documented {
+		c.undocumented = append(c.undocumented, key+" "+code)
+		return
+	}
+	codes[code] = true
+}
+
+// report lists every documented response and whether it was exercised.
+func (c *conformance) report() (string, bool) {
+	var lines []string
+	complete := true
+	for op, codes := range c.documented {
+		for code, seen := range codes {
+			status := "exercised"
+			if !seen {
+				status = "NOT exercised"
+				complete = false
+			}
+			lines = append(lines, fmt.Sprintf("%-22s %s  %s", op, code, status))
+		}
+	}
+	sort.Strings(lines)
+	for _, u := range c.undocumented {
+		lines = append(lines, fmt.Sprintf("%-22s UNDOCUMENTED", u))
+		complete = false
+	}
+	return strings.Join(lines, "\n"), complete
+}
+
+// contractClient validates every frame it sends or receives.
+type contractClient struct {
+	t        *testing.T
+	doc      spec
+	inbound  map[string]interface{}
+	outbound map[string]interface{}
+	conn     *websocket.Conn
+}
+
+func (c *contractClient) send(frame interface{}) {
+	c.t.Helper()
+	raw, err := json.Marshal(frame)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.check("inbound", c.inbound, raw)
+	if err := c.conn.WriteMessage(websocket.TextMessage, raw); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *contractClient) receive() Message {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, raw, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.check("outbound", c.outbound, raw)
+	var msg Message
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+func (c *contractClient) check(direction string, schema map[string]interface{}, raw []byte) {
+	c.t.Helper()
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		c.t.Fatalf("%s frame is not JSON: %s", direction, raw)
+	}
+	for _, e := range c.doc.validate(schema, value, "$") {
+		c.t.Errorf("%s frame %s violates asyncapi schema: %s", direction, raw, e)
+	}
+}
+
+func signedToken(t *testing.T, subject string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: subject}).SignedString(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestChatContract(t *testing.T) {
+	openapi := loadSpec(t, "turn5openapi.yaml")
+	asyncapi := loadSpec(t, "turn5asyncapi.yaml")
+	inbound := asyncapi.messageSchema(t, "/chat", "publish")
+	outbound := asyncapi.messageSchema(t, "/chat", "subscribe")
+	conf := newConformance(t, openapi)
+
+	chat := NewChat()
+	go chat.Run()
+	srv := httptest.NewServer(newServer(chat, testKey))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/chat"
+
+	dial := func(token string) (*websocket.Conn, int) {
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL+"?token="+token, nil)
+		if resp == nil {
+			t.Fatalf("dial: %v", err)
+		}
+		conf.record(http.MethodGet, "/chat", resp.StatusCode)
+		return conn, resp.StatusCode
+	}
+
+	t.Run("spec documents are served", func(t *testing.T) {
+		for path, want := range map[string][]byte{"/openapi.yaml": openapiSpec, "/asyncapi.yaml": asyncapiSpec} {
+			resp, err := http.Get(srv.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			conf.record(http.MethodGet, path, resp.StatusCode)
+			if string(body) != string(want) {
+				t.Errorf("%s served a different document", path)
+			}
+		}
+	})
+
+	t.Run("missing or invalid token is rejected", func(t *testing.T) {
+		for _, token := range []string{"", "not-a-jwt"} {
+			if _, status := dial(token); status != http.StatusUnauthorized {
+				t.Errorf("token %q: status %d, want 401", token, status)
+			}
+		}
+	})
+
+	t.Run("plain HTTP request is a bad handshake", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/chat?token=" + signedToken(t, "alice"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		conf.record(http.MethodGet, "/chat", resp.StatusCode)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", resp.StatusCode)
+		}
+	})
+
+	t.Run("frames match the asyncapi schemas", func(t *testing.T) {
+		var clients []*contractClient
+		for _, name := range []string{"alice", "bob"} {
+			conn, status := dial(signedToken(t, name))
+			if status != http.StatusSwitchingProtocols {
+				t.Fatalf("%s: status %d, want 101", name, status)
+			}
+			defer conn.Close()
+			clients = append(clients, &contractClient{t: t, doc: asyncapi, inbound: inbound, outbound: outbound, conn: conn})
+		}
+		waitForClients(t, chat, len(clients))
+
+		clients[0].send(map[string]string{"message": "Hello, Everyone!"})
+		for _, c := range clients {
+			if msg := c.receive(); msg.From != "alice" || msg.Message != "Hello, Everyone!" {
+				t.Errorf("received %+v", msg)
+			}
+		}
+	})
+
+	t.Run("Message struct matches the outbound schema", func(t *testing.T) {
+		documented := map[string]bool{}
+		for name := range outbound["properties"].(map[string]interface{}) {
+			documented[name] = true
+		}
+		typ := reflect.TypeOf(Message{})
+		for i := 0; i < typ.NumField(); i++ {
+			name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+			if !documented[name] {
+				t.Errorf("Message.%s is sent as %q, which the asyncapi spec does not document", typ.Field(i).Name, name)
+			}
+			delete(documented, name)
+		}
+		for name := range documented {
+			t.Errorf("asyncapi property %q has no Message field", name)
+		}
+	})
+
+	report, complete := conf.report()
+	t.Logf("conformance report:\n%s", report)
+	if path := os.Getenv("CONFORMANCE_REPORT"); path != "" {
+		if err := os.WriteFile(path, []byte(report+"\n"), 0o644); err != nil {
+			t.Error(err)
+		}
+	}
+	if !complete {
+		t.Error("documented responses were not all exercised, or undocumented ones were returned")
+	}
+}
+
+// TestSchemaValidatorDetectsDrift makes sure the harness would actually fail
+// if the server changed its frame format.
+func TestSchemaValidatorDetectsDrift(t *testing.T) {
+	asyncapi := loadSpec(t, "turn5asyncapi.yaml")
+	outbound := asyncapi.messageSchema(t, "/chat", "subscribe")
+
+	cases := map[string]string{
+		"renamed field": `{"user":"alice","message":"hi"}`,
+		"extra field":   `{"from":"alice","message":"hi","ts":1}`,
+		"wrong type":    `{"from":"alice","message":42}`,
+		"empty message": `{"from":"alice","message":""}`,
+		"not an object": `["alice","hi"]`,
+	}
+	for name, raw := range cases {
+		var v interface{}
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			t.Fatal(err)
+		}
+		if errs := asyncapi.validate(outbound, v, "$"); len(errs) == 0 {
+			t.Errorf("%s: %s passed validation", name, raw)
+		}
+	}
+
+	var ok interface{}
+	json.Unmarshal([]byte(`{"from":"alice","message":"hi"}`), &ok)
+	if errs := asyncapi.validate(outbound, ok, "$"); len(errs) != 0 {
+		t.Errorf("valid frame rejected: %v", errs)
+	}
+}
+
+func waitForClients(t *testing.T, chat *Chat, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		chat.Lock.RLock()
+		got := len(chat.Clients)
+		chat.Lock.RUnlock()
+		if got >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients registered, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+const (
+	defaultRoom    = "general"
+	maxMessageLen  = 1000 // characters accepted per chat message
+	sendBufferSize = 64
+	writeWait      = 10 * time.Second
+	auditFile      = "moderation_audit.log"
+)
+
+// maxFrameSize is the bytes accepted per WebSocket frame. It fits the longest
+// valid message even with every character escaped as a surrogate pair (12
+// bytes), so an overlong message gets an error frame, not a closed connection.
+const (
+	maxEnvelope  = 512 // the frame's other fields and JSON syntax
+	maxFrameSize = 12*maxMessageLen + maxEnvelope
+)
+
+// Frame types
+const (
+	TypeJoin    = "join"
+	TypeLeave   = "leave"
+	TypeMessage = "message"
+	TypeSystem  = "system"
+	TypeError   = "error"
+)
+
+// Message is the frame format in both directions.
+type Message struct {
+	Type    string `json:"type"`
+	Room    string `json:"room,omitempty"`
+	From    string `json:"from,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Claims adds a role to the standard JWT claims. Only "admin" may moderate.
+type Claims struct {
+	jwt.StandardClaims
+	Role string `json:"role"`
+}
+
+const roleAdmin = "admin"
+
+func authenticate(jwtKey []byte, tokenStr string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return jwtKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.Subject == "" {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return claims, nil
+}
+
+// MessageFilter inspects a message before it is broadcast. It may rewrite
+// msg.Message in place or return an error to reject the message.
+type MessageFilter func(msg *Message) error
+
+// NewRegexFilter masks every match of the given patterns with asterisks.
+func NewRegexFilter(patterns ...string) (MessageFilter, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("filter pattern %q: %w", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return func(msg *Message) error {
+		for _, re := range compiled {
+			msg.Message = re.ReplaceAllStringFunc(msg.Message, func(m string) string {
+				return strings.Repeat("*", utf8.RuneCountInString(m))
+			})
+		}
+		return nil
+	}, nil
+}
+
+// RateLimiter is a token bucket: Burst messages at once, refilled at Rate per second.
+// Buckets idle long enough to have refilled are dropped, since a fresh
+// bucket behaves the same.
+type RateLimiter struct {
+	Rate  float64
+	Burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate, burst float64) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token for user if one is available.
+func (rl *RateLimiter) Allow(user string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	rl.sweepLocked(now)
+	b, ok := rl.buckets[user]
+	if !ok {
+		b = &bucket{tokens: rl.Burst, last: now}
+		rl.buckets[user] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rl.Rate
+	if b.tokens > rl.Burst {
+		b.tokens = rl.Burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweepLocked drops full buckets, at most once per refill period.
+func (rl *RateLimiter) sweepLocked(now time.Time) {
+	if rl.Rate <= 0 {
+		return
+	}
+	refill := time.Duration(rl.Burst / rl.Rate * float64(time.Second))
+	if now.Sub(rl.lastSweep) < refill {
+		return
+	}
+	rl.lastSweep = now
+	for user, b := range rl.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(rl.buckets, user)
+		}
+	}
+}
+
+// AuditEntry is one line of the moderation audit file.
+type AuditEntry struct {
+	Time     time.Time     `json:"time"`
+	Actor    string        `json:"actor"`
+	Action   string        `json:"action"`
+	Target   string        `json:"target"`
+	Reason   string        `json:"reason,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// AuditLog appends moderation actions to a JSON-lines file.
+type AuditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{file: f}, nil
+}
+
+// Record writes e and syncs it to disk before returning.
+func (a *AuditLog) Record(e AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// Entries reads back every recorded action, oldest first.
+func (a *AuditLog) Entries() ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(a.file)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("corrupt audit line %q: %w", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func (a *AuditLog) Close() error { return a.file.Close() }
+
+// Moderation actions
+const (
+	ActionMute   = "mute"
+	ActionUnmute = "unmute"
+	ActionKick   = "kick"
+	ActionBan    = "ban"
+	ActionUnban  = "unban"
+)
+
+// Client is one WebSocket connection. Only its write pump writes to conn.
+type Client struct {
+	username string
+	conn     *websocket.Conn
+	send     chan Message
+	rooms    map[string]bool
+}
+
+func (c *Client) writePump() {
+	defer c.conn.Close()
+	for msg := range c.send {
+		c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, ""))
+}
+
+// A Hub maintains the active connections, their rooms and the moderation state.
+type Hub struct {
+	jwtKey  []byte
+	limiter *RateLimiter
+	filters []MessageFilter
+	audit   *AuditLog
+
+	broadcast chan Message
+
+	mu      sync.Mutex
+	clients map[*Client]bool
+	rooms   map[string]map[*Client]bool
+	muted   map[string]time.Time // username -> muted until
+	banned  map[string]time.Time // username -> banned until, zero means forever
+}
+
+// NewHub creates a hub and restores mutes and bans from the audit log.
+func NewHub(jwtKey []byte, limiter *RateLimiter, audit *AuditLog, filters ...MessageFilter) (*Hub, error) {
+	h := &Hub{
+		jwtKey:    jwtKey,
+		limiter:   limiter,
+		filters:   filters,
+		audit:     audit,
+		broadcast: make(chan Message, 256),
+		clients:   make(map[*Client]bool),
+		rooms:     make(map[string]map[*Client]bool),
+		muted:     make(map[string]time.Time),
+		banned:    make(map[string]time.Time),
+	}
+	entries, err := audit.Entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		h.applyLocked(e)
+	}
+	return h, nil
+}
+
+// Run delivers broadcast messages to the members of their room.
+func (h *Hub) Run() {
+	for msg := range h.broadcast {
+		h.mu.Lock()
+		for c := range h.rooms[msg.Room] {
+			h.sendLocked(c, msg)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// sendLocked queues msg without blocking; a client that cannot keep up is dropped.
+func (h *Hub) sendLocked(c *Client, msg Message) {
+	if !h.clients[c] {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		log.Println("Client", c.username, "is too slow, disconnecting")
+		h.removeLocked(c)
+	}
+}
+
+func (h *Hub) sendTo(c *Client, msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sendLocked(c, msg)
+}
+
+func (h *Hub) removeLocked(c *Client) {
+	if !h.clients[c] {
+		return
+	}
+	delete(h.clients, c)
+	for room := range c.rooms {
+		delete(h.rooms[room], c)
+		if len(h.rooms[room]) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+	close(c.send)
+}
+
+func (h *Hub) bannedLocked(user string) bool {
+	until, ok := h.banned[user]
+	if !ok {
+		return false
+	}
+	if !until.IsZero() && time.Now().After(until) {
+		delete(h.banned, user)
+		return false
+	}
+	return true
+}
+
+func (h *Hub) mutedLocked(user string) bool {
+	until, ok := h.muted[user]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(h.muted, user)
+		return false
+	}
+	return true
+}
+
+// ChatHandler handles WebSocket connections and communication
+func (h *Hub) chatHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := authenticate(h.jwtKey, r.URL.Query().Get("token"))
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.mu.Lock()
+	banned := h.bannedLocked(claims.Subject)
+	h.mu.Unlock()
+	if banned {
+		http.Error(w, "Forbidden: you are banned", http.StatusForbidden)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	conn.SetReadLimit(maxFrameSize)
+
+	c := &Client{username: claims.Subject, conn: conn, send: make(chan Message, sendBufferSize), rooms: make(map[string]bool)}
+	h.mu.Lock()
+	h.clients[c] = true
+	h.mu.Unlock()
+	go c.writePump()
+	h.join(c, defaultRoom)
+
+	defer func() {
+		h.mu.Lock()
+		h.removeLocked(c)
+		h.mu.Unlock()
+	}()
+
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("Read error:", err)
+			}
+			return
+		}
+		h.handle(c, msg)
+	}
+}
+
+func (h *Hub) join(c *Client, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.clients[c] {
+		return
+	}
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*Client]bool)
+	}
+	h.rooms[room][c] = true
+	c.rooms[room] = true
+	h.sendLocked(c, Message{Type: TypeSystem, Room: room, Message: "joined " + room})
+}
+
+func (h *Hub) leave(c *Client, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.rooms[room], c)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+	}
+	delete(c.rooms, room)
+}
+
+func (h *Hub) handle(c *Client, msg Message) {
+	if msg.Room == "" {
+		msg.Room = defaultRoom
+	}
+	switch msg.Type {
+	case TypeJoin:
+		h.join(c, msg.Room)
+	case TypeLeave:
+		h.leave(c, msg.Room)
+	case TypeMessage, "":
+		if err := h.accept(c, &msg); err != nil {
+			h.sendTo(c, Message{Type: TypeError, Room: msg.Room, Message: err.Error()})
+			return
+		}
+		h.broadcast <- msg
+	default:
+		h.sendTo(c, Message{Type: TypeError, Message: "unknown message type " + msg.Type})
+	}
+}
+
+// accept applies membership, mute, size, rate and filter checks in that order.
+func (h *Hub) accept(c *Client, msg *Message) error {
+	h.mu.Lock()
+	member := c.rooms[msg.Room]
+	muted := h.mutedLocked(c.username)
+	h.mu.Unlock()
+
+	switch {
+	case !member:
+		return errors.New("join " + msg.Room + " before sending to it")
+	case muted:
+		return errors.New("you are muted")
+	case msg.Message == "":
+		return errors.New("message is empty")
+	case len([]rune(msg.Message)) > maxMessageLen:
+		return fmt.Errorf("message exceeds %d characters", maxMessageLen)
+	case !h.limiter.Allow(c.username):
+		return errors.New("rate limit exceeded, slow down")
+	}
+
+	msg.Type = TypeMessage
+	msg.From = c.username
+	for _, filter := range h.filters {
+		if err := filter(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ModerationRequest is the body of POST /admin/{mute,unmute,kick,ban,unban}.
+type ModerationRequest struct {
+	User     string `json:"user"`
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"` // e.g. "10m"; required for mute, optional for ban
+}
+
+// moderationHandler performs one action. The caller must present an admin JWT
+// in the Authorization header.
+func (h *Hub) moderationHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		claims, err := authenticate(h.jwtKey, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if claims.Role != roleAdmin {
+			http.Error(w, "Forbidden: admin role required", http.StatusForbidden)
+			return
+		}
+
+		var req ModerationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.User == "" {
+			http.Error(w, "Bad Request: user is required", http.StatusBadRequest)
+			return
+		}
+		entry := AuditEntry{Time: time.Now(), Actor: claims.Subject, Action: action, Target: req.User, Reason: req.Reason}
+		if req.Duration != "" {
+			if entry.Duration, err = time.ParseDuration(req.Duration); err != nil || entry.Duration <= 0 {
+				http.Error(w, "Bad Request: invalid duration", http.StatusBadRequest)
+				return
+			}
+		}
+		if action == ActionMute && entry.Duration == 0 {
+			http.Error(w, "Bad Request: mute requires a duration", http.StatusBadRequest)
+			return
+		}
+
+		// The action is only applied once it is safely in the audit file.
+		if err := h.audit.Record(entry); err != nil {
+			log.Println("Audit write failed:", err)
+			http.Error(w, "Could not record moderation action", http.StatusInternalServerError)
+			return
+		}
+		h.mu.Lock()
+		h.applyLocked(entry)
+		h.mu.Unlock()
+		log.Printf("%s %s %s (%s)", entry.Actor, action, entry.Target, entry.Reason)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// applyLocked updates moderation state for one audit entry. It is used both
+// for live actions and when replaying the audit file at startup.
+func (h *Hub) applyLocked(e AuditEntry) {
+	switch e.Action {
+	case ActionMute:
+		h.muted[e.Target] = e.Time.Add(e.Duration)
+	case ActionUnmute:
+		delete(h.muted, e.Target)
+	case ActionBan:
+		until := time.Time{}
+		if e.Duration > 0 {
+			until = e.Time.Add(e.Duration)
+		}
+		h.banned[e.Target] = until
+		h.disconnectLocked(e.Target, "banned")
+	case ActionUnban:
+		delete(h.banned, e.Target)
+	case ActionKick:
+		h.disconnectLocked(e.Target, "kicked")
+	}
+}
+
+func (h *Hub) disconnectLocked(user, why string) {
+	for c := range h.clients {
+		if c.username == user {
+			select {
+			case c.send <- Message{Type: TypeSystem, Message: "you have been " + why}:
+			default:
+			}
+			h.removeLocked(c)
+		}
+	}
+}
+
+func main() {
+	secret, exists := os.LookupEnv("JWT_SECRET")
+	if !exists {
+		log.Fatal("JWT_SECRET environment variable is required")
+	}
+
+	audit, err := OpenAuditLog(auditFile)
+	if err != nil {
+		log.Fatal("Opening audit log: ", err)
+	}
+	defer audit.Close()
+
+	profanity, err := NewRegexFilter(`\bdarn\b`, `\bheck\b`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hub, err := NewHub([]byte(secret), NewRateLimiter(1, 5), audit, profanity)
+	if err != nil {
+		log.Fatal("Replaying audit log: ", err)
+	}
+	go hub.Run()
+
+	http.HandleFunc("/chat", hub.chatHandler)
+	for _, action := range []string{ActionMute, ActionUnmute, ActionKick, ActionBan, ActionUnban} {
+		http.HandleFunc("/admin/"+action, hub.moderationHandler(action))
+	}
+
+	log.Println("Server running on :8080")
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
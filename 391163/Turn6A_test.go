@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/websocket"
+)
+
+var testKey = []byte("test_secret_key")
+
+func signedToken(t *testing.T, subject, role string) string {
+	t.Helper()
+	claims := &Claims{StandardClaims: jwt.StandardClaims{Subject: subject, ExpiresAt: time.Now().Add(time.Hour).Unix()}, Role: role}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// testServer runs a hub behind the same routes as main.
+func testServer(t *testing.T, limiter *RateLimiter, filters ...MessageFilter) *httptest.Server {
+	t.Helper()
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	hub, err := NewHub(testKey, limiter, audit, filters...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go hub.Run()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chat", hub.chatHandler)
+	for _, action := range []string{ActionMute, ActionUnmute, ActionKick, ActionBan, ActionUnban} {
+		mux.HandleFunc("/admin/"+action, hub.moderationHandler(action))
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dial(t *testing.T, server *httptest.Server, user string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/chat?token=" + signedToken(t, user, "")
+	return websocket.DefaultDialer.Dial(url, nil)
+}
+
+// connect dials as user and consumes the "joined general" frame.
+func connect(t *testing.T, server *httptest.Server, user string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := dial(t, server, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if msg := receive(t, conn); msg.Type != TypeSystem {
+		t.Fatalf("first frame = %+v, want the join notice", msg)
+	}
+	return conn
+}
+
+func receive(t *testing.T, conn *websocket.Conn) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg Message
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	return msg
+}
+
+func say(t *testing.T, conn *websocket.Conn, text string) Message {
+	t.Helper()
+	if err := conn.WriteJSON(Message{Type: TypeMessage, Message: text}); err != nil {
+		t.Fatal(err)
+	}
+	return receive(t, conn)
+}
+
+func moderate(t *testing.T, server *httptest.Server, action string, req ModerationRequest) int {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	r, _ := http.NewRequest(http.MethodPost, server.URL+"/admin/"+action, bytes.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+signedToken(t, "mod", roleAdmin))
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRegexFilterMasksByCharacter(t *testing.T) {
+	filter, err := NewRegexFilter(`(?i)heck`, `gräßlich`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Message: "what the HECK, how gräßlich"}
+	if err := filter(msg); err != nil {
+		t.Fatal(err)
+	}
+	if want := "what the ****, how ********"; msg.Message != want {
+		t.Errorf("filtered = %q, want %q", msg.Message, want)
+	}
+}
+
+func TestRateLimiterDropsIdleBuckets(t *testing.T) {
+	rl := NewRateLimiter(1000, 2) // refills in 2ms
+	if !rl.Allow("a") || !rl.Allow("a") || rl.Allow("a") {
+		t.Fatal("want exactly Burst messages allowed at once")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if !rl.Allow("b") {
+		t.Fatal("b was limited")
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if _, ok := rl.buckets["a"]; ok || len(rl.buckets) != 1 {
+		t.Errorf("buckets = %v, want only b after a went idle", rl.buckets)
+	}
+}
+
+func TestRateLimitRejectsWithErrorFrame(t *testing.T) {
+	server := testServer(t, NewRateLimiter(0.001, 2))
+	conn := connect(t, server, "alice")
+	for i := 0; i < 2; i++ {
+		if msg := say(t, conn, "hi"); msg.Type != TypeMessage {
+			t.Fatalf("message %d: got %+v, want it broadcast", i, msg)
+		}
+	}
+	if msg := say(t, conn, "hi"); msg.Type != TypeError || !strings.Contains(msg.Message, "rate limit") {
+		t.Errorf("third message: got %+v, want a rate limit error", msg)
+	}
+}
+
+func TestLongestMessageFitsInFrame(t *testing.T) {
+	server := testServer(t, NewRateLimiter(100, 100))
+	conn := connect(t, server, "alice")
+
+	// Every character escaped as a surrogate pair, the longest encoding.
+	sayEscaped := func(n int) Message {
+		t.Helper()
+		frame := `{"type":"message","message":"` + strings.Repeat(`\ud83d\ude00`, n) + `"}`
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatal(err)
+		}
+		return receive(t, conn)
+	}
+	if msg := sayEscaped(maxMessageLen); msg.Type != TypeMessage || msg.Message != strings.Repeat("😀", maxMessageLen) {
+		t.Errorf("longest message: got type %q, want it broadcast", msg.Type)
+	}
+	if msg := sayEscaped(maxMessageLen + 1); msg.Type != TypeError || !strings.Contains(msg.Message, "exceeds") {
+		t.Errorf("overlong message: got %+v, want a length error", msg)
+	}
+}
+
+func TestBanDisconnectsAndBlocksReconnect(t *testing.T) {
+	server := testServer(t, NewRateLimiter(100, 100))
+	conn := connect(t, server, "mallory")
+
+	if status := moderate(t, server, ActionBan, ModerationRequest{User: "mallory", Reason: "spam"}); status != http.StatusNoContent {
+		t.Fatalf("ban: status %d", status)
+	}
+	if msg := receive(t, conn); msg.Message != "you have been banned" {
+		t.Errorf("got %+v, want the ban notice", msg)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("connection still open after ban")
+	}
+
+	_, resp, err := dial(t, server, "mallory")
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("reconnect after ban: err %v, want 403", err)
+	}
+
+	if status := moderate(t, server, ActionUnban, ModerationRequest{User: "mallory"}); status != http.StatusNoContent {
+		t.Fatalf("unban: status %d", status)
+	}
+	connect(t, server, "mallory")
+}
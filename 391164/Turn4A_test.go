@@ -0,0 +1,530 @@
+package _91164
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+var replaySeed = flag.Int64("harness.seed", 0, "replay a single seed reported by a failing harness test")
+
+// FaultConfig controls what the harness injects at each named point.
+type FaultConfig struct {
+	InjectPanic bool
+	PanicRate   float64
+	InjectDelay bool
+	DelayRate   float64
+	MaxDelay    int // in scheduler steps
+}
+
+// InjectedPanic is the value the harness panics with; the harness recovers it.
+type InjectedPanic struct {
+	Thread int
+	Point  string
+}
+
+var errAborted = errors.New("harness aborted")
+
+type thread struct {
+	id        int
+	wake      chan struct{}
+	done      bool
+	wakeAt    int
+	blockedOn *Mutex
+	panicked  *InjectedPanic
+	err       error
+}
+
+// Harness runs a set of goroutines one at a time. Every call to Point hands
+// control back to the scheduler, which picks the next goroutine with a
+// seeded RNG, so a seed fully determines the interleaving and the faults.
+type Harness struct {
+	Seed    int64
+	rng     *rand.Rand
+	faults  FaultConfig
+	threads []*thread
+	current *thread
+	yielded chan struct{}
+	now     int
+	aborted bool
+	trace   []string
+	clock   int
+	history []Op
+}
+
+func NewHarness(seed int64, faults FaultConfig) *Harness {
+	return &Harness{Seed: seed, rng: rand.New(rand.NewSource(seed)), faults: faults}
+}
+
+// Rand returns the harness RNG. Test code must use it instead of math/rand
+// so that its choices replay with the seed.
+func (h *Harness) Rand() *rand.Rand { return h.rng }
+
+// Trace returns the scheduling decisions and injected faults, in order.
+func (h *Harness) Trace() []string { return h.trace }
+
+// Run starts one goroutine per function and schedules them to completion.
+// It returns an error on deadlock or on a panic the harness did not inject.
+func (h *Harness) Run(fns ...func(tid int)) error {
+	h.yielded = make(chan struct{})
+	for i, fn := range fns {
+		th := &thread{id: i, wake: make(chan struct{})}
+		h.threads = append(h.threads, th)
+		go h.start(th, fn)
+	}
+
+	for {
+		th, err := h.pick()
+		if err != nil {
+			h.abort()
+			return err
+		}
+		if th == nil {
+			break
+		}
+		h.current = th
+		th.wake <- struct{}{}
+		<-h.yielded
+	}
+
+	for _, th := range h.threads {
+		if th.err != nil {
+			return th.err
+		}
+	}
+	return nil
+}
+
+func (h *Harness) start(th *thread, fn func(tid int)) {
+	<-th.wake
+	defer func() {
+		switch r := recover().(type) {
+		case nil:
+		case InjectedPanic:
+			th.panicked = &r
+		case error:
+			if !errors.Is(r, errAborted) {
+				th.err = fmt.Errorf("thread %d panicked: %v", th.id, r)
+			}
+		default:
+			th.err = fmt.Errorf("thread %d panicked: %v", th.id, r)
+		}
+		th.done = true
+		h.yielded <- struct{}{}
+	}()
+	fn(th.id)
+}
+
+// pick advances the virtual clock and chooses the next thread to run. It
+// returns nil when every thread has finished.
+func (h *Harness) pick() (*thread, error) {
+	h.now++
+	for {
+		var runnable []*thread
+		nextWake, live := math.MaxInt, 0
+		for _, th := range h.threads {
+			if th.done {
+				continue
+			}
+			live++
+			if th.blockedOn != nil && th.blockedOn.held {
+				continue
+			}
+			if th.wakeAt > h.now {
+				nextWake = min(nextWake, th.wakeAt)
+				continue
+			}
+			runnable = append(runnable, th)
+		}
+		switch {
+		case len(runnable) > 0:
+			th := runnable[h.rng.Intn(len(runnable))]
+			h.trace = append(h.trace, fmt.Sprintf("run t%d", th.id))
+			return th, nil
+		case live == 0:
+			return nil, nil
+		case nextWake == math.MaxInt:
+			return nil, fmt.Errorf("deadlock: %d threads blocked", live)
+		}
+		// Everyone left is sleeping; jump to the first wake-up.
+		h.now = nextWake
+	}
+}
+
+// abort releases goroutines that can no longer be scheduled.
+func (h *Harness) abort() {
+	h.aborted = true
+	for _, th := range h.threads {
+		if !th.done {
+			h.current = th
+			th.wake <- struct{}{}
+			<-h.yielded
+		}
+	}
+}
+
+// Point marks a place where the scheduler may switch goroutines or inject a fault.
+func (h *Harness) Point(name string) {
+	th := h.current
+	h.trace = append(h.trace, fmt.Sprintf("t%d %s", th.id, name))
+	if h.faults.InjectPanic && h.rng.Float64() < h.faults.PanicRate {
+		h.trace = append(h.trace, fmt.Sprintf("t%d panic at %s", th.id, name))
+		panic(InjectedPanic{Thread: th.id, Point: name})
+	}
+	if h.faults.InjectDelay && h.faults.MaxDelay > 0 && h.rng.Float64() < h.faults.DelayRate {
+		th.wakeAt = h.now + 1 + h.rng.Intn(h.faults.MaxDelay)
+		h.trace = append(h.trace, fmt.Sprintf("t%d delay until %d", th.id, th.wakeAt))
+	}
+	h.yield(th)
+}
+
+func (h *Harness) yield(th *thread) {
+	h.yielded <- struct{}{}
+	<-th.wake
+	if h.aborted {
+		panic(errAborted)
+	}
+}
+
+// Mutex is a lock that cooperates with the harness scheduler. A real
+// sync.Mutex would block the only running goroutine forever.
+type Mutex struct {
+	h    *Harness
+	held bool
+}
+
+func (h *Harness) NewMutex() *Mutex { return &Mutex{h: h} }
+
+func (m *Mutex) Lock() {
+	th := m.h.current
+	for m.held {
+		th.blockedOn = m
+		m.h.trace = append(m.h.trace, fmt.Sprintf("t%d blocked", th.id))
+		m.h.yield(th)
+	}
+	th.blockedOn = nil
+	m.held = true
+}
+
+func (m *Mutex) Unlock() { m.held = false }
+
+// Op is one operation in a recorded history. Call and Return are positions
+// in a global event order; an op that never returned is still pending.
+type Op struct {
+	Thread int
+	Kind   string
+	Result string
+	Call   int
+	Return int
+	Done   bool
+}
+
+func (o Op) String() string {
+	if !o.Done {
+		return fmt.Sprintf("t%d %s [%d, pending]", o.Thread, o.Kind, o.Call)
+	}
+	return fmt.Sprintf("t%d %s -> %s [%d, %d]", o.Thread, o.Kind, o.Result, o.Call, o.Return)
+}
+
+// Invoke records the start of an operation and returns its index.
+func (h *Harness) Invoke(kind string) int {
+	h.clock++
+	h.history = append(h.history, Op{Thread: h.current.id, Kind: kind, Call: h.clock, Return: math.MaxInt})
+	return len(h.history) - 1
+}
+
+// Complete records the result of an operation started with Invoke.
+func (h *Harness) Complete(idx int, result string) {
+	h.clock++
+	h.history[idx].Return = h.clock
+	h.history[idx].Result = result
+	h.history[idx].Done = true
+}
+
+func (h *Harness) History() []Op { return h.history }
+
+// Model is a sequential specification. Step applies op to state and reports
+// whether the op's result is allowed; results of pending ops are not checked.
+type Model interface {
+	Init() int
+	Step(state int, op Op) (int, bool)
+}
+
+// CheckLinearizable reports whether some total order of the ops respects
+// real-time order and matches the model (Wing & Gong search with memoisation).
+// Pending ops may be placed anywhere after their call or left out entirely.
+func CheckLinearizable(ops []Op, model Model) bool {
+	if len(ops) > 64 {
+		panic("CheckLinearizable supports at most 64 operations")
+	}
+	var completed uint64
+	for i, op := range ops {
+		if op.Done {
+			completed |= 1 << i
+		}
+	}
+
+	type key struct {
+		mask  uint64
+		state int
+	}
+	failed := make(map[key]bool)
+
+	var search func(mask uint64, state int) bool
+	search = func(mask uint64, state int) bool {
+		if mask&completed == completed {
+			return true
+		}
+		k := key{mask, state}
+		if failed[k] {
+			return false
+		}
+		// Any op that starts before the earliest outstanding return may go next.
+		minReturn := math.MaxInt
+		for i, op := range ops {
+			if mask&(1<<i) == 0 && op.Done {
+				minReturn = min(minReturn, op.Return)
+			}
+		}
+		for i, op := range ops {
+			if mask&(1<<i) != 0 || op.Call > minReturn {
+				continue
+			}
+			if next, ok := model.Step(state, op); ok && search(mask|1<<i, next) {
+				return true
+			}
+		}
+		failed[k] = true
+		return false
+	}
+	return search(0, model.Init())
+}
+
+// counterModel is the sequential Counter: dec fails instead of going negative.
+type counterModel struct{}
+
+func (counterModel) Init() int { return 0 }
+
+func (counterModel) Step(v int, op Op) (int, bool) {
+	switch op.Kind {
+	case "inc":
+		return v + 1, !op.Done || op.Result == "ok"
+	case "dec":
+		if v > 0 {
+			return v - 1, !op.Done || op.Result == "ok"
+		}
+		return v, !op.Done || op.Result == "error"
+	case "get":
+		return v, !op.Done || op.Result == fmt.Sprint(v)
+	}
+	return v, false
+}
+
+// Counter is the state the earlier turns drove through channels, with the
+// harness points placed where the scheduler may interleave other goroutines.
+type Counter struct {
+	h     *Harness
+	mu    *Mutex
+	value int
+	racy  bool // skip locking to show the harness catches lost updates
+}
+
+func NewCounter(h *Harness, racy bool) *Counter {
+	return &Counter{h: h, mu: h.NewMutex(), racy: racy}
+}
+
+func (c *Counter) lock() func() {
+	if c.racy {
+		return func() {}
+	}
+	c.h.Point("lock")
+	c.mu.Lock()
+	return c.mu.Unlock
+}
+
+func (c *Counter) Increment() {
+	defer c.lock()()
+	v := c.value
+	c.h.Point("inc.read")
+	c.value = v + 1
+	c.h.Point("inc.written")
+}
+
+func (c *Counter) Decrement() error {
+	defer c.lock()()
+	v := c.value
+	c.h.Point("dec.read")
+	if v == 0 {
+		return errors.New("counter is already zero")
+	}
+	c.value = v - 1
+	c.h.Point("dec.written")
+	return nil
+}
+
+func (c *Counter) Value() int {
+	defer c.lock()()
+	c.h.Point("get")
+	return c.value
+}
+
+// counterWorkload runs a few random operations per thread and records them.
+func counterWorkload(h *Harness, c *Counter, threads, opsPerThread int) error {
+	fns := make([]func(int), threads)
+	for i := range fns {
+		fns[i] = func(int) {
+			for j := 0; j < opsPerThread; j++ {
+				switch h.Rand().Intn(3) {
+				case 0:
+					idx := h.Invoke("inc")
+					c.Increment()
+					h.Complete(idx, "ok")
+				case 1:
+					idx := h.Invoke("dec")
+					result := "ok"
+					if err := c.Decrement(); err != nil {
+						result = "error"
+					}
+					h.Complete(idx, result)
+				default:
+					idx := h.Invoke("get")
+					h.Complete(idx, fmt.Sprint(c.Value()))
+				}
+			}
+		}
+	}
+	return h.Run(fns...)
+}
+
+// explore runs scenario once per seed and stops at the first failure,
+// printing the seed so the exact interleaving can be replayed.
+func explore(t *testing.T, seeds int, faults FaultConfig, scenario func(h *Harness) error) {
+	t.Helper()
+	first, last := int64(1), int64(seeds)
+	if *replaySeed != 0 {
+		first, last = *replaySeed, *replaySeed
+	}
+	for seed := first; seed <= last; seed++ {
+		h := NewHarness(seed, faults)
+		if err := scenario(h); err != nil {
+			t.Fatalf("seed %d: %v\nreplay with: go test -run '%s' -harness.seed=%d\ntrace:\n  %s",
+				seed, err, t.Name(), seed, strings.Join(h.Trace(), "\n  "))
+		}
+	}
+}
+
+func linearizableScenario(racy bool) func(h *Harness) error {
+	return func(h *Harness) error {
+		c := NewCounter(h, racy)
+		if err := counterWorkload(h, c, 3, 4); err != nil {
+			return err
+		}
+		if !CheckLinearizable(h.History(), counterModel{}) {
+			var ops []string
+			for _, op := range h.History() {
+				ops = append(ops, op.String())
+			}
+			return fmt.Errorf("history is not linearizable:\n  %s", strings.Join(ops, "\n  "))
+		}
+		return nil
+	}
+}
+
+var defaultFaults = FaultConfig{
+	InjectPanic: true,
+	PanicRate:   0.02,
+	InjectDelay: true,
+	DelayRate:   0.1,
+	MaxDelay:    5,
+}
+
+func TestCounterIsLinearizableUnderFaults(t *testing.T) {
+	explore(t, 300, defaultFaults, linearizableScenario(false))
+}
+
+func TestSameSeedReplaysSameInterleaving(t *testing.T) {
+	run := func(seed int64) ([]string, []Op) {
+		h := NewHarness(seed, defaultFaults)
+		if err := counterWorkload(h, NewCounter(h, false), 3, 5); err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		return h.Trace(), h.History()
+	}
+
+	trace1, hist1 := run(42)
+	trace2, hist2 := run(42)
+	if strings.Join(trace1, "\n") != strings.Join(trace2, "\n") {
+		t.Fatal("same seed produced different traces")
+	}
+	if fmt.Sprint(hist1) != fmt.Sprint(hist2) {
+		t.Fatal("same seed produced different histories")
+	}
+
+	trace3, _ := run(43)
+	if strings.Join(trace1, "\n") == strings.Join(trace3, "\n") {
+		t.Fatal("different seeds produced the same trace")
+	}
+}
+
+// TestRacyCounterIsCaught checks that the harness finds the lost update in an
+// unlocked counter and that the failing seed reproduces it.
+func TestRacyCounterIsCaught(t *testing.T) {
+	scenario := linearizableScenario(true)
+	for seed := int64(1); seed <= 300; seed++ {
+		err := scenario(NewHarness(seed, FaultConfig{}))
+		if err == nil {
+			continue
+		}
+		if again := scenario(NewHarness(seed, FaultConfig{})); again == nil || again.Error() != err.Error() {
+			t.Fatalf("seed %d did not replay the same failure", seed)
+		}
+		t.Logf("seed %d exposes the race:\n%v", seed, err)
+		return
+	}
+	t.Fatal("no seed exposed the unlocked counter")
+}
+
+func TestDeadlockIsReported(t *testing.T) {
+	h := NewHarness(1, FaultConfig{})
+	a, b := h.NewMutex(), h.NewMutex()
+	err := h.Run(
+		func(int) { a.Lock(); h.Point("hold a"); b.Lock() },
+		func(int) { b.Lock(); h.Point("hold b"); a.Lock() },
+	)
+	// Some seeds let one thread take both locks first; seed 1 interleaves them.
+	if err == nil || !strings.Contains(err.Error(), "deadlock") {
+		t.Fatalf("Run = %v, want deadlock", err)
+	}
+}
+
+func TestCheckLinearizable(t *testing.T) {
+	ok := []Op{
+		{Thread: 0, Kind: "inc", Result: "ok", Call: 1, Return: 4, Done: true},
+		{Thread: 1, Kind: "get", Result: "1", Call: 2, Return: 3, Done: true},
+	}
+	if !CheckLinearizable(ok, counterModel{}) {
+		t.Error("overlapping inc/get reading 1 should be linearizable")
+	}
+
+	lost := []Op{
+		{Thread: 0, Kind: "inc", Result: "ok", Call: 1, Return: 2, Done: true},
+		{Thread: 1, Kind: "inc", Result: "ok", Call: 3, Return: 4, Done: true},
+		{Thread: 0, Kind: "get", Result: "1", Call: 5, Return: 6, Done: true},
+	}
+	if CheckLinearizable(lost, counterModel{}) {
+		t.Error("lost update should not be linearizable")
+	}
+
+	pending := []Op{
+		{Thread: 0, Kind: "inc", Call: 1, Return: math.MaxInt},
+		{Thread: 1, Kind: "get", Result: "1", Call: 2, Return: 3, Done: true},
+		{Thread: 1, Kind: "get", Result: "0", Call: 4, Return: 5, Done: true},
+	}
+	if CheckLinearizable(pending, counterModel{}) {
+		t.Error("a pending inc cannot be observed and then undone")
+	}
+}
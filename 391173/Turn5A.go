@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TaskFunc type for task functions. Returning nil means the task finished
+// normally; an error or a panic counts as a failure.
+type TaskFunc func(ctx context.Context, name string) error
+
+// TaskID identifies a child for its whole lifetime, across restarts.
+type TaskID uint64
+
+// Strategy decides which children restart when one of them fails.
+type Strategy int
+
+const (
+	OneForOne  Strategy = iota // restart only the failed child
+	OneForAll                  // stop and restart every child
+	RestForOne                 // restart the failed child and those started after it
+)
+
+// RestartPolicy decides whether a child is restarted at all.
+type RestartPolicy int
+
+const (
+	Permanent RestartPolicy = iota // always restarted
+	Transient                      // restarted only after a failure
+	Temporary                      // never restarted
+)
+
+// ChildSpec describes a supervised task or a nested supervisor.
+type ChildSpec struct {
+	Name     string
+	Priority int // Higher number means higher priority; stopped later on shutdown
+	Restart  RestartPolicy
+	Timeout  time.Duration // optional limit for a single run
+	Fn       TaskFunc
+	// Supervisor runs a nested group as this child. When it gives up, the
+	// parent sees a failure and applies its own strategy.
+	Supervisor *Supervisor
+}
+
+// ChildInfo is a snapshot of one child.
+type ChildInfo struct {
+	ID       TaskID
+	Name     string
+	Priority int
+	Running  bool
+	Restarts int
+}
+
+// ErrRestartIntensity is returned by Run when children fail too often.
+var ErrRestartIntensity = errors.New("restart intensity exceeded")
+
+const defaultShutdownTimeout = 5 * time.Second
+
+type child struct {
+	id       TaskID
+	spec     ChildSpec
+	cancel   context.CancelFunc
+	done     chan struct{}
+	gen      int // bumped on each start so exits of old runs are ignored
+	running  bool
+	restarts int
+}
+
+type exitEvent struct {
+	id  TaskID
+	gen int
+	err error
+}
+
+type request struct {
+	add    *ChildSpec
+	remove TaskID
+	info   bool
+	reply  chan response
+}
+
+type response struct {
+	id    TaskID
+	err   error
+	infos []ChildInfo
+}
+
+// Supervisor runs children and restarts them according to its strategy,
+// giving up once more than MaxRestarts happen within Period.
+type Supervisor struct {
+	Name            string
+	Strategy        Strategy
+	MaxRestarts     int
+	Period          time.Duration
+	ShutdownTimeout time.Duration
+
+	mu       sync.Mutex
+	nextID   TaskID
+	pending  []*child // added before Run
+	requests chan request
+	events   chan exitEvent
+	loopDone chan struct{}
+	running  bool
+
+	// Owned by the Run loop.
+	ctx      context.Context
+	children []*child // in start order
+	restarts []time.Time
+}
+
+func NewSupervisor(name string, strategy Strategy, maxRestarts int, period time.Duration) *Supervisor {
+	return &Supervisor{
+		Name:            name,
+		Strategy:        strategy,
+		MaxRestarts:     maxRestarts,
+		Period:          period,
+		ShutdownTimeout: defaultShutdownTimeout,
+		requests:        make(chan request),
+		events:          make(chan exitEvent),
+	}
+}
+
+// Add registers a child and starts it if the supervisor is running.
+func (s *Supervisor) Add(spec ChildSpec) (TaskID, error) {
+	if (spec.Fn == nil) == (spec.Supervisor == nil) {
+		return 0, errors.New("child needs exactly one of Fn or Supervisor")
+	}
+	s.mu.Lock()
+	if !s.running {
+		s.nextID++
+		s.pending = append(s.pending, &child{id: s.nextID, spec: spec})
+		id := s.nextID
+		s.mu.Unlock()
+		return id, nil
+	}
+	s.mu.Unlock()
+	resp, err := s.call(request{add: &spec})
+	if err != nil {
+		return 0, err
+	}
+	return resp.id, resp.err
+}
+
+// Remove stops a child and forgets it. Other children keep their IDs.
+func (s *Supervisor) Remove(id TaskID) error {
+	s.mu.Lock()
+	if !s.running {
+		defer s.mu.Unlock()
+		for i, c := range s.pending {
+			if c.id == id {
+				s.pending = append(s.pending[:i], s.pending[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("task %d not found", id)
+	}
+	s.mu.Unlock()
+	resp, err := s.call(request{remove: id})
+	if err != nil {
+		return err
+	}
+	return resp.err
+}
+
+// Children returns a snapshot of the children in start order.
+func (s *Supervisor) Children() []ChildInfo {
+	resp, err := s.call(request{info: true})
+	if err != nil {
+		return nil
+	}
+	return resp.infos
+}
+
+func (s *Supervisor) call(req request) (response, error) {
+	s.mu.Lock()
+	done := s.loopDone
+	s.mu.Unlock()
+	if done == nil {
+		return response{}, errors.New("supervisor is not running")
+	}
+	req.reply = make(chan response, 1)
+	select {
+	case s.requests <- req:
+		return <-req.reply, nil
+	case <-done:
+		return response{}, errors.New("supervisor has stopped")
+	}
+}
+
+// Run starts the children and supervises them until ctx is cancelled, in
+// which case it stops them in priority order and returns nil, or until the
+// restart intensity is exceeded, in which case it returns an error.
+func (s *Supervisor) Run(ctx context.Context) (err error) {
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		return errors.New("supervisor is already running")
+	}
+	s.running = true
+	s.loopDone = make(chan struct{})
+	s.children, s.pending = s.pending, nil
+	s.restarts = nil
+	// Children must not see ctx's cancellation directly, or they would all
+	// stop at once instead of in priority order.
+	s.ctx = context.WithoutCancel(ctx)
+	s.mu.Unlock()
+
+	defer func() {
+		s.stopChildren(s.children)
+		s.mu.Lock()
+		for _, c := range s.children {
+			c.running = false
+			s.pending = append(s.pending, c)
+		}
+		s.children = nil
+		s.running = false
+		close(s.loopDone)
+		s.mu.Unlock()
+	}()
+
+	for _, c := range s.children {
+		s.startChild(c)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case req := <-s.requests:
+			req.reply <- s.handleRequest(req)
+		case ev := <-s.events:
+			if err := s.handleExit(ev); err != nil {
+				log.Printf("Supervisor %s: giving up: %v", s.Name, err)
+				return err
+			}
+		}
+	}
+}
+
+func (s *Supervisor) handleRequest(req request) response {
+	switch {
+	case req.info:
+		infos := make([]ChildInfo, 0, len(s.children))
+		for _, c := range s.children {
+			infos = append(infos, ChildInfo{ID: c.id, Name: c.spec.Name, Priority: c.spec.Priority, Running: c.running, Restarts: c.restarts})
+		}
+		return response{infos: infos}
+	case req.add != nil:
+		s.mu.Lock()
+		s.nextID++
+		c := &child{id: s.nextID, spec: *req.add}
+		s.mu.Unlock()
+		s.children = append(s.children, c)
+		s.startChild(c)
+		return response{id: c.id}
+	default:
+		i := s.indexOf(req.remove)
+		if i < 0 {
+			return response{err: fmt.Errorf("task %d not found", req.remove)}
+		}
+		c := s.children[i]
+		s.stopChildren([]*child{c})
+		s.children = append(s.children[:i], s.children[i+1:]...)
+		return response{}
+	}
+}
+
+func (s *Supervisor) indexOf(id TaskID) int {
+	for i, c := range s.children {
+		if c.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Supervisor) handleExit(ev exitEvent) error {
+	i := s.indexOf(ev.id)
+	if i < 0 || s.children[i].gen != ev.gen || !s.children[i].running {
+		return nil // exit of a run we already stopped
+	}
+	c := s.children[i]
+	c.running = false
+	if ev.err != nil {
+		log.Printf("Supervisor %s: task %d '%s' failed: %v", s.Name, c.id, c.spec.Name, ev.err)
+	}
+
+	restart := c.spec.Restart == Permanent || (c.spec.Restart == Transient && ev.err != nil)
+	if !restart {
+		s.children = append(s.children[:i], s.children[i+1:]...)
+		return nil
+	}
+
+	if err := s.recordRestart(); err != nil {
+		return fmt.Errorf("task %d '%s': %w (last error: %v)", c.id, c.spec.Name, err, ev.err)
+	}
+
+	var affected []*child
+	switch s.Strategy {
+	case OneForAll:
+		affected = s.children
+	case RestForOne:
+		affected = s.children[i:]
+	default:
+		affected = []*child{c}
+	}
+
+	s.stopChildren(affected)
+	for _, a := range affected {
+		a.restarts++
+		s.startChild(a)
+	}
+	return nil
+}
+
+func (s *Supervisor) recordRestart() error {
+	now := time.Now()
+	kept := s.restarts[:0]
+	for _, t := range s.restarts {
+		if now.Sub(t) < s.Period {
+			kept = append(kept, t)
+		}
+	}
+	s.restarts = append(kept, now)
+	if len(s.restarts) > s.MaxRestarts {
+		return fmt.Errorf("%w: more than %d restarts in %v", ErrRestartIntensity, s.MaxRestarts, s.Period)
+	}
+	return nil
+}
+
+func (s *Supervisor) startChild(c *child) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	if c.spec.Timeout > 0 {
+		var timeoutCancel context.CancelFunc
+		ctx, timeoutCancel = context.WithTimeout(ctx, c.spec.Timeout)
+		parentCancel := cancel
+		cancel = func() { timeoutCancel(); parentCancel() }
+	}
+	c.gen++
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	c.running = true
+
+	go func(gen int, done, loopDone chan struct{}) {
+		err := s.runChild(ctx, c.spec)
+		cancel()
+		close(done)
+		select {
+		case s.events <- exitEvent{id: c.id, gen: gen, err: err}:
+		case <-loopDone:
+		}
+	}(c.gen, c.done, s.loopDone)
+}
+
+func (s *Supervisor) runChild(ctx context.Context, spec ChildSpec) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if spec.Supervisor != nil {
+		return spec.Supervisor.Run(ctx)
+	}
+	return spec.Fn(ctx, spec.Name)
+}
+
+// stopChildren cancels the given children, lowest priority first (later
+// started first among equals), waiting for each before moving on.
+func (s *Supervisor) stopChildren(children []*child) {
+	order := make([]*child, 0, len(children))
+	for _, c := range children {
+		if c.running {
+			order = append(order, c)
+		}
+	}
+	// children is in start order, so reversing it first makes the stable
+	// sort put later-started children first among equal priorities.
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].spec.Priority < order[j].spec.Priority })
+
+	for _, c := range order {
+		c.cancel()
+		select {
+		case <-c.done:
+		case <-time.After(s.ShutdownTimeout):
+			log.Printf("Supervisor %s: task %d '%s' did not stop within %v", s.Name, c.id, c.spec.Name, s.ShutdownTimeout)
+		}
+		c.running = false
+	}
+}
+
+// Example scheduled task
+func runTask(ctx context.Context, name string) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Task %s: Shutting down\n", name)
+			return nil
+		case <-ticker.C:
+			log.Printf("Task %s: Running task\n", name)
+		}
+	}
+}
+
+// flakyTask fails every few seconds to show restarts.
+func flakyTask(ctx context.Context, name string) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(3 * time.Second):
+		return fmt.Errorf("%s lost its connection", name)
+	}
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	workers := NewSupervisor("workers", RestForOne, 3, 10*time.Second)
+	workers.Add(ChildSpec{Name: "Connection", Priority: 3, Fn: flakyTask})
+	workers.Add(ChildSpec{Name: "Consumer", Priority: 2, Fn: runTask})
+
+	root := NewSupervisor("root", OneForOne, 5, time.Minute)
+	root.Add(ChildSpec{Name: "Task 1", Priority: 1, Fn: runTask})
+	root.Add(ChildSpec{Name: "workers", Priority: 2, Supervisor: workers})
+
+	done := make(chan error, 1)
+	go func() { done <- root.Run(ctx) }()
+
+	time.Sleep(5 * time.Second)
+
+	// Dynamically add a new task; its ID stays valid whatever else is removed.
+	id, err := root.Add(ChildSpec{Name: "Task 3", Priority: 3, Fn: runTask})
+	if err != nil {
+		log.Fatal(err)
+	}
+	time.Sleep(2 * time.Second)
+	if err := root.Remove(id); err != nil {
+		log.Println("Remove:", err)
+	}
+	for _, info := range root.Children() {
+		log.Printf("Child %d '%s' running=%t restarts=%d", info.ID, info.Name, info.Running, info.Restarts)
+	}
+
+	log.Println("Initiating graceful shutdown...")
+	cancel()
+	if err := <-done; err != nil {
+		log.Println("Supervisor stopped with error:", err)
+	}
+	log.Println("All tasks have been shut down gracefully.")
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// checkNoLeaks fails the test if goroutines started during it are still
+// running once it finishes.
+func checkNoLeaks(t *testing.T) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				n := runtime.Stack(buf, true)
+				t.Errorf("goroutine leak: %d running, %d before the test\n%s", runtime.NumGoroutine(), before, buf[:n])
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// probe is a task that counts its starts and fails on demand.
+type probe struct {
+	starts atomic.Int32
+	fail   chan error
+}
+
+func newProbe() *probe { return &probe{fail: make(chan error, 1)} }
+
+func (p *probe) run(ctx context.Context, name string) error {
+	p.starts.Add(1)
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-p.fail:
+		return err
+	}
+}
+
+func startSupervisor(t *testing.T, s *Supervisor) (stop func() error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	return func() error {
+		cancel()
+		return <-done
+	}
+}
+
+func TestRestartStrategies(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy Strategy
+		want     [3]int32 // starts per child after the middle one fails once
+	}{
+		{"one for one", OneForOne, [3]int32{1, 2, 1}},
+		{"one for all", OneForAll, [3]int32{2, 2, 2}},
+		{"rest for one", RestForOne, [3]int32{1, 2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkNoLeaks(t)
+			s := NewSupervisor("test", tt.strategy, 5, time.Minute)
+			probes := []*probe{newProbe(), newProbe(), newProbe()}
+			for i, p := range probes {
+				if _, err := s.Add(ChildSpec{Name: "child", Priority: i, Fn: p.run}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			stop := startSupervisor(t, s)
+
+			waitFor(t, func() bool {
+				return probes[0].starts.Load() == 1 && probes[1].starts.Load() == 1 && probes[2].starts.Load() == 1
+			})
+			probes[1].fail <- errors.New("boom")
+			waitFor(t, func() bool { return probes[1].starts.Load() == 2 })
+			waitFor(t, func() bool {
+				for _, info := range s.Children() {
+					if !info.Running {
+						return false
+					}
+				}
+				return true
+			})
+
+			for i, p := range probes {
+				if got := p.starts.Load(); got != tt.want[i] {
+					t.Errorf("child %d started %d times, want %d", i, got, tt.want[i])
+				}
+			}
+			if err := stop(); err != nil {
+				t.Fatalf("Run = %v", err)
+			}
+		})
+	}
+}
+
+func TestRestartPolicies(t *testing.T) {
+	checkNoLeaks(t)
+	s := NewSupervisor("test", OneForOne, 5, time.Minute)
+	transient, temporary := newProbe(), newProbe()
+	s.Add(ChildSpec{Name: "transient", Restart: Transient, Fn: transient.run})
+	s.Add(ChildSpec{Name: "temporary", Restart: Temporary, Fn: temporary.run})
+	stop := startSupervisor(t, s)
+	defer stop()
+
+	waitFor(t, func() bool { return transient.starts.Load() == 1 && temporary.starts.Load() == 1 })
+	transient.fail <- errors.New("crash")
+	waitFor(t, func() bool { return transient.starts.Load() == 2 })
+
+	temporary.fail <- errors.New("crash")
+	transient.fail <- nil // normal exit: not restarted
+	waitFor(t, func() bool { return len(s.Children()) == 0 })
+}
+
+func TestRestartIntensityGivesUp(t *testing.T) {
+	checkNoLeaks(t)
+	s := NewSupervisor("test", OneForOne, 2, time.Minute)
+	s.Add(ChildSpec{Name: "crasher", Fn: func(ctx context.Context, name string) error {
+		return errors.New("always fails")
+	}})
+	healthy := newProbe()
+	s.Add(ChildSpec{Name: "healthy", Fn: healthy.run})
+
+	err := s.Run(context.Background())
+	if !errors.Is(err, ErrRestartIntensity) {
+		t.Fatalf("Run = %v, want ErrRestartIntensity", err)
+	}
+}
+
+func TestPanicIsAFailure(t *testing.T) {
+	checkNoLeaks(t)
+	s := NewSupervisor("test", OneForOne, 1, time.Minute)
+	var runs atomic.Int32
+	s.Add(ChildSpec{Name: "panicky", Fn: func(ctx context.Context, name string) error {
+		if runs.Add(1) == 1 {
+			panic("first run panics")
+		}
+		<-ctx.Done()
+		return nil
+	}})
+	stop := startSupervisor(t, s)
+	waitFor(t, func() bool { return runs.Load() == 2 })
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNestedSupervisorEscalates(t *testing.T) {
+	checkNoLeaks(t)
+	inner := NewSupervisor("inner", OneForOne, 1, time.Minute)
+	var innerRuns atomic.Int32
+	failures := make(chan struct{}, 10)
+	inner.Add(ChildSpec{Name: "worker", Fn: func(ctx context.Context, name string) error {
+		innerRuns.Add(1)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-failures:
+			return errors.New("worker failed")
+		}
+	}})
+
+	outer := NewSupervisor("outer", OneForOne, 5, time.Minute)
+	sibling := newProbe()
+	outer.Add(ChildSpec{Name: "inner", Supervisor: inner})
+	outer.Add(ChildSpec{Name: "sibling", Fn: sibling.run})
+	stop := startSupervisor(t, outer)
+
+	waitFor(t, func() bool { return innerRuns.Load() == 1 })
+	// First failure is absorbed by the inner supervisor; the second exceeds
+	// its intensity, so the outer supervisor restarts the whole inner group.
+	failures <- struct{}{}
+	waitFor(t, func() bool { return innerRuns.Load() == 2 })
+	failures <- struct{}{}
+	waitFor(t, func() bool { return innerRuns.Load() == 3 })
+
+	var innerInfo ChildInfo
+	waitFor(t, func() bool {
+		for _, info := range outer.Children() {
+			if info.Name == "inner" {
+				innerInfo = info
+			}
+		}
+		return innerInfo.Restarts == 1 && innerInfo.Running
+	})
+	if sibling.starts.Load() != 1 {
+		t.Errorf("sibling restarted %d times, want untouched", sibling.starts.Load()-1)
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShutdownFollowsPriority(t *testing.T) {
+	checkNoLeaks(t)
+	s := NewSupervisor("test", OneForOne, 5, time.Minute)
+
+	var mu sync.Mutex
+	var order []string
+	var started atomic.Int32
+	task := func(ctx context.Context, name string) error {
+		started.Add(1)
+		<-ctx.Done()
+		mu.Lock()
+		order = append(order, name)
+		mu.Unlock()
+		return nil
+	}
+	s.Add(ChildSpec{Name: "medium", Priority: 2, Fn: task})
+	s.Add(ChildSpec{Name: "high", Priority: 3, Fn: task})
+	s.Add(ChildSpec{Name: "low", Priority: 1, Fn: task})
+	s.Add(ChildSpec{Name: "low-later", Priority: 1, Fn: task})
+	stop := startSupervisor(t, s)
+	waitFor(t, func() bool { return started.Load() == 4 })
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"low-later", "low", "medium", "high"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("shutdown order %v, want %v", order, want)
+		}
+	}
+}
+
+func TestStableIDsUnderConcurrentAddRemove(t *testing.T) {
+	checkNoLeaks(t)
+	s := NewSupervisor("test", OneForOne, 5, time.Minute)
+	stop := startSupervisor(t, s)
+	waitFor(t, func() bool { return s.Children() != nil })
+
+	var wg sync.WaitGroup
+	ids := make(chan TaskID, 100)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				id, err := s.Add(ChildSpec{Name: "task", Fn: newProbe().run})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	var keep []TaskID
+	n := 0
+	for id := range ids {
+		if n%2 == 0 {
+			wg.Add(1)
+			go func(id TaskID) {
+				defer wg.Done()
+				if err := s.Remove(id); err != nil {
+					t.Error(err)
+				}
+			}(id)
+		} else {
+			keep = append(keep, id)
+		}
+		n++
+	}
+	wg.Wait()
+
+	remaining := map[TaskID]bool{}
+	for _, info := range s.Children() {
+		remaining[info.ID] = true
+	}
+	if len(remaining) != len(keep) {
+		t.Fatalf("%d children left, want %d", len(remaining), len(keep))
+	}
+	for _, id := range keep {
+		if !remaining[id] {
+			t.Errorf("task %d was removed by someone else's Remove", id)
+		}
+	}
+	if err := s.Remove(keep[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove(keep[0]); err == nil {
+		t.Fatal("removing the same ID twice succeeded")
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTaskTimeoutIsReleased(t *testing.T) {
+	checkNoLeaks(t)
+	s := NewSupervisor("test", OneForOne, 100, time.Minute)
+	var runs atomic.Int32
+	s.Add(ChildSpec{Name: "bounded", Restart: Transient, Timeout: 5 * time.Millisecond, Fn: func(ctx context.Context, name string) error {
+		runs.Add(1)
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	stop := startSupervisor(t, s)
+	waitFor(t, func() bool { return runs.Load() >= 3 })
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
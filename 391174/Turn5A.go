@@ -0,0 +1,648 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Cents is an amount of money in minor units. Balances never use floats.
+type Cents int64
+
+// Dollars converts a dollar amount to Cents, rounding to the nearest cent.
+func Dollars(d float64) Cents {
+	return Cents(math.Round(d * 100))
+}
+
+func (c Cents) String() string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, c/100, c%100)
+}
+
+var (
+	ErrInvalidAmount   = errors.New("amount must be positive")
+	ErrAccountNotFound = errors.New("account not found")
+	ErrAccountExists   = errors.New("account already exists")
+	ErrSameAccount     = errors.New("cannot transfer to the same account")
+)
+
+// InsufficientFundsError is returned when a debit would break the account's
+// overdraft rule.
+type InsufficientFundsError struct {
+	Account   string
+	Balance   Cents
+	Requested Cents
+	Overdraft Cents // how far below zero the account may go
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return fmt.Sprintf("insufficient funds in account %s: balance %s, requested %s, overdraft limit %s",
+		e.Account, e.Balance, e.Requested, e.Overdraft)
+}
+
+// Account interface defines methods that any type of account should implement.
+// Balances only change through the Bank, which records every change in the journal.
+type Account interface {
+	GetAccountNumber() string
+	GetBalance() Cents
+
+	core() *accountCore
+	// checkDebit applies the account's overdraft rule. The caller holds the lock.
+	checkDebit(amount Cents) error
+}
+
+// accountCore holds the state shared by all account types.
+type accountCore struct {
+	accountNumber string
+	balance       Cents
+	mu            sync.Mutex
+}
+
+func (a *accountCore) core() *accountCore { return a }
+
+// GetAccountNumber method returns the account number
+func (a *accountCore) GetAccountNumber() string { return a.accountNumber }
+
+// GetBalance method returns the current balance
+func (a *accountCore) GetBalance() Cents {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.balance
+}
+
+// BankAccount is a current account that may go overdrawn up to a limit.
+type BankAccount struct {
+	accountCore
+	overdraftLimit Cents
+}
+
+func (a *BankAccount) checkDebit(amount Cents) error {
+	if a.balance-amount < -a.overdraftLimit {
+		return &InsufficientFundsError{Account: a.accountNumber, Balance: a.balance, Requested: amount, Overdraft: a.overdraftLimit}
+	}
+	return nil
+}
+
+// SavingsAccount earns interest and can never go below zero.
+type SavingsAccount struct {
+	accountCore
+	interestRate float64 // in percentage per year
+	// Interest below one cent is carried over to the next accrual, in
+	// units of 1/(100*periodsPerYear*100) cents, so nothing is lost to rounding.
+	interestCarry int64
+}
+
+func (a *SavingsAccount) checkDebit(amount Cents) error {
+	if amount > a.balance {
+		return &InsufficientFundsError{Account: a.accountNumber, Balance: a.balance, Requested: amount}
+	}
+	return nil
+}
+
+// System accounts are the other side of money entering or leaving the bank.
+const (
+	cashAccount     = "system:cash"
+	interestAccount = "system:interest-expense"
+)
+
+// Journal entry kinds
+const (
+	KindOpen       = "open"
+	KindDeposit    = "deposit"
+	KindWithdrawal = "withdrawal"
+	KindTransfer   = "transfer"
+	KindInterest   = "interest"
+)
+
+// Posting changes one account's balance. Positive amounts increase it.
+type Posting struct {
+	Account string `json:"account"`
+	Amount  Cents  `json:"amount"`
+}
+
+// OpenDetails describes the account created by an open entry.
+type OpenDetails struct {
+	Type           string  `json:"type"` // "bank" or "savings"
+	OverdraftLimit Cents   `json:"overdraft_limit,omitempty"`
+	InterestRate   float64 `json:"interest_rate,omitempty"`
+}
+
+// JournalEntry is one line of the journal. Its postings always sum to zero.
+type JournalEntry struct {
+	Seq      uint64       `json:"seq"`
+	Time     time.Time    `json:"time"`
+	Kind     string       `json:"kind"`
+	Account  string       `json:"account,omitempty"` // for open and interest entries
+	Open     *OpenDetails `json:"open,omitempty"`
+	Postings []Posting    `json:"postings,omitempty"`
+	// Carry is the account's sub-cent interest remainder after an interest
+	// entry, so accrual continues exactly where it left off after a replay.
+	Carry int64 `json:"carry,omitempty"`
+}
+
+func (e *JournalEntry) balanced() bool {
+	var sum Cents
+	for _, p := range e.Postings {
+		sum += p.Amount
+	}
+	return sum == 0
+}
+
+// Journal is an append-only file of JSON entries, synced after every write.
+type Journal struct {
+	mu   sync.Mutex
+	file *os.File
+	seq  uint64
+}
+
+// Append assigns the next sequence number and durably writes e.
+func (j *Journal) Append(e *JournalEntry) error {
+	if !e.balanced() {
+		return fmt.Errorf("unbalanced %s entry: %+v", e.Kind, e.Postings)
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e.Seq = j.seq + 1
+	e.Time = time.Now()
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	offset, err := j.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("seeking journal: %w", err)
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return j.rollback(offset, fmt.Errorf("writing journal: %w", err))
+	}
+	if err := j.file.Sync(); err != nil {
+		return j.rollback(offset, fmt.Errorf("syncing journal: %w", err))
+	}
+	j.seq = e.Seq
+	return nil
+}
+
+// rollback cuts a failed append back off the file so the next entry can
+// reuse its sequence number without leaving a duplicate behind.
+func (j *Journal) rollback(offset int64, cause error) error {
+	if err := j.file.Truncate(offset); err != nil {
+		return errors.Join(cause, fmt.Errorf("truncating journal: %w", err))
+	}
+	return cause
+}
+
+func (j *Journal) Close() error { return j.file.Close() }
+
+// Bank owns the accounts and the journal that every balance change goes through.
+type Bank struct {
+	mu       sync.RWMutex
+	accounts map[string]Account
+	journal  *Journal
+
+	systemMu sync.Mutex
+	system   map[string]Cents
+}
+
+// OpenBank opens or creates the journal at path and replays it to rebuild
+// every account and balance.
+func OpenBank(path string) (*Bank, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	bank := &Bank{
+		accounts: make(map[string]Account),
+		journal:  &Journal{file: f},
+		system:   make(map[string]Cents),
+	}
+	if err := bank.replay(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return bank, nil
+}
+
+// replay applies every journal entry in order. An unparseable final line is
+// an append torn by a crash; it was never acknowledged, so it is cut off.
+// A complete final entry missing its newline gets one, so the next append
+// starts on a line of its own.
+func (bank *Bank) replay() error {
+	scanner := bufio.NewScanner(bank.journal.file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var good int64 // offset just past the last applied entry
+	var torn error
+	for scanner.Scan() {
+		if torn != nil {
+			return torn
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			torn = fmt.Errorf("journal entry after seq %d: %w", bank.journal.seq, err)
+			continue
+		}
+		if e.Seq != bank.journal.seq+1 {
+			return fmt.Errorf("journal sequence gap: got %d after %d", e.Seq, bank.journal.seq)
+		}
+		if !e.balanced() {
+			return fmt.Errorf("journal entry %d is unbalanced", e.Seq)
+		}
+		switch e.Kind {
+		case KindOpen:
+			if _, err := bank.createAccount(e.Account, e.Open); err != nil {
+				return fmt.Errorf("journal entry %d: %w", e.Seq, err)
+			}
+		case KindInterest:
+			if s, ok := bank.accounts[e.Account].(*SavingsAccount); ok {
+				s.interestCarry = e.Carry
+			}
+		}
+		for _, p := range e.Postings {
+			if err := bank.applyReplayed(p); err != nil {
+				return fmt.Errorf("journal entry %d: %w", e.Seq, err)
+			}
+		}
+		bank.journal.seq = e.Seq
+		good += int64(len(scanner.Bytes())) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if torn != nil {
+		if err := bank.journal.file.Truncate(good); err != nil {
+			return fmt.Errorf("discarding torn journal entry: %w", err)
+		}
+		return nil
+	}
+	info, err := bank.journal.file.Stat()
+	if err != nil {
+		return err
+	}
+	if good > info.Size() {
+		if _, err := bank.journal.file.Write([]byte{'\n'}); err != nil {
+			return fmt.Errorf("terminating last journal entry: %w", err)
+		}
+		return bank.journal.file.Sync()
+	}
+	return nil
+}
+
+func (bank *Bank) applyReplayed(p Posting) error {
+	if acct, ok := bank.accounts[p.Account]; ok {
+		acct.core().balance += p.Amount
+		return nil
+	}
+	if p.Account == cashAccount || p.Account == interestAccount {
+		bank.system[p.Account] += p.Amount
+		return nil
+	}
+	return fmt.Errorf("posting to unknown account %s", p.Account)
+}
+
+func (bank *Bank) createAccount(number string, details *OpenDetails) (Account, error) {
+	if details == nil {
+		return nil, errors.New("open entry without details")
+	}
+	if _, exists := bank.accounts[number]; exists {
+		return nil, fmt.Errorf("%w: %s", ErrAccountExists, number)
+	}
+	var acct Account
+	switch details.Type {
+	case "savings":
+		acct = &SavingsAccount{accountCore: accountCore{accountNumber: number}, interestRate: details.InterestRate}
+	case "bank":
+		acct = &BankAccount{accountCore: accountCore{accountNumber: number}, overdraftLimit: details.OverdraftLimit}
+	default:
+		return nil, fmt.Errorf("unknown account type %q", details.Type)
+	}
+	bank.accounts[number] = acct
+	return acct, nil
+}
+
+func (bank *Bank) open(number string, details *OpenDetails, initial Cents) error {
+	if initial < 0 {
+		return ErrInvalidAmount
+	}
+	bank.mu.Lock()
+	if _, exists := bank.accounts[number]; exists {
+		bank.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrAccountExists, number)
+	}
+	if err := bank.journal.Append(&JournalEntry{Kind: KindOpen, Account: number, Open: details}); err != nil {
+		bank.mu.Unlock()
+		return err
+	}
+	bank.createAccount(number, details)
+	bank.mu.Unlock()
+
+	if initial > 0 {
+		return bank.Deposit(number, initial)
+	}
+	return nil
+}
+
+// OpenBankAccount opens a current account that may go overdraft down to -overdraftLimit.
+func (bank *Bank) OpenBankAccount(number string, initial, overdraftLimit Cents) error {
+	if overdraftLimit < 0 {
+		return ErrInvalidAmount
+	}
+	return bank.open(number, &OpenDetails{Type: "bank", OverdraftLimit: overdraftLimit}, initial)
+}
+
+// OpenSavingsAccount opens an account earning interestRate percent per year.
+func (bank *Bank) OpenSavingsAccount(number string, initial Cents, interestRate float64) error {
+	if interestRate < 0 {
+		return errors.New("interest rate cannot be negative")
+	}
+	return bank.open(number, &OpenDetails{Type: "savings", InterestRate: interestRate}, initial)
+}
+
+// Lock order: bank.mu, then account locks in account-number order, then the
+// journal and systemMu. Never take bank.mu while holding an account lock.
+
+func (bank *Bank) account(number string) (Account, error) {
+	bank.mu.RLock()
+	defer bank.mu.RUnlock()
+	return bank.lookup(number)
+}
+
+// lookup finds an account. The caller holds bank.mu.
+func (bank *Bank) lookup(number string) (Account, error) {
+	acct, ok := bank.accounts[number]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAccountNotFound, number)
+	}
+	return acct, nil
+}
+
+// Balance returns the balance of one account.
+func (bank *Bank) Balance(number string) (Cents, error) {
+	acct, err := bank.account(number)
+	if err != nil {
+		return 0, err
+	}
+	return acct.GetBalance(), nil
+}
+
+// post journals the entry and then applies it. Callers hold bank.mu for
+// reading and the locks of every customer account in the postings.
+func (bank *Bank) post(e *JournalEntry) error {
+	if err := bank.journal.Append(e); err != nil {
+		return err
+	}
+	for _, p := range e.Postings {
+		if acct, ok := bank.accounts[p.Account]; ok {
+			acct.core().balance += p.Amount
+			continue
+		}
+		bank.systemMu.Lock()
+		bank.system[p.Account] += p.Amount
+		bank.systemMu.Unlock()
+	}
+	return nil
+}
+
+// Deposit adds cash to an account.
+func (bank *Bank) Deposit(number string, amount Cents) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	bank.mu.RLock()
+	defer bank.mu.RUnlock()
+	acct, err := bank.lookup(number)
+	if err != nil {
+		return err
+	}
+	c := acct.core()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bank.post(&JournalEntry{Kind: KindDeposit, Postings: []Posting{
+		{Account: cashAccount, Amount: -amount},
+		{Account: number, Amount: amount},
+	}})
+}
+
+// Withdraw takes cash out of an account, subject to its overdraft rule.
+func (bank *Bank) Withdraw(number string, amount Cents) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	bank.mu.RLock()
+	defer bank.mu.RUnlock()
+	acct, err := bank.lookup(number)
+	if err != nil {
+		return err
+	}
+	c := acct.core()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := acct.checkDebit(amount); err != nil {
+		return err
+	}
+	return bank.post(&JournalEntry{Kind: KindWithdrawal, Postings: []Posting{
+		{Account: number, Amount: -amount},
+		{Account: cashAccount, Amount: amount},
+	}})
+}
+
+// Transfer moves money between any two accounts atomically. Both locks are
+// taken in account-number order, so opposing transfers cannot deadlock.
+func (bank *Bank) Transfer(fromNumber, toNumber string, amount Cents) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if fromNumber == toNumber {
+		return ErrSameAccount
+	}
+	bank.mu.RLock()
+	defer bank.mu.RUnlock()
+	from, err := bank.lookup(fromNumber)
+	if err != nil {
+		return err
+	}
+	to, err := bank.lookup(toNumber)
+	if err != nil {
+		return err
+	}
+
+	first, second := from.core(), to.core()
+	if toNumber < fromNumber {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	if err := from.checkDebit(amount); err != nil {
+		return err
+	}
+	return bank.post(&JournalEntry{Kind: KindTransfer, Postings: []Posting{
+		{Account: fromNumber, Amount: -amount},
+		{Account: toNumber, Amount: amount},
+	}})
+}
+
+// AccrueInterest credits one period of interest to every savings account,
+// where a year has periodsPerYear periods.
+func (bank *Bank) AccrueInterest(periodsPerYear int) error {
+	if periodsPerYear <= 0 {
+		return errors.New("periodsPerYear must be positive")
+	}
+	bank.mu.RLock()
+	var savings []*SavingsAccount
+	for _, acct := range bank.accounts {
+		if s, ok := acct.(*SavingsAccount); ok {
+			savings = append(savings, s)
+		}
+	}
+	bank.mu.RUnlock()
+
+	for _, s := range savings {
+		if err := bank.accrue(s, periodsPerYear); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bank *Bank) accrue(s *SavingsAccount, periodsPerYear int) error {
+	bank.mu.RLock()
+	defer bank.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.balance <= 0 || s.interestRate == 0 {
+		return nil
+	}
+
+	// Work in hundredths of a basis point per period to keep integers exact.
+	rate := int64(math.Round(s.interestRate * 10000)) // percent -> 1/10000 percent
+	denom := int64(100*10000) * int64(periodsPerYear)
+	total := int64(s.balance)*rate + s.interestCarry
+	interest := Cents(total / denom)
+	carry := total % denom
+
+	// Below one cent the entry has no postings and only records the carry.
+	e := &JournalEntry{Kind: KindInterest, Account: s.accountNumber, Carry: carry}
+	if interest > 0 {
+		e.Postings = []Posting{
+			{Account: interestAccount, Amount: -interest},
+			{Account: s.accountNumber, Amount: interest},
+		}
+	}
+	err := bank.post(e)
+	if err == nil {
+		s.interestCarry = carry
+	}
+	return err
+}
+
+// StartInterestAccrual runs AccrueInterest every interval until ctx is done.
+func (bank *Bank) StartInterestAccrual(ctx context.Context, interval time.Duration, periodsPerYear int) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := bank.AccrueInterest(periodsPerYear); err != nil {
+					fmt.Println("Interest accrual failed:", err)
+				}
+			}
+		}
+	}()
+}
+
+// TrialBalance sums every customer and system account. In a consistent
+// double-entry ledger the result is always zero. Every account is locked in
+// number order for the whole sum, so no posting can land halfway through.
+func (bank *Bank) TrialBalance() Cents {
+	bank.mu.RLock()
+	defer bank.mu.RUnlock()
+	numbers := make([]string, 0, len(bank.accounts))
+	for number := range bank.accounts {
+		numbers = append(numbers, number)
+	}
+	sort.Strings(numbers)
+	var total Cents
+	for _, number := range numbers {
+		c := bank.accounts[number].core()
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		total += c.balance
+	}
+	bank.systemMu.Lock()
+	defer bank.systemMu.Unlock()
+	for _, bal := range bank.system {
+		total += bal
+	}
+	return total
+}
+
+func (bank *Bank) Close() error { return bank.journal.Close() }
+
+func transferRepeatedly(bank *Bank, from, to string, n int, amount Cents, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for i := 0; i < n; i++ {
+		if err := bank.Transfer(from, to, amount); err != nil {
+			var insufficient *InsufficientFundsError
+			if !errors.As(err, &insufficient) {
+				fmt.Println("Transfer failed:", err)
+			}
+		}
+	}
+}
+
+func main() {
+	const journalPath = "ledger.journal"
+
+	bank, err := OpenBank(journalPath)
+	if err != nil {
+		fmt.Println("Opening ledger:", err)
+		return
+	}
+	defer bank.Close()
+
+	if _, err := bank.Balance("123456789"); errors.Is(err, ErrAccountNotFound) {
+		bank.OpenBankAccount("123456789", Dollars(1000), Dollars(100))
+		bank.OpenSavingsAccount("987654321", Dollars(500), 5.0)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Compress a year into a few seconds so the accrual is visible.
+	bank.StartInterestAccrual(ctx, 100*time.Millisecond, 12)
+
+	// Opposing transfers would deadlock without ordered locking.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go transferRepeatedly(bank, "123456789", "987654321", 100, Dollars(12.5), &wg)
+	go transferRepeatedly(bank, "987654321", "123456789", 100, Dollars(10), &wg)
+	wg.Wait()
+
+	err = bank.Withdraw("987654321", Dollars(1_000_000))
+	var insufficient *InsufficientFundsError
+	if errors.As(err, &insufficient) {
+		fmt.Println("Rejected:", insufficient)
+	}
+
+	time.Sleep(time.Second)
+	for _, number := range []string{"123456789", "987654321"} {
+		balance, _ := bank.Balance(number)
+		fmt.Printf("Balance in account %s: %s\n", number, balance)
+	}
+	fmt.Println("Trial balance (must be $0.00):", bank.TrialBalance())
+	fmt.Println("Run again to rebuild these balances from", journalPath)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimiter hands out limit tokens per interval. In adaptive mode the
+// limit is halved whenever the server answers 429 and grows back by one
+// after a full window's worth of successful calls.
+type RateLimiter struct {
+	limit       int64 // Current max number of requests per window
+	maxLimit    int64 // Configured limit; adaptive mode never exceeds it
+	interval    time.Duration
+	windowStart time.Time
+	remaining   int64
+	pausedUntil time.Time // Set from Retry-After; no tokens before this
+	adaptive    bool
+	successes   int64
+	mutex       sync.Mutex
+	counter     atomic.Int64 // Tokens handed out, for reporting
+}
+
+// NewRateLimiter creates a new rate limiter with specified limits and intervals.
+func NewRateLimiter(limit int64, interval time.Duration, adaptive bool) *RateLimiter {
+	if limit < 1 {
+		limit = 1
+	}
+	return &RateLimiter{
+		limit:       limit,
+		maxLimit:    limit,
+		interval:    interval,
+		windowStart: time.Now(),
+		remaining:   limit,
+		adaptive:    adaptive,
+	}
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		rl.mutex.Lock()
+		now := time.Now()
+		var wait time.Duration
+		switch {
+		case now.Before(rl.pausedUntil):
+			wait = rl.pausedUntil.Sub(now)
+		default:
+			if now.Sub(rl.windowStart) >= rl.interval {
+				rl.windowStart = now
+				rl.remaining = rl.limit
+			}
+			if rl.remaining > 0 {
+				rl.remaining--
+				rl.counter.Add(1)
+				rl.mutex.Unlock()
+				return nil
+			}
+			wait = rl.windowStart.Add(rl.interval).Sub(now)
+		}
+		rl.mutex.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Throttled records a 429 response. Nobody gets a token until retryAfter has
+// passed, and in adaptive mode the per-window limit is halved.
+func (rl *RateLimiter) Throttled(retryAfter time.Duration) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	if until := time.Now().Add(retryAfter); until.After(rl.pausedUntil) {
+		rl.pausedUntil = until
+	}
+	if !rl.adaptive {
+		return
+	}
+	rl.successes = 0
+	rl.limit = max(1, rl.limit/2)
+	rl.remaining = min(rl.remaining, rl.limit)
+}
+
+// Succeeded records a successful call, letting an adaptive limiter recover.
+func (rl *RateLimiter) Succeeded() {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	if !rl.adaptive || rl.limit >= rl.maxLimit {
+		return
+	}
+	rl.successes++
+	if rl.successes >= rl.limit {
+		rl.successes = 0
+		rl.limit++
+	}
+}
+
+// Limit returns the current tokens per interval.
+func (rl *RateLimiter) Limit() int64 {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	return rl.limit
+}
+
+// HostLimits keeps one RateLimiter and one concurrency cap per host.
+type HostLimits struct {
+	PerInterval   int64
+	Interval      time.Duration
+	MaxConcurrent int
+	Adaptive      bool
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimit
+}
+
+type hostLimit struct {
+	rate     *RateLimiter
+	inFlight chan struct{}
+}
+
+func (h *HostLimits) forHost(host string) *hostLimit {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hosts == nil {
+		h.hosts = make(map[string]*hostLimit)
+	}
+	hl, ok := h.hosts[host]
+	if !ok {
+		hl = &hostLimit{
+			rate:     NewRateLimiter(h.PerInterval, h.Interval, h.Adaptive),
+			inFlight: make(chan struct{}, max(1, h.MaxConcurrent)),
+		}
+		h.hosts[host] = hl
+	}
+	return hl
+}
+
+// Acquire waits for a concurrency slot and then a rate token for host.
+// The returned release must be called when the request is finished.
+func (h *HostLimits) Acquire(ctx context.Context, host string) (*RateLimiter, func(), error) {
+	hl := h.forHost(host)
+	select {
+	case hl.inFlight <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+	release := func() { <-hl.inFlight }
+	if err := hl.rate.Wait(ctx); err != nil {
+		release()
+		return nil, nil, err
+	}
+	return hl.rate, release, nil
+}
+
+// RetryPolicy controls how failed calls are retried.
+type RetryPolicy struct {
+	MaxRetries  int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	CallTimeout time.Duration // Per attempt; zero means no extra timeout
+}
+
+// backoff returns an exponential delay with full jitter for the given retry.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.BaseDelay << retry
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// StatusError is returned for responses that are not 2xx.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func retryable(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+	}
+	// Network errors and per-attempt timeouts are worth another try; the
+	// caller's own context is checked separately.
+	return true
+}
+
+// CallResult is the outcome of one URL.
+type CallResult struct {
+	URL      string
+	Attempts int
+	Body     []byte
+	Err      error
+	Elapsed  time.Duration
+}
+
+// APIClient makes rate limited, retried HTTP calls.
+type APIClient struct {
+	HTTP   *http.Client
+	Limits *HostLimits
+	Policy RetryPolicy
+}
+
+// Call fetches rawURL, retrying retryable failures under the client's policy.
+func (c *APIClient) Call(ctx context.Context, rawURL string) (res CallResult) {
+	start := time.Now()
+	res.URL = rawURL
+	defer func() { res.Elapsed = time.Since(start) }()
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	for retry := 0; retry <= c.Policy.MaxRetries; retry++ {
+		rate, release, err := c.Limits.Acquire(ctx, u.Host)
+		if err != nil {
+			res.Err = fmt.Errorf("waiting for rate limit: %w", err)
+			return res
+		}
+		res.Attempts++
+		res.Body, res.Err = c.attempt(ctx, rawURL)
+		release()
+
+		var se *StatusError
+		switch {
+		case res.Err == nil:
+			rate.Succeeded()
+			return res
+		case errors.As(res.Err, &se) && se.StatusCode == http.StatusTooManyRequests:
+			rate.Throttled(se.RetryAfter)
+		}
+		if ctx.Err() != nil || !retryable(res.Err) || retry == c.Policy.MaxRetries {
+			return res
+		}
+
+		timer := time.NewTimer(c.Policy.backoff(retry))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			res.Err = ctx.Err()
+			return res
+		case <-timer.C:
+		}
+	}
+	return res
+}
+
+func (c *APIClient) attempt(ctx context.Context, rawURL string) ([]byte, error) {
+	if c.Policy.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Policy.CallTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return body, nil
+}
+
+// parseRetryAfter understands both delay-seconds and HTTP-date forms.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(0, time.Until(t))
+	}
+	return 0
+}
+
+// handleMultipleApiCalls fans the URLs out to at most parallelism workers and
+// returns one result per URL, in the same order as urls.
+func handleMultipleApiCalls(ctx context.Context, client *APIClient, urls []string, parallelism int) []CallResult {
+	results := make([]CallResult, len(urls))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(1, parallelism); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = client.Call(ctx, urls[i])
+			}
+		}()
+	}
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// newFlakyServer stands in for a real API: it allows a few requests per
+// second, answers 429 beyond that, and fails 20% of the time.
+func newFlakyServer() *httptest.Server {
+	var mu sync.Mutex
+	var windowStart time.Time
+	var served int
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if time.Since(windowStart) >= time.Second {
+			windowStart, served = time.Now(), 0
+		}
+		served++
+		over := served > 4
+		mu.Unlock()
+
+		if over {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		time.Sleep(time.Duration(50+rand.Intn(100)) * time.Millisecond)
+		if rand.Intn(10) < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "data for %s", r.URL.Path)
+	}))
+}
+
+func main() {
+	server := newFlakyServer()
+	defer server.Close()
+
+	var urls []string
+	for i := 1; i <= 12; i++ {
+		urls = append(urls, fmt.Sprintf("%s/api%d", server.URL, i))
+	}
+
+	// Deliberately more generous than the server so adaptive mode has
+	// something to learn.
+	limits := &HostLimits{PerInterval: 8, Interval: time.Second, MaxConcurrent: 3, Adaptive: true}
+	client := &APIClient{
+		HTTP:   &http.Client{},
+		Limits: limits,
+		Policy: RetryPolicy{MaxRetries: 4, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second, CallTimeout: time.Second},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	for _, r := range handleMultipleApiCalls(ctx, client, urls, 4) {
+		if r.Err != nil {
+			fmt.Printf("%s failed after %d attempts in %v: %v\n", r.URL, r.Attempts, r.Elapsed.Round(time.Millisecond), r.Err)
+			continue
+		}
+		fmt.Printf("%s succeeded after %d attempts in %v: %s\n", r.URL, r.Attempts, r.Elapsed.Round(time.Millisecond), r.Body)
+	}
+
+	u, _ := url.Parse(server.URL)
+	rate := limits.forHost(u.Host).rate
+	fmt.Printf("Adaptive limit settled at %d/s (configured %d/s), %d tokens used\n", rate.Limit(), limits.PerInterval, rate.counter.Load())
+}
@@ -0,0 +1,607 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrUnknownResource = errors.New("unknown resource")
+	ErrQuotaExceeded   = errors.New("quota exceeded")
+	ErrUnknownLease    = errors.New("unknown or expired lease")
+)
+
+// Resource represents a global resource: limit units, current of them leased.
+type Resource struct {
+	limit   int
+	current int
+}
+
+// Lease grants Amount units of Resource to Node until Expires. Leases that
+// are not renewed by a heartbeat expire and their units return to the pool.
+type Lease struct {
+	ID       string        `json:"id"`
+	Node     string        `json:"node"`
+	Resource string        `json:"resource"`
+	Amount   int           `json:"amount"`
+	Expires  time.Time     `json:"expires"`
+	TTL      time.Duration `json:"ttl"`
+}
+
+// nodeState is what the store knows about a node from its heartbeats.
+type nodeState struct {
+	lastSeen time.Time
+	demand   map[string]int // units wanted per resource, including those held
+	quota    map[string]int // set by adjustRateLimit; absent means no per-node cap
+}
+
+// CentralStore is the quota service. It owns the global limits and every
+// outstanding lease; nodes only hold leases they have been granted.
+type CentralStore struct {
+	mu sync.Mutex
+
+	globalLimits map[string]*Resource
+	nodeStatuses map[string]*nodeState
+	leases       map[string]*Lease
+	leaseTTL     time.Duration
+	nextID       uint64
+	now          func() time.Time
+}
+
+// NewCentralStore creates a new CentralStore instance.
+func NewCentralStore(leaseTTL time.Duration) *CentralStore {
+	return &CentralStore{
+		globalLimits: make(map[string]*Resource),
+		nodeStatuses: make(map[string]*nodeState),
+		leases:       make(map[string]*Lease),
+		leaseTTL:     leaseTTL,
+		now:          time.Now,
+	}
+}
+
+func (c *CentralStore) SetGlobalLimit(resource string, limit int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.globalLimits[resource]; ok {
+		r.limit = limit
+		return
+	}
+	c.globalLimits[resource] = &Resource{limit: limit}
+}
+
+func (c *CentralStore) node(id string) *nodeState {
+	n, ok := c.nodeStatuses[id]
+	if !ok {
+		n = &nodeState{demand: make(map[string]int)}
+		c.nodeStatuses[id] = n
+	}
+	return n
+}
+
+// expireLocked returns the units of every lapsed lease to the pool and
+// forgets nodes that have not been seen for a whole TTL and hold nothing.
+func (c *CentralStore) expireLocked() {
+	now := c.now()
+	held := make(map[string]bool)
+	for id, l := range c.leases {
+		if !now.Before(l.Expires) {
+			c.globalLimits[l.Resource].current -= l.Amount
+			delete(c.leases, id)
+			continue
+		}
+		held[l.Node] = true
+	}
+	for id, n := range c.nodeStatuses {
+		if !held[id] && now.Sub(n.lastSeen) >= c.leaseTTL {
+			delete(c.nodeStatuses, id)
+		}
+	}
+}
+
+// ExpireLeases reclaims lapsed leases. Every other operation does this
+// first, so calling it is only needed to keep Status fresh.
+func (c *CentralStore) ExpireLeases() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked()
+}
+
+// Acquire grants a lease of amount units, bounded by both the global limit
+// and the node's quota.
+func (c *CentralStore) Acquire(nodeID, resource string, amount int) (Lease, error) {
+	if amount <= 0 {
+		return Lease{}, errors.New("amount must be positive")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked()
+
+	r, ok := c.globalLimits[resource]
+	if !ok {
+		return Lease{}, fmt.Errorf("%w: %s", ErrUnknownResource, resource)
+	}
+	if r.current+amount > r.limit {
+		return Lease{}, fmt.Errorf("%w: %s has %d of %d units leased", ErrQuotaExceeded, resource, r.current, r.limit)
+	}
+	n := c.node(nodeID)
+	n.lastSeen = c.now()
+	if quota, capped := n.quota[resource]; capped {
+		if held := c.heldLocked(nodeID, resource); held+amount > quota {
+			return Lease{}, fmt.Errorf("%w: node %s holds %d of its %d %s units", ErrQuotaExceeded, nodeID, held, quota, resource)
+		}
+	}
+
+	c.nextID++
+	l := &Lease{
+		ID:       strconv.FormatUint(c.nextID, 10),
+		Node:     nodeID,
+		Resource: resource,
+		Amount:   amount,
+		Expires:  c.now().Add(c.leaseTTL),
+		TTL:      c.leaseTTL,
+	}
+	c.leases[l.ID] = l
+	r.current += amount
+	return *l, nil
+}
+
+func (c *CentralStore) heldLocked(nodeID, resource string) int {
+	held := 0
+	for _, l := range c.leases {
+		if l.Node == nodeID && l.Resource == resource {
+			held += l.Amount
+		}
+	}
+	return held
+}
+
+// Release returns a lease's units to the pool before it expires.
+func (c *CentralStore) Release(nodeID, leaseID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked()
+	l, ok := c.leases[leaseID]
+	if !ok || l.Node != nodeID {
+		return ErrUnknownLease
+	}
+	c.globalLimits[l.Resource].current -= l.Amount
+	delete(c.leases, leaseID)
+	return nil
+}
+
+// HeartbeatRequest renews the node's leases and reports its demand.
+type HeartbeatRequest struct {
+	Node   string         `json:"node"`
+	Leases []string       `json:"leases"`
+	Demand map[string]int `json:"demand"`
+}
+
+// HeartbeatResponse lists the renewed leases and those the node must stop
+// using because they already expired.
+type HeartbeatResponse struct {
+	Renewed []Lease        `json:"renewed"`
+	Lost    []string       `json:"lost"`
+	Quotas  map[string]int `json:"quotas"`
+	TTL     time.Duration  `json:"ttl"`
+}
+
+// Heartbeat extends every listed lease by a full TTL.
+func (c *CentralStore) Heartbeat(req HeartbeatRequest) HeartbeatResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked()
+
+	n := c.node(req.Node)
+	n.lastSeen = c.now()
+	n.demand = req.Demand
+
+	resp := HeartbeatResponse{Quotas: make(map[string]int), TTL: c.leaseTTL}
+	for _, id := range req.Leases {
+		l, ok := c.leases[id]
+		if !ok || l.Node != req.Node {
+			resp.Lost = append(resp.Lost, id)
+			continue
+		}
+		l.Expires = c.now().Add(c.leaseTTL)
+		resp.Renewed = append(resp.Renewed, *l)
+	}
+	for res, q := range n.quota {
+		resp.Quotas[res] = q
+	}
+	return resp
+}
+
+// adjustRateLimit rebalances each resource's limit across live nodes in
+// proportion to the demand they reported. Lowering a node's quota does not
+// revoke leases it already holds; it only stops new ones until it is back
+// under its share.
+func (c *CentralStore) adjustRateLimit() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked()
+
+	ids := make([]string, 0, len(c.nodeStatuses))
+	for id := range c.nodeStatuses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for resource, r := range c.globalLimits {
+		total := 0
+		for _, id := range ids {
+			total += c.nodeStatuses[id].demand[resource]
+		}
+		if total == 0 {
+			for _, id := range ids {
+				delete(c.nodeStatuses[id].quota, resource)
+			}
+			continue
+		}
+
+		// Largest remainder, so the shares always add up to the limit.
+		type share struct {
+			id        string
+			remainder int
+		}
+		shares := make([]share, 0, len(ids))
+		assigned := 0
+		for _, id := range ids {
+			n := c.nodeStatuses[id]
+			if n.quota == nil {
+				n.quota = make(map[string]int)
+			}
+			q := r.limit * n.demand[resource]
+			n.quota[resource] = q / total
+			assigned += q / total
+			shares = append(shares, share{id, q % total})
+		}
+		sort.SliceStable(shares, func(i, j int) bool { return shares[i].remainder > shares[j].remainder })
+		for i := 0; assigned < r.limit; i++ {
+			c.nodeStatuses[shares[i%len(shares)].id].quota[resource]++
+			assigned++
+		}
+	}
+}
+
+// ResourceStatus is one resource in the status report.
+type ResourceStatus struct {
+	Limit  int            `json:"limit"`
+	Leased int            `json:"leased"`
+	Quotas map[string]int `json:"quotas,omitempty"`
+}
+
+// Status reports the limit, usage and per-node quotas of every resource.
+func (c *CentralStore) Status() map[string]ResourceStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked()
+	status := make(map[string]ResourceStatus, len(c.globalLimits))
+	for name, r := range c.globalLimits {
+		rs := ResourceStatus{Limit: r.limit, Leased: r.current, Quotas: make(map[string]int)}
+		for id, n := range c.nodeStatuses {
+			if q, ok := n.quota[name]; ok {
+				rs.Quotas[id] = q
+			}
+		}
+		status[name] = rs
+	}
+	return status
+}
+
+// Handler exposes the store as an HTTP/JSON API:
+//
+//	POST   /v1/leases            {"node","resource","amount"} -> Lease
+//	DELETE /v1/leases/{id}?node= -> 204
+//	POST   /v1/heartbeat         HeartbeatRequest -> HeartbeatResponse
+//	GET    /v1/status            -> map of ResourceStatus
+func (c *CentralStore) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/leases", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Node     string `json:"node"`
+			Resource string `json:"resource"`
+			Amount   int    `json:"amount"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Node == "" {
+			writeError(w, http.StatusBadRequest, "expected {node, resource, amount}")
+			return
+		}
+		lease, err := c.Acquire(req.Node, req.Resource, req.Amount)
+		switch {
+		case errors.Is(err, ErrQuotaExceeded):
+			writeError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, ErrUnknownResource):
+			writeError(w, http.StatusNotFound, err.Error())
+		case err != nil:
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeJSON(w, http.StatusCreated, lease)
+		}
+	})
+	mux.HandleFunc("DELETE /v1/leases/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if err := c.Release(r.URL.Query().Get("node"), r.PathValue("id")); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /v1/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		var req HeartbeatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Node == "" {
+			writeError(w, http.StatusBadRequest, "expected a heartbeat with a node")
+			return
+		}
+		writeJSON(w, http.StatusOK, c.Heartbeat(req))
+	})
+	mux.HandleFunc("GET /v1/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Status())
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// QuotaClient is a node's view of the quota service. It tracks the leases
+// the node holds and, because it cannot trust the server to still honour a
+// lease it has not heard about, treats each one as valid only until a TTL
+// after the last request that renewed it was sent.
+type QuotaClient struct {
+	NodeID  string
+	BaseURL string
+	HTTP    *http.Client
+	now     func() time.Time
+
+	mu     sync.Mutex
+	leases map[string]heldLease
+	demand map[string]int
+}
+
+type heldLease struct {
+	Lease
+	validUntil time.Time // by the node's own clock
+}
+
+func NewQuotaClient(nodeID, baseURL string, httpClient *http.Client) *QuotaClient {
+	return &QuotaClient{
+		NodeID:  nodeID,
+		BaseURL: baseURL,
+		HTTP:    httpClient,
+		now:     time.Now,
+		leases:  make(map[string]heldLease),
+		demand:  make(map[string]int),
+	}
+}
+
+func (q *QuotaClient) post(ctx context.Context, path string, body, out interface{}) (int, error) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.BaseURL+path, bytes.NewReader(buf))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := q.HTTP.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return resp.StatusCode, errors.New(e.Error)
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// SetDemand reports how many units of resource the node would like to hold.
+// It is sent with the next heartbeat.
+func (q *QuotaClient) SetDemand(resource string, units int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.demand[resource] = units
+}
+
+// Acquire asks the service for a lease.
+func (q *QuotaClient) Acquire(ctx context.Context, resource string, amount int) (Lease, error) {
+	sent := q.now()
+	var lease Lease
+	status, err := q.post(ctx, "/v1/leases", map[string]interface{}{"node": q.NodeID, "resource": resource, "amount": amount}, &lease)
+	if status == http.StatusConflict {
+		return Lease{}, fmt.Errorf("%w: %v", ErrQuotaExceeded, err)
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.leases[lease.ID] = heldLease{Lease: lease, validUntil: sent.Add(lease.TTL)}
+	return lease, nil
+}
+
+// Release gives a lease back. The node stops using it even if the request fails.
+func (q *QuotaClient) Release(ctx context.Context, leaseID string) error {
+	q.mu.Lock()
+	delete(q.leases, leaseID)
+	q.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, q.BaseURL+"/v1/leases/"+leaseID+"?node="+q.NodeID, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := q.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return ErrUnknownLease
+	}
+	return nil
+}
+
+// Heartbeat renews every held lease and drops those the service has lost.
+func (q *QuotaClient) Heartbeat(ctx context.Context) (HeartbeatResponse, error) {
+	q.mu.Lock()
+	req := HeartbeatRequest{Node: q.NodeID, Demand: make(map[string]int, len(q.demand))}
+	for id := range q.leases {
+		req.Leases = append(req.Leases, id)
+	}
+	for res, n := range q.demand {
+		req.Demand[res] = n
+	}
+	q.mu.Unlock()
+
+	sent := q.now()
+	var resp HeartbeatResponse
+	if _, err := q.post(ctx, "/v1/heartbeat", req, &resp); err != nil {
+		return resp, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, id := range resp.Lost {
+		delete(q.leases, id)
+	}
+	for _, l := range resp.Renewed {
+		if _, ok := q.leases[l.ID]; ok {
+			q.leases[l.ID] = heldLease{Lease: l, validUntil: sent.Add(resp.TTL)}
+		}
+	}
+	return resp, nil
+}
+
+// Held returns the units of resource covered by leases that are still valid
+// by the node's clock. Leases that lapsed during a partition do not count.
+func (q *QuotaClient) Held(resource string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	held := 0
+	for id, l := range q.leases {
+		if !now.Before(l.validUntil) {
+			delete(q.leases, id)
+			continue
+		}
+		if l.Resource == resource {
+			held += l.Amount
+		}
+	}
+	return held
+}
+
+// SimulateNode runs tasks that each lease one unit of a resource, while a
+// heartbeat loop keeps the node's leases alive.
+func SimulateNode(ctx context.Context, client *QuotaClient, totalTasks int, heartbeat time.Duration) {
+	resources := []string{"CPU", "Memory", "Network"}
+
+	hbCtx, stopHeartbeat := context.WithCancel(ctx)
+	defer stopHeartbeat()
+	go func() {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hbCtx.Done():
+				return
+			case <-ticker.C:
+				if _, err := client.Heartbeat(hbCtx); err != nil && hbCtx.Err() == nil {
+					fmt.Printf("Node %s: heartbeat failed: %v\n", client.NodeID, err)
+				}
+			}
+		}
+	}()
+
+	var wanted [3]atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < totalTasks; i++ {
+		r := rand.Intn(len(resources))
+		wanted[r].Add(1)
+		client.SetDemand(resources[r], int(wanted[r].Load()))
+
+		wg.Add(1)
+		go func(id, r int) {
+			defer wg.Done()
+			defer func() { client.SetDemand(resources[r], int(wanted[r].Add(-1))) }()
+			lease, err := client.Acquire(ctx, resources[r], 1)
+			if err != nil {
+				fmt.Printf("Node %s: Task %d dropped (%s): %v\n", client.NodeID, id, resources[r], err)
+				return
+			}
+			fmt.Printf("Node %s: Task %d started (Resource: %s, lease %s)\n", client.NodeID, id, resources[r], lease.ID)
+			select {
+			case <-time.After(time.Duration(200+rand.Intn(800)) * time.Millisecond):
+			case <-ctx.Done():
+			}
+			client.Release(context.Background(), lease.ID)
+		}(i, r)
+		time.Sleep(100 * time.Millisecond) // Simulate incoming tasks
+	}
+	wg.Wait()
+}
+
+func main() {
+	store := NewCentralStore(2 * time.Second)
+	for _, res := range []string{"CPU", "Memory", "Network"} {
+		store.SetGlobalLimit(res, 4)
+	}
+	server := httptest.NewServer(store.Handler())
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				store.adjustRateLimit()
+				status, _ := json.Marshal(store.Status())
+				fmt.Println("Rebalanced quotas:", string(status))
+			}
+		}
+	}()
+
+	// Node3 takes leases and then "crashes": it never heartbeats, so its
+	// units come back after the TTL.
+	crashed := NewQuotaClient("Node3", server.URL, server.Client())
+	for _, res := range []string{"CPU", "Memory", "Network"} {
+		crashed.Acquire(ctx, res, 2)
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"Node1", "Node2"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			SimulateNode(ctx, NewQuotaClient(id, server.URL, server.Client()), 30, 500*time.Millisecond)
+		}(id)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is shared by the store and its clients so tests control expiry.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// partitionTransport fails every request while the node is cut off.
+type partitionTransport struct {
+	cut  atomic.Bool
+	base http.RoundTripper
+}
+
+var errPartitioned = errors.New("network partition")
+
+func (p *partitionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if p.cut.Load() {
+		return nil, errPartitioned
+	}
+	return p.base.RoundTrip(req)
+}
+
+type cluster struct {
+	store *CentralStore
+	clock *fakeClock
+	url   string
+}
+
+const testTTL = 10 * time.Second
+
+func newCluster(t *testing.T, limit int) *cluster {
+	t.Helper()
+	clock := &fakeClock{now: time.Unix(1_700_000_000, 0)}
+	store := NewCentralStore(testTTL)
+	store.now = clock.Now
+	store.SetGlobalLimit("CPU", limit)
+	server := httptest.NewServer(store.Handler())
+	t.Cleanup(server.Close)
+	return &cluster{store: store, clock: clock, url: server.URL}
+}
+
+func (c *cluster) node(id string) (*QuotaClient, *partitionTransport) {
+	tr := &partitionTransport{base: http.DefaultTransport}
+	q := NewQuotaClient(id, c.url, &http.Client{Transport: tr})
+	q.now = c.clock.Now
+	return q, tr
+}
+
+func leased(c *cluster) int {
+	return c.store.Status()["CPU"].Leased
+}
+
+func TestDeadNodeLeasesReturnToPool(t *testing.T) {
+	c := newCluster(t, 4)
+	ctx := context.Background()
+	a, _ := c.node("a")
+	b, _ := c.node("b")
+
+	if _, err := a.Acquire(ctx, "CPU", 4); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Acquire(ctx, "CPU", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Acquire over the limit = %v, want ErrQuotaExceeded", err)
+	}
+
+	// a crashes: no heartbeats.
+	c.clock.Advance(testTTL)
+	if got := leased(c); got != 0 {
+		t.Fatalf("%d units still leased after the TTL", got)
+	}
+	if _, err := b.Acquire(ctx, "CPU", 4); err != nil {
+		t.Fatalf("capacity not returned to the pool: %v", err)
+	}
+}
+
+func TestHeartbeatsKeepLeasesAlive(t *testing.T) {
+	c := newCluster(t, 2)
+	ctx := context.Background()
+	a, _ := c.node("a")
+	b, _ := c.node("b")
+
+	if _, err := a.Acquire(ctx, "CPU", 2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		c.clock.Advance(testTTL / 2)
+		if _, err := a.Heartbeat(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := a.Held("CPU"); got != 2 {
+		t.Fatalf("a holds %d units, want 2", got)
+	}
+	if _, err := b.Acquire(ctx, "CPU", 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Acquire = %v, want ErrQuotaExceeded while a heartbeats", err)
+	}
+}
+
+func TestPartitionedNodeStopsUsingLapsedLeases(t *testing.T) {
+	c := newCluster(t, 3)
+	ctx := context.Background()
+	a, link := c.node("a")
+	b, _ := c.node("b")
+
+	if _, err := a.Acquire(ctx, "CPU", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	link.cut.Store(true)
+	c.clock.Advance(testTTL / 2)
+	if _, err := a.Heartbeat(ctx); !errors.Is(err, errPartitioned) {
+		t.Fatalf("Heartbeat during partition = %v", err)
+	}
+	if got := a.Held("CPU"); got != 3 {
+		t.Fatalf("lease dropped early: a holds %d", got)
+	}
+
+	// Both sides reach the same conclusion once the TTL has passed: the
+	// node stops using the units and the store hands them to someone else.
+	c.clock.Advance(testTTL / 2)
+	if got := a.Held("CPU"); got != 0 {
+		t.Fatalf("partitioned node still believes it holds %d units", got)
+	}
+	if _, err := b.Acquire(ctx, "CPU", 3); err != nil {
+		t.Fatalf("b could not take the expired units: %v", err)
+	}
+
+	link.cut.Store(false)
+	if _, err := a.Heartbeat(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := leased(c); got != 3 {
+		t.Fatalf("%d units leased, want b's 3", got)
+	}
+}
+
+func TestHealedNodeIsToldItsLeasesAreLost(t *testing.T) {
+	c := newCluster(t, 2)
+	ctx := context.Background()
+	a, link := c.node("a")
+
+	if _, err := a.Acquire(ctx, "CPU", 2); err != nil {
+		t.Fatal(err)
+	}
+	link.cut.Store(true)
+	c.clock.Advance(testTTL)
+	c.store.ExpireLeases()
+	link.cut.Store(false)
+
+	resp, err := a.Heartbeat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Renewed) != 0 || len(resp.Lost) != 1 {
+		t.Fatalf("heartbeat after heal: renewed %v, lost %v", resp.Renewed, resp.Lost)
+	}
+	a.mu.Lock()
+	remaining := len(a.leases)
+	a.mu.Unlock()
+	if remaining != 0 {
+		t.Fatalf("a still tracks %d leases after being told they are lost", remaining)
+	}
+}
+
+func TestPartitionedReleaseIsReclaimedByExpiry(t *testing.T) {
+	c := newCluster(t, 1)
+	ctx := context.Background()
+	a, link := c.node("a")
+
+	lease, err := a.Acquire(ctx, "CPU", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link.cut.Store(true)
+	if err := a.Release(ctx, lease.ID); err == nil {
+		t.Fatal("Release succeeded across a partition")
+	}
+	if got := leased(c); got != 1 {
+		t.Fatalf("leased = %d before expiry", got)
+	}
+	c.clock.Advance(testTTL)
+	if got := leased(c); got != 0 {
+		t.Fatalf("leased = %d after expiry", got)
+	}
+}
+
+func TestAdjustRateLimitFollowsDemand(t *testing.T) {
+	c := newCluster(t, 10)
+	ctx := context.Background()
+	a, _ := c.node("a")
+	b, _ := c.node("b")
+	d, _ := c.node("d")
+
+	a.SetDemand("CPU", 6)
+	b.SetDemand("CPU", 2)
+	d.SetDemand("CPU", 1)
+	for _, n := range []*QuotaClient{a, b, d} {
+		if _, err := n.Heartbeat(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.store.adjustRateLimit()
+
+	quotas := c.store.Status()["CPU"].Quotas
+	sum := 0
+	for _, q := range quotas {
+		sum += q
+	}
+	if sum != 10 {
+		t.Fatalf("quotas %v add up to %d, want the whole limit", quotas, sum)
+	}
+	if !(quotas["a"] > quotas["b"] && quotas["b"] >= quotas["d"] && quotas["d"] >= 1) {
+		t.Fatalf("quotas %v do not follow demand 6:2:1", quotas)
+	}
+
+	if _, err := b.Acquire(ctx, "CPU", quotas["b"]+1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Acquire beyond node quota = %v, want ErrQuotaExceeded", err)
+	}
+	resp, err := a.Heartbeat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Quotas["CPU"] != quotas["a"] {
+		t.Fatalf("heartbeat reports quota %d, want %d", resp.Quotas["CPU"], quotas["a"])
+	}
+
+	// A node that dies drops out of the next rebalance.
+	c.clock.Advance(testTTL / 2)
+	a.Heartbeat(ctx)
+	b.Heartbeat(ctx)
+	c.clock.Advance(testTTL / 2)
+	c.store.adjustRateLimit()
+	if _, ok := c.store.Status()["CPU"].Quotas["d"]; ok {
+		t.Fatal("dead node still has a quota")
+	}
+}
+
+func TestLimitHoldsUnderConcurrentAcquire(t *testing.T) {
+	c := newCluster(t, 5)
+	ctx := context.Background()
+
+	var granted atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n, _ := c.node(string(rune('a' + i)))
+			if _, err := n.Acquire(ctx, "CPU", 1); err == nil {
+				granted.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if granted.Load() != 5 || leased(c) != 5 {
+		t.Fatalf("granted %d, leased %d, want 5", granted.Load(), leased(c))
+	}
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TaskStatus is where a task is in its lifecycle.
+type TaskStatus string
+
+const (
+	StatusWaiting   TaskStatus = "waiting"   // prerequisites have not all succeeded yet
+	StatusScheduled TaskStatus = "scheduled" // RunAt is in the future
+	StatusReady     TaskStatus = "ready"     // queued by priority
+	StatusRunning   TaskStatus = "running"
+	StatusSucceeded TaskStatus = "succeeded"
+	StatusFailed    TaskStatus = "failed"
+	StatusCancelled TaskStatus = "cancelled"
+	StatusSkipped   TaskStatus = "skipped" // a prerequisite failed or was cancelled
+)
+
+func (s TaskStatus) terminal() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCancelled || s == StatusSkipped
+}
+
+var (
+	ErrTaskExists     = errors.New("task already exists")
+	ErrTaskNotFound   = errors.New("task not found")
+	ErrUnknownHandler = errors.New("no handler registered")
+	ErrTaskFinished   = errors.New("task already finished")
+)
+
+// HandlerFunc runs a task. Handlers are registered by name so queued tasks
+// can be written to disk and picked up again after a restart.
+type HandlerFunc func(ctx context.Context, args json.RawMessage) error
+
+// Task represents a task with a priority and the handler to execute.
+type Task struct {
+	ID        string          `json:"id"`
+	Handler   string          `json:"handler"`
+	Args      json.RawMessage `json:"args,omitempty"`
+	Priority  int             `json:"priority"`             // higher runs first
+	RunAt     time.Time       `json:"run_at"`               // zero means as soon as possible
+	Every     time.Duration   `json:"every,omitempty"`      // non-zero makes the task recurring
+	DependsOn []string        `json:"depends_on,omitempty"` // must all succeed first
+
+	Status    TaskStatus `json:"status"`
+	Runs      int        `json:"runs"`
+	LastRun   time.Time  `json:"last_run"`
+	LastError string     `json:"last_error,omitempty"`
+
+	seq    uint64 // insertion order, breaks priority ties
+	index  int    // position in whichever queue holds the task
+	cancel context.CancelFunc
+}
+
+// readyQueue orders tasks by priority, then by age.
+type readyQueue []*Task
+
+func (q readyQueue) Len() int { return len(q) }
+func (q readyQueue) Less(i, j int) bool {
+	if q[i].Priority != q[j].Priority {
+		return q[i].Priority > q[j].Priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q readyQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *readyQueue) Push(x interface{}) {
+	t := x.(*Task)
+	t.index = len(*q)
+	*q = append(*q, t)
+}
+func (q *readyQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*q = old[:n-1]
+	return t
+}
+
+// delayQueue orders scheduled tasks by when they are due.
+type delayQueue struct{ readyQueue }
+
+func (q delayQueue) Less(i, j int) bool { return q.readyQueue[i].RunAt.Before(q.readyQueue[j].RunAt) }
+
+// Scheduler runs tasks by priority once they are due and their
+// prerequisites have succeeded. Every change is saved to statePath.
+type Scheduler struct {
+	mu        sync.Mutex
+	handlers  map[string]HandlerFunc
+	tasks     map[string]*Task
+	ready     readyQueue
+	delayed   delayQueue
+	running   int
+	workers   int
+	seq       uint64
+	statePath string
+	stopping  bool // tasks interrupted by Stop are requeued, not failed
+
+	wake    chan struct{}
+	quit    chan struct{}
+	stopOne sync.Once // closes quit
+	stopped chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewScheduler returns a Scheduler running at most workers tasks at once.
+// If statePath holds a saved queue it is restored; tasks that were running
+// when the process stopped run again.
+func NewScheduler(workers int, statePath string) (*Scheduler, error) {
+	s := &Scheduler{
+		handlers:  make(map[string]HandlerFunc),
+		tasks:     make(map[string]*Task),
+		workers:   max(1, workers),
+		statePath: statePath,
+		wake:      make(chan struct{}, 1),
+		quit:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// RegisterHandler makes a handler available to tasks. Register every
+// handler before Start so restored tasks can find theirs.
+func (s *Scheduler) RegisterHandler(name string, fn HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[name] = fn
+}
+
+func (s *Scheduler) load() error {
+	data, err := os.ReadFile(s.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []*Task
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("reading %s: %w", s.statePath, err)
+	}
+	for _, t := range saved {
+		s.seq++
+		t.seq = s.seq
+		t.index = -1
+		s.tasks[t.ID] = t
+	}
+	// Queue in a second pass, once every prerequisite is known.
+	for _, t := range saved {
+		if t.Status == StatusRunning {
+			t.Status = StatusReady
+		}
+		if !t.Status.terminal() {
+			s.enqueueLocked(t)
+		}
+	}
+	return nil
+}
+
+// saveLocked writes the whole queue atomically. Failures are reported but
+// do not stop the scheduler; the next change retries the write.
+func (s *Scheduler) saveLocked() {
+	if s.statePath == "" {
+		return
+	}
+	list := make([]*Task, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].seq < list[j].seq })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err == nil {
+		tmp := s.statePath + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, s.statePath)
+		}
+	}
+	if err != nil {
+		fmt.Println("Saving scheduler state:", err)
+	}
+}
+
+// Add queues a task. It fails if the ID is taken, the handler is unknown or
+// a prerequisite does not exist; requiring prerequisites to exist first
+// also rules out cycles.
+func (s *Scheduler) Add(t Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.tasks[t.ID]; exists || t.ID == "" {
+		return fmt.Errorf("%w: %q", ErrTaskExists, t.ID)
+	}
+	if _, ok := s.handlers[t.Handler]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownHandler, t.Handler)
+	}
+	for _, dep := range t.DependsOn {
+		d, ok := s.tasks[dep]
+		if !ok {
+			return fmt.Errorf("%w: prerequisite %q", ErrTaskNotFound, dep)
+		}
+		if d.Every > 0 {
+			return fmt.Errorf("prerequisite %q is recurring and never finishes", dep)
+		}
+	}
+
+	task := &Task{
+		ID: t.ID, Handler: t.Handler, Args: t.Args, Priority: t.Priority,
+		RunAt: t.RunAt, Every: t.Every, DependsOn: append([]string(nil), t.DependsOn...),
+		index: -1,
+	}
+	s.seq++
+	task.seq = s.seq
+	s.tasks[task.ID] = task
+	s.enqueueLocked(task)
+	s.saveLocked()
+	s.signal()
+	return nil
+}
+
+// enqueueLocked puts a non-terminal task wherever its state says it belongs.
+func (s *Scheduler) enqueueLocked(t *Task) {
+	switch s.depsLocked(t) {
+	case StatusFailed:
+		t.Status = StatusSkipped
+		t.LastError = "a prerequisite did not succeed"
+		return
+	case StatusWaiting:
+		t.Status = StatusWaiting
+		return
+	}
+	if t.RunAt.After(time.Now()) {
+		t.Status = StatusScheduled
+		heap.Push(&s.delayed, t)
+		return
+	}
+	t.Status = StatusReady
+	heap.Push(&s.ready, t)
+}
+
+// depsLocked reports StatusSucceeded if every prerequisite succeeded,
+// StatusFailed if any never will, and StatusWaiting otherwise.
+func (s *Scheduler) depsLocked(t *Task) TaskStatus {
+	result := StatusSucceeded
+	for _, id := range t.DependsOn {
+		d, ok := s.tasks[id]
+		switch {
+		case !ok, d.Status.terminal() && d.Status != StatusSucceeded:
+			return StatusFailed
+		case d.Status != StatusSucceeded:
+			result = StatusWaiting
+		}
+	}
+	return result
+}
+
+// releaseDependentsLocked re-examines waiting tasks after id finished.
+// Skips cascade, since a skipped task is itself a failed prerequisite.
+func (s *Scheduler) releaseDependentsLocked(id string) {
+	for _, t := range s.tasks {
+		if t.Status != StatusWaiting {
+			continue
+		}
+		for _, dep := range t.DependsOn {
+			if dep == id {
+				s.enqueueLocked(t)
+				if t.Status == StatusSkipped {
+					s.releaseDependentsLocked(t.ID)
+				}
+				break
+			}
+		}
+	}
+}
+
+// Cancel stops a task. Queued tasks are removed, a running one has its
+// context cancelled, and a recurring one will not run again.
+func (s *Scheduler) Cancel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrTaskNotFound, id)
+	}
+	switch t.Status {
+	case StatusReady:
+		heap.Remove(&s.ready, t.index)
+	case StatusScheduled:
+		heap.Remove(&s.delayed, t.index)
+	case StatusRunning:
+		t.cancel()
+	case StatusWaiting:
+	default:
+		return fmt.Errorf("%w: %q is %s", ErrTaskFinished, id, t.Status)
+	}
+	t.Status = StatusCancelled
+	s.releaseDependentsLocked(id)
+	s.saveLocked()
+	return nil
+}
+
+// Status returns a copy of one task.
+func (s *Scheduler) Status(id string) (Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return Task{}, fmt.Errorf("%w: %q", ErrTaskNotFound, id)
+	}
+	return *t, nil
+}
+
+// Snapshot is the scheduler's state at one moment.
+type Snapshot struct {
+	Time    time.Time          `json:"time"`
+	Running int                `json:"running"`
+	Workers int                `json:"workers"`
+	Counts  map[TaskStatus]int `json:"counts"`
+	Tasks   []Task             `json:"tasks"`
+}
+
+// Snapshot returns every task in the order it was added.
+func (s *Scheduler) Snapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := Snapshot{Time: time.Now(), Running: s.running, Workers: s.workers, Counts: make(map[TaskStatus]int)}
+	for _, t := range s.tasks {
+		snap.Counts[t.Status]++
+		snap.Tasks = append(snap.Tasks, *t)
+	}
+	sort.Slice(snap.Tasks, func(i, j int) bool { return snap.Tasks[i].seq < snap.Tasks[j].seq })
+	return snap
+}
+
+// Handler serves GET /status with a Snapshot, GET /tasks/{id} with one
+// task and DELETE /tasks/{id} to cancel it.
+func (s *Scheduler) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Snapshot())
+	})
+	mux.HandleFunc("GET /tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		t, err := s.Status(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	})
+	mux.HandleFunc("DELETE /tasks/{id}", func(w http.ResponseWriter, r *http.Request) {
+		err := s.Cancel(r.PathValue("id"))
+		switch {
+		case errors.Is(err, ErrTaskNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		case err != nil:
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Scheduler) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start initializes the scheduler to process tasks
+func (s *Scheduler) Start() {
+	go s.dispatch()
+}
+
+// dispatch promotes due tasks and hands ready ones to free workers,
+// sleeping until the next task is due or something changes.
+func (s *Scheduler) dispatch() {
+	defer close(s.stopped)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		now := time.Now()
+		for s.delayed.Len() > 0 && !s.delayed.readyQueue[0].RunAt.After(now) {
+			t := heap.Pop(&s.delayed).(*Task)
+			t.Status = StatusReady
+			heap.Push(&s.ready, t)
+		}
+		for s.running < s.workers && s.ready.Len() > 0 {
+			s.startLocked(heap.Pop(&s.ready).(*Task))
+		}
+		next := time.Hour
+		if s.delayed.Len() > 0 {
+			next = s.delayed.readyQueue[0].RunAt.Sub(now)
+		}
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(next)
+		select {
+		case <-s.quit:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *Scheduler) startLocked(t *Task) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.Status = StatusRunning
+	t.Runs++
+	t.LastRun = time.Now()
+	s.running++
+	s.saveLocked()
+	fn := s.handlers[t.Handler]
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		err := runHandler(ctx, fn, t.Args)
+		s.finish(t, err)
+	}()
+}
+
+func runHandler(ctx context.Context, fn HandlerFunc, args json.RawMessage) (err error) {
+	if fn == nil {
+		return ErrUnknownHandler
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn(ctx, args)
+}
+
+func (s *Scheduler) finish(t *Task, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.signal()
+	s.running--
+	t.cancel = nil
+	t.LastError = ""
+	if err != nil {
+		t.LastError = err.Error()
+	}
+
+	switch {
+	case t.Status == StatusCancelled:
+	case s.stopping && err != nil:
+		t.Status = StatusReady // runs again after the next restart
+	case t.Every > 0:
+		// Recurring tasks keep their cadence whatever the outcome, and
+		// skip runs missed while the scheduler was busy or down.
+		next := t.RunAt
+		if next.IsZero() {
+			next = t.LastRun
+		}
+		for !next.After(time.Now()) {
+			next = next.Add(t.Every)
+		}
+		t.RunAt = next
+		t.Status = StatusScheduled
+		heap.Push(&s.delayed, t)
+	case err != nil:
+		t.Status = StatusFailed
+	default:
+		t.Status = StatusSucceeded
+	}
+	if t.Status.terminal() {
+		s.releaseDependentsLocked(t.ID)
+	}
+	s.saveLocked()
+}
+
+// Stop stops dispatching and waits for running tasks until ctx is done,
+// then cancels them. Unfinished tasks stay in the saved queue. It is safe
+// to call more than once.
+func (s *Scheduler) Stop(ctx context.Context) {
+	s.stopOne.Do(func() { close(s.quit) })
+	<-s.stopped
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.mu.Lock()
+		s.stopping = true
+		for _, t := range s.tasks {
+			if t.Status == StatusRunning {
+				t.cancel()
+			}
+		}
+		s.mu.Unlock()
+		<-done
+	}
+}
+
+func main() {
+	statePath := filepath.Join(os.TempDir(), "scheduler-state.json")
+	scheduler, err := NewScheduler(2, statePath)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	scheduler.RegisterHandler("print", func(ctx context.Context, args json.RawMessage) error {
+		var msg string
+		json.Unmarshal(args, &msg)
+		fmt.Printf("%s %s\n", time.Now().Format("15:04:05.000"), msg)
+		return nil
+	})
+	scheduler.RegisterHandler("sleep", func(ctx context.Context, args json.RawMessage) error {
+		var d time.Duration
+		json.Unmarshal(args, &d)
+		select {
+		case <-time.After(d):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	scheduler.RegisterHandler("fail", func(ctx context.Context, args json.RawMessage) error {
+		return errors.New("deliberate failure")
+	})
+
+	arg := func(v interface{}) json.RawMessage {
+		data, _ := json.Marshal(v)
+		return data
+	}
+	now := time.Now()
+	tasks := []Task{
+		{ID: "low", Handler: "print", Priority: 1, Args: arg("Task with priority 1 executed")},
+		{ID: "high", Handler: "print", Priority: 5, Args: arg("Task with priority 5 executed")},
+		{ID: "later", Handler: "print", Priority: 3, RunAt: now.Add(time.Second), Args: arg("Delayed task executed")},
+		{ID: "tick", Handler: "print", Every: 700 * time.Millisecond, Args: arg("Recurring task executed")},
+		{ID: "extract", Handler: "sleep", Args: arg(300 * time.Millisecond)},
+		{ID: "transform", Handler: "print", DependsOn: []string{"extract"}, Args: arg("transform ran after extract")},
+		{ID: "load", Handler: "fail", DependsOn: []string{"transform"}},
+		{ID: "report", Handler: "print", DependsOn: []string{"load"}, Args: arg("never printed: load fails")},
+		{ID: "slow", Handler: "sleep", RunAt: now.Add(10 * time.Second), Args: arg(time.Second)},
+	}
+	for _, t := range tasks {
+		// A restored queue already has these tasks.
+		if err := scheduler.Add(t); err != nil && !errors.Is(err, ErrTaskExists) {
+			fmt.Println("Add:", err)
+		}
+	}
+
+	scheduler.Start()
+	server := &http.Server{Addr: "localhost:8080", Handler: scheduler.Handler()}
+	go server.ListenAndServe()
+	fmt.Println("Status at http://localhost:8080/status; state saved to", statePath)
+
+	time.Sleep(3 * time.Second)
+	scheduler.Cancel("slow")
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	select {
+	case <-signalChan:
+	case <-time.After(2 * time.Second):
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(ctx)
+	scheduler.Stop(ctx)
+
+	for _, t := range scheduler.Snapshot().Tasks {
+		line := fmt.Sprintf("%-10s %-10s runs=%d", t.ID, t.Status, t.Runs)
+		if t.LastError != "" {
+			line += " error=" + t.LastError
+		}
+		fmt.Println(line)
+	}
+}
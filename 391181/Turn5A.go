@@ -0,0 +1,559 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Define a transient error type for demonstration
+type transientError struct {
+	message string
+}
+
+func (e transientError) Error() string {
+	return e.message
+}
+
+func (e transientError) Temporary() bool { return true }
+
+// ErrorClass decides what happens to a task after an error.
+type ErrorClass string
+
+const (
+	ClassTransient ErrorClass = "transient" // retried automatically from the last checkpoint
+	ClassCancelled ErrorClass = "cancelled" // paused; resumes on the next run
+	ClassPermanent ErrorClass = "permanent" // failed; only resumes when asked to
+)
+
+// Classify sorts err into an ErrorClass. Anything that does not say it is
+// temporary is treated as permanent.
+func Classify(err error) ErrorClass {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ClassCancelled
+	}
+	var temp interface{ Temporary() bool }
+	if errors.As(err, &temp) && temp.Temporary() {
+		return ClassTransient
+	}
+	return ClassPermanent
+}
+
+// TaskStatus is the state recorded in a checkpoint.
+type TaskStatus string
+
+const (
+	StatusRunning   TaskStatus = "running" // still running, or the process crashed
+	StatusPaused    TaskStatus = "paused"
+	StatusFailed    TaskStatus = "failed"
+	StatusDone      TaskStatus = "done"
+	StatusAbandoned TaskStatus = "abandoned"
+)
+
+// Checkpoint is the saved progress of one task.
+type Checkpoint struct {
+	TaskID    string          `json:"task_id"`
+	Kind      string          `json:"kind"` // which registered WorkFunc runs it
+	Args      json.RawMessage `json:"args,omitempty"`
+	Step      int             `json:"step"`
+	State     json.RawMessage `json:"state,omitempty"`
+	Status    TaskStatus      `json:"status"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	ErrorKind ErrorClass      `json:"error_class,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+var (
+	ErrNoCheckpoint = errors.New("no checkpoint")
+	ErrAbandoned    = errors.New("task was abandoned")
+	ErrFailed       = errors.New("task failed permanently; resume it explicitly")
+	ErrInvalidID    = errors.New("invalid task ID")
+)
+
+// CheckpointStore persists checkpoints. Save must be atomic: after a crash
+// Load returns either the old checkpoint or the new one, never a mix.
+type CheckpointStore interface {
+	Save(cp Checkpoint) error
+	Load(taskID string) (Checkpoint, error)
+	List() ([]Checkpoint, error)
+	Close() error
+}
+
+// FileStore keeps one JSON file per task in a directory.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// path maps a task ID to its file. IDs come from the command line, so
+// anything but a single plain file name is rejected rather than letting it
+// reach outside the store directory.
+func (s *FileStore) path(taskID string) (string, error) {
+	if taskID == "" || taskID == "." || taskID == ".." || filepath.Base(taskID) != taskID || strings.ContainsAny(taskID, `/\`) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidID, taskID)
+	}
+	return filepath.Join(s.dir, taskID+".json"), nil
+}
+
+func (s *FileStore) Save(cp Checkpoint) error {
+	path, err := s.path(cp.TaskID)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, cp.TaskID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) Load(taskID string) (Checkpoint, error) {
+	var cp Checkpoint
+	path, err := s.path(taskID)
+	if err != nil {
+		return cp, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, fmt.Errorf("%w for %s", ErrNoCheckpoint, taskID)
+	}
+	if err != nil {
+		return cp, err
+	}
+	return cp, json.Unmarshal(data, &cp)
+}
+
+func (s *FileStore) List() ([]Checkpoint, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var list []Checkpoint
+	for _, name := range names {
+		cp, err := s.Load(strings.TrimSuffix(filepath.Base(name), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, cp)
+	}
+	return list, nil
+}
+
+func (s *FileStore) Close() error { return nil }
+
+var checkpointBucket = []byte("checkpoints")
+
+// BoltStore keeps checkpoints in a bbolt database, one key per task.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(checkpointBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Save(cp Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointBucket).Put([]byte(cp.TaskID), data)
+	})
+}
+
+func (s *BoltStore) Load(taskID string) (Checkpoint, error) {
+	var cp Checkpoint
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(checkpointBucket).Get([]byte(taskID))
+		if data == nil {
+			return fmt.Errorf("%w for %s", ErrNoCheckpoint, taskID)
+		}
+		return json.Unmarshal(data, &cp)
+	})
+	return cp, err
+}
+
+func (s *BoltStore) List() ([]Checkpoint, error) {
+	var list []Checkpoint
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(checkpointBucket).ForEach(func(k, v []byte) error {
+			var cp Checkpoint
+			if err := json.Unmarshal(v, &cp); err != nil {
+				return fmt.Errorf("checkpoint %s: %w", k, err)
+			}
+			list = append(list, cp)
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (s *BoltStore) Close() error { return s.db.Close() }
+
+// Progress is handed to a running task. The task reads where to start from
+// Step and State, and reports each finished step through Advance.
+type Progress struct {
+	Step  int
+	State json.RawMessage
+
+	cp       *Checkpoint
+	store    CheckpointStore
+	every    time.Duration
+	lastSave time.Time
+}
+
+// Advance records that work up to step is done, with state describing it.
+// The checkpoint is written at most once per checkpoint interval, so a crash
+// loses at most that much work.
+func (p *Progress) Advance(step int, state interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	p.Step, p.State = step, data
+	if time.Since(p.lastSave) < p.every {
+		return nil
+	}
+	return p.flush()
+}
+
+func (p *Progress) flush() error {
+	p.cp.Step, p.cp.State = p.Step, p.State
+	p.cp.UpdatedAt = time.Now()
+	p.lastSave = p.cp.UpdatedAt
+	return p.store.Save(*p.cp)
+}
+
+// WorkFunc does the task's work starting from p.Step.
+type WorkFunc func(ctx context.Context, args json.RawMessage, p *Progress) error
+
+// Runner runs checkpointed tasks and resumes them after errors and restarts.
+type Runner struct {
+	Store           CheckpointStore
+	Work            map[string]WorkFunc
+	MaxRetries      int
+	BaseDelay       time.Duration
+	CheckpointEvery time.Duration
+}
+
+// Start runs a new task, or resumes it if a checkpoint exists.
+func (r *Runner) Start(ctx context.Context, taskID, kind string, args interface{}) error {
+	cp, err := r.Store.Load(taskID)
+	if errors.Is(err, ErrNoCheckpoint) {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return err
+		}
+		cp = Checkpoint{TaskID: taskID, Kind: kind, Args: data, Status: StatusRunning, UpdatedAt: time.Now()}
+		if err := r.Store.Save(cp); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return r.run(ctx, cp)
+}
+
+// Resume continues a task from its checkpoint. Unlike Start it also
+// resumes tasks that failed permanently.
+func (r *Runner) Resume(ctx context.Context, taskID string) error {
+	cp, err := r.Store.Load(taskID)
+	if err != nil {
+		return err
+	}
+	if cp.Status == StatusFailed {
+		cp.Status = StatusPaused
+	}
+	return r.run(ctx, cp)
+}
+
+// Abandon marks a task so it is never resumed.
+func (r *Runner) Abandon(taskID string) error {
+	cp, err := r.Store.Load(taskID)
+	if err != nil {
+		return err
+	}
+	cp.Status = StatusAbandoned
+	cp.UpdatedAt = time.Now()
+	return r.Store.Save(cp)
+}
+
+func (r *Runner) run(ctx context.Context, cp Checkpoint) error {
+	switch cp.Status {
+	case StatusDone:
+		return nil
+	case StatusAbandoned:
+		return fmt.Errorf("%s: %w", cp.TaskID, ErrAbandoned)
+	case StatusFailed:
+		return fmt.Errorf("%s: %w: %s", cp.TaskID, ErrFailed, cp.LastError)
+	}
+	work, ok := r.Work[cp.Kind]
+	if !ok {
+		return fmt.Errorf("%s: no work registered for kind %q", cp.TaskID, cp.Kind)
+	}
+
+	for retry := 0; ; retry++ {
+		cp.Status = StatusRunning
+		cp.Attempts++
+		p := &Progress{Step: cp.Step, State: cp.State, cp: &cp, store: r.Store, every: r.CheckpointEvery, lastSave: time.Now()}
+		if err := r.Store.Save(cp); err != nil {
+			return err
+		}
+
+		err := work(ctx, cp.Args, p)
+		// Whatever happened, the last step the task reported is complete.
+		if err == nil {
+			cp.Status, cp.LastError, cp.ErrorKind = StatusDone, "", ""
+			return p.flush()
+		}
+		cp.LastError, cp.ErrorKind = err.Error(), Classify(err)
+
+		if cp.ErrorKind == ClassTransient && retry < r.MaxRetries {
+			// Keep the progress and go again after a backoff.
+			if ferr := p.flush(); ferr != nil {
+				return fmt.Errorf("%s: %w", cp.TaskID, errors.Join(err, ferr))
+			}
+			delay := r.BaseDelay << retry
+			fmt.Printf("Task %s: %v; resuming from step %d in %v\n", cp.TaskID, err, cp.Step, delay)
+			select {
+			case <-time.After(delay):
+				continue
+			case <-ctx.Done():
+				err = ctx.Err()
+				cp.LastError, cp.ErrorKind = err.Error(), ClassCancelled
+			}
+		}
+
+		switch cp.ErrorKind {
+		case ClassPermanent:
+			cp.Status = StatusFailed
+		case ClassTransient:
+			cp.Status = StatusPaused
+			err = fmt.Errorf("giving up after %d retries, will resume on the next run: %w", retry, err)
+		default:
+			cp.Status = StatusPaused
+		}
+		if ferr := p.flush(); ferr != nil {
+			err = errors.Join(err, ferr)
+		}
+		return fmt.Errorf("%s: %w", cp.TaskID, err)
+	}
+}
+
+// countArgs configures the demo work: process Steps items, failing
+// transiently now and then, and permanently at FailAt if set.
+type countArgs struct {
+	Steps  int `json:"steps"`
+	FailAt int `json:"fail_at,omitempty"`
+}
+
+// longRunningOperation processes items one at a time, resuming after the
+// last checkpointed item.
+func longRunningOperation(ctx context.Context, rawArgs json.RawMessage, p *Progress) error {
+	var args countArgs
+	if err := json.Unmarshal(rawArgs, &args); err != nil {
+		return err
+	}
+	var sum int
+	if p.State != nil {
+		json.Unmarshal(p.State, &sum)
+	}
+	for step := p.Step + 1; step <= args.Steps; step++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond): // Simulate work on one item
+		}
+		if step == args.FailAt {
+			return fmt.Errorf("item %d is corrupt", step)
+		}
+		if rand.Float32() < 0.05 {
+			return transientError{fmt.Sprintf("transient error at item %d", step)}
+		}
+		sum += step
+		if err := p.Advance(step, sum); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// longRunningTask runs one checkpointed operation for the demo.
+func longRunningTask(ctx context.Context, id int, runner *Runner, wg *sync.WaitGroup, errCh chan<- error) {
+	defer wg.Done() // Mark this Goroutine as done when the function exits
+
+	args := countArgs{Steps: 20 + 10*id}
+	if id == 5 {
+		args.FailAt = 25
+	}
+	taskID := fmt.Sprintf("task-%d", id)
+	if err := runner.Start(ctx, taskID, "count", args); err != nil {
+		errCh <- err
+		return
+	}
+	fmt.Printf("Task %s completed successfully\n", taskID)
+}
+
+func openStore(kind, path string) (CheckpointStore, error) {
+	switch kind {
+	case "file":
+		return NewFileStore(path)
+	case "bolt":
+		return NewBoltStore(path)
+	}
+	return nil, fmt.Errorf("unknown store %q (want file or bolt)", kind)
+}
+
+func printList(store CheckpointStore) error {
+	list, err := store.List()
+	if err != nil {
+		return err
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].TaskID < list[j].TaskID })
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tSTATUS\tSTEP\tATTEMPTS\tUPDATED\tLAST ERROR")
+	for _, cp := range list {
+		lastErr := cp.LastError
+		if cp.ErrorKind != "" {
+			lastErr = fmt.Sprintf("[%s] %s", cp.ErrorKind, lastErr)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", cp.TaskID, cp.Status, cp.Step, cp.Attempts, cp.UpdatedAt.Format(time.TimeOnly), lastErr)
+	}
+	return w.Flush()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: %s [-store file|bolt] [-path p] <command>
+
+commands:
+  run            start the demo tasks, resuming any with checkpoints
+  list           show every task's checkpoint
+  resume <id>    continue a paused or failed task
+  abandon <id>   mark a task so it is never resumed
+`, filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+}
+
+func main() {
+	storeKind := flag.String("store", "file", "checkpoint store: file or bolt")
+	storePath := flag.String("path", "", "store directory (file) or database file (bolt)")
+	timeout := flag.Duration("timeout", 10*time.Second, "stop running tasks after this long")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *storePath == "" {
+		*storePath = map[string]string{"file": "checkpoints", "bolt": "checkpoints.db"}[*storeKind]
+	}
+
+	store, err := openStore(*storeKind, *storePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	runner := &Runner{
+		Store:           store,
+		Work:            map[string]WorkFunc{"count": longRunningOperation},
+		MaxRetries:      3,
+		BaseDelay:       200 * time.Millisecond,
+		CheckpointEvery: 300 * time.Millisecond,
+	}
+
+	// Ctrl+C pauses running tasks; they resume from their checkpoint next time.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	switch cmd := flag.Arg(0); cmd {
+	case "run":
+		var wg sync.WaitGroup
+		errCh := make(chan error, 5) // Buffered channel to collect errors
+		for i := 1; i <= 5; i++ {
+			wg.Add(1)
+			go longRunningTask(ctx, i, runner, &wg, errCh)
+		}
+		wg.Wait()
+		close(errCh)
+
+		for err := range errCh {
+			fmt.Printf("Error (%s): %v\n", Classify(err), err)
+		}
+		err = printList(store)
+	case "list":
+		err = printList(store)
+	case "resume", "abandon":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		if cmd == "abandon" {
+			err = runner.Abandon(flag.Arg(1))
+		} else {
+			err = runner.Resume(ctx, flag.Arg(1))
+		}
+		if err == nil {
+			err = printList(store)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
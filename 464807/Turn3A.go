@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// PluralRule picks the plural category ("one", "few", ...) for a count.
+type PluralRule func(n int) string
+
+// pluralRules follow CLDR for integer counts. Languages not listed use English rules.
+var pluralRules = map[string]PluralRule{
+	"en": func(n int) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"fr": func(n int) string {
+		if n == 0 || n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"pl": func(n int) string {
+		switch {
+		case n == 1:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	},
+}
+
+// pluralCategories lists the categories each rule can return for integers,
+// which is what lint expects every plural message to define.
+var pluralCategories = map[string][]string{
+	"en": {"one", "other"},
+	"fr": {"one", "other"},
+	"pl": {"one", "few", "many"},
+}
+
+func baseLanguage(tag string) string {
+	lang, _, _ := strings.Cut(tag, "-")
+	return lang
+}
+
+func pluralRuleFor(tag string) (PluralRule, []string) {
+	lang := baseLanguage(tag)
+	if rule, ok := pluralRules[lang]; ok {
+		return rule, pluralCategories[lang]
+	}
+	return pluralRules["en"], pluralCategories["en"]
+}
+
+// Message is a plain string or a set of plural forms keyed by category.
+type Message struct {
+	Text   string
+	Plural map[string]string
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &m.Text); err == nil {
+		return nil
+	}
+	return json.Unmarshal(data, &m.Plural)
+}
+
+func (m *Message) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&m.Text)
+	}
+	return node.Decode(&m.Plural)
+}
+
+// Catalog holds one locale's messages.
+type Catalog struct {
+	Locale   string
+	File     string
+	Messages map[string]Message
+}
+
+// Bundle is every catalog loaded from one directory. Bundles are immutable
+// once built, so a reload swaps in a whole new one.
+type Bundle struct {
+	Default  string
+	Catalogs map[string]*Catalog
+	loadedAt time.Time
+}
+
+// normalizeTag turns "fr_ca" or "FR-ca" into "fr-CA".
+func normalizeTag(tag string) string {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"), "-")
+	parts[0] = strings.ToLower(parts[0])
+	for i := 1; i < len(parts); i++ {
+		if len(parts[i]) == 2 {
+			parts[i] = strings.ToUpper(parts[i])
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// LoadBundle reads every .json, .yaml and .yml file in dir. The file name
+// (without extension) is the locale, e.g. "fr-CA.json".
+func LoadBundle(dir, defaultLocale string) (*Bundle, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{Default: normalizeTag(defaultLocale), Catalogs: make(map[string]*Catalog), loadedAt: time.Now()}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".json" && ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		messages := make(map[string]Message)
+		if ext == ".json" {
+			err = json.Unmarshal(data, &messages)
+		} else {
+			err = yaml.Unmarshal(data, &messages)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		locale := normalizeTag(strings.TrimSuffix(e.Name(), ext))
+		if _, dup := b.Catalogs[locale]; dup {
+			return nil, fmt.Errorf("locale %s is defined by more than one file", locale)
+		}
+		b.Catalogs[locale] = &Catalog{Locale: locale, File: path, Messages: messages}
+	}
+	if _, ok := b.Catalogs[b.Default]; !ok {
+		return nil, fmt.Errorf("no catalog for default locale %s in %s", b.Default, dir)
+	}
+	return b, nil
+}
+
+// Chain is the fallback order for a locale: "fr-CA" gives fr-CA, fr, then
+// the default, keeping only locales that have a catalog.
+func (b *Bundle) Chain(locale string) []string {
+	var chain []string
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		if _, ok := b.Catalogs[tag]; ok && !seen[tag] {
+			seen[tag] = true
+			chain = append(chain, tag)
+		}
+	}
+	tag := normalizeTag(locale)
+	for {
+		add(tag)
+		i := strings.LastIndex(tag, "-")
+		if i < 0 {
+			break
+		}
+		tag = tag[:i]
+	}
+	add(b.Default)
+	return chain
+}
+
+// Localizer translates messages for one negotiated locale.
+type Localizer struct {
+	Locale string
+	chain  []string
+	bundle *Bundle
+}
+
+func (b *Bundle) Localizer(locale string) *Localizer {
+	chain := b.Chain(locale)
+	return &Localizer{Locale: chain[0], chain: chain, bundle: b}
+}
+
+// T returns the message for key with {placeholders} filled in from args.
+// A "count" argument selects the plural form using the rules of the locale
+// the message came from. Missing keys come back as the key itself.
+func (l *Localizer) T(key string, args map[string]interface{}) string {
+	for _, locale := range l.chain {
+		msg, ok := l.bundle.Catalogs[locale].Messages[key]
+		if !ok {
+			continue
+		}
+		text := msg.Text
+		if msg.Plural != nil {
+			count, _ := args["count"].(int)
+			rule, _ := pluralRuleFor(locale)
+			var found bool
+			if text, found = msg.Plural[rule(count)]; !found {
+				text = msg.Plural["other"]
+			}
+		}
+		return interpolate(text, args)
+	}
+	return key
+}
+
+func interpolate(text string, args map[string]interface{}) string {
+	if len(args) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(args))
+	for name, v := range args {
+		pairs = append(pairs, "{"+name+"}", fmt.Sprint(v))
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// BundleStore holds the current bundle and reloads it when a catalog changes.
+type BundleStore struct {
+	dir           string
+	defaultLocale string
+	current       atomic.Pointer[Bundle]
+	lastChange    time.Time // newest change already acted on, good or bad
+}
+
+func NewBundleStore(dir, defaultLocale string) (*BundleStore, error) {
+	s := &BundleStore{dir: dir, defaultLocale: defaultLocale}
+	b, err := LoadBundle(dir, defaultLocale)
+	if err != nil {
+		return nil, err
+	}
+	s.current.Store(b)
+	s.lastChange = b.loadedAt
+	return s, nil
+}
+
+func (s *BundleStore) Bundle() *Bundle { return s.current.Load() }
+
+// latestChange returns the newest modification time in the catalog
+// directory, including the directory itself so added and removed files count.
+func (s *BundleStore) latestChange() (time.Time, error) {
+	info, err := os.Stat(s.dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := info.ModTime()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, e := range entries {
+		if fi, err := e.Info(); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Watch polls the catalog directory and reloads on change. A catalog that
+// fails to parse is reported and the previous bundle stays in use.
+func (s *BundleStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := s.latestChange()
+		if err != nil || !changed.After(s.lastChange) {
+			continue
+		}
+		s.lastChange = changed
+		b, err := LoadBundle(s.dir, s.defaultLocale)
+		if err != nil {
+			fmt.Println("Catalog reload failed, keeping previous catalogs:", err)
+			continue
+		}
+		s.current.Store(b)
+		fmt.Printf("Reloaded %d catalogs from %s\n", len(b.Catalogs), s.dir)
+	}
+}
+
+type weightedTag struct {
+	tag string
+	q   float64
+}
+
+// parseAcceptLanguage returns the tags in an Accept-Language header, most
+// preferred first. Tags with q=0 and the "*" wildcard are dropped.
+func parseAcceptLanguage(header string) []string {
+	var tags []weightedTag
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		tags = append(tags, weightedTag{normalizeTag(tag), q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// Negotiate picks a locale from, in order, the lang (or legacy locale) query
+// parameter, the lang cookie and the Accept-Language header. The first
+// candidate whose fallback chain reaches a real catalog other than the
+// default wins; otherwise the default locale is used.
+func (b *Bundle) Negotiate(r *http.Request) string {
+	var candidates []string
+	query := r.URL.Query()
+	candidates = append(candidates, query["lang"]...)
+	candidates = append(candidates, query["locale"]...)
+	if c, err := r.Cookie("lang"); err == nil {
+		candidates = append(candidates, c.Value)
+	}
+	candidates = append(candidates, parseAcceptLanguage(r.Header.Get("Accept-Language"))...)
+
+	for _, c := range candidates {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		if chain := b.Chain(c); chain[0] != b.Default || baseLanguage(normalizeTag(c)) == baseLanguage(b.Default) {
+			return chain[0]
+		}
+	}
+	return b.Default
+}
+
+type localizerKey struct{}
+
+// localizationMiddleware negotiates the request's locale and stores a
+// Localizer in its context. A lang query parameter is remembered in a cookie.
+func localizationMiddleware(store *BundleStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bundle := store.Bundle()
+		locale := bundle.Negotiate(r)
+		if r.URL.Query().Has("lang") {
+			http.SetCookie(w, &http.Cookie{Name: "lang", Value: locale, Path: "/", MaxAge: 365 * 24 * 3600, SameSite: http.SameSiteLaxMode})
+		}
+		w.Header().Set("Content-Language", locale)
+		w.Header().Add("Vary", "Accept-Language, Cookie")
+
+		ctx := context.WithValue(r.Context(), localizerKey{}, bundle.Localizer(locale))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// LocalizerFrom returns the Localizer set by localizationMiddleware.
+func LocalizerFrom(ctx context.Context) *Localizer {
+	l, _ := ctx.Value(localizerKey{}).(*Localizer)
+	return l
+}
+
+// LintIssue is one problem found by Lint.
+type LintIssue struct {
+	Locale  string
+	Key     string
+	Problem string
+	Error   bool // warnings are keys a regional locale inherits from its parent
+}
+
+// Lint compares every catalog against the default one. Keys missing from
+// a language catalog are errors; keys a regional catalog (fr-CA) leaves to
+// its parent are warnings. It also checks plural forms and placeholders.
+func Lint(b *Bundle) []LintIssue {
+	var issues []LintIssue
+	reference := b.Catalogs[b.Default]
+
+	locales := make([]string, 0, len(b.Catalogs))
+	for l := range b.Catalogs {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+	keys := make([]string, 0, len(reference.Messages))
+	for k := range reference.Messages {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, locale := range locales {
+		cat := b.Catalogs[locale]
+		chain := b.Chain(locale)
+		_, categories := pluralRuleFor(locale)
+
+		for _, key := range keys {
+			ref := reference.Messages[key]
+			msg, ok := cat.Messages[key]
+			if !ok {
+				from := b.Default
+				for _, parent := range chain[1:] {
+					if _, ok := b.Catalogs[parent].Messages[key]; ok {
+						from = parent
+						break
+					}
+				}
+				regional := strings.Contains(locale, "-") && from != b.Default
+				issues = append(issues, LintIssue{locale, key, "missing, falls back to " + from, !regional})
+				continue
+			}
+			if (ref.Plural != nil) != (msg.Plural != nil) {
+				issues = append(issues, LintIssue{locale, key, "plural in one locale but not the other", true})
+				continue
+			}
+			for _, c := range categories {
+				if _, ok := msg.Plural[c]; msg.Plural != nil && !ok {
+					issues = append(issues, LintIssue{locale, key, fmt.Sprintf("missing plural form %q", c), true})
+				}
+			}
+			if want, got := placeholders(ref), placeholders(msg); want != got {
+				issues = append(issues, LintIssue{locale, key, fmt.Sprintf("placeholders %s, want %s", got, want), true})
+			}
+		}
+		for key := range cat.Messages {
+			if _, ok := reference.Messages[key]; !ok {
+				issues = append(issues, LintIssue{locale, key, "not in the default catalog", false})
+			}
+		}
+	}
+	return issues
+}
+
+// placeholders returns the sorted, de-duplicated {names} used by a message.
+func placeholders(m Message) string {
+	texts := []string{m.Text}
+	for _, t := range m.Plural {
+		texts = append(texts, t)
+	}
+	set := make(map[string]bool)
+	for _, t := range texts {
+		for {
+			start := strings.IndexByte(t, '{')
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(t[start:], '}')
+			if end < 0 {
+				break
+			}
+			set[t[start:start+end+1]] = true
+			t = t[start+end+1:]
+		}
+	}
+	names := make([]string, 0, len(set))
+	for n := range set {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return "[" + strings.Join(names, " ") + "]"
+}
+
+func loadConfiguration(configPath string) error {
+	viper.SetConfigFile(configPath)
+	viper.SetDefault("catalog_dir", "locales")
+	viper.SetDefault("reload_interval", "2s")
+
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("Error reading configuration file: %w", err)
+	}
+	if !viper.IsSet("default_localization") {
+		return fmt.Errorf("Missing required key: default_localization in configuration file")
+	}
+	return nil
+}
+
+func main() {
+	configPath := "Turn3A.yaml"
+	if err := loadConfiguration(configPath); err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	store, err := NewBundleStore(viper.GetString("catalog_dir"), viper.GetString("default_localization"))
+	if err != nil {
+		fmt.Printf("Error loading catalogs: %v\n", err)
+		os.Exit(1)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "lint" {
+		failed := false
+		for _, issue := range Lint(store.Bundle()) {
+			level := "warning"
+			if issue.Error {
+				level, failed = "error", true
+			}
+			fmt.Printf("%s: %s: %s: %s\n", level, issue.Locale, issue.Key, issue.Problem)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	go store.Watch(context.Background(), viper.GetDuration("reload_interval"))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		l := LocalizerFrom(r.Context())
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			name = "World"
+		}
+		count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+
+		fmt.Fprintln(w, l.T("greeting", map[string]interface{}{"name": name}))
+		fmt.Fprintln(w, l.T("inbox.count", map[string]interface{}{"count": count}))
+		fmt.Fprintln(w, l.T("cart.items", map[string]interface{}{"count": count}))
+		fmt.Fprintln(w, l.T("farewell", nil))
+	})
+
+	fmt.Println("Listening on :8080, e.g. curl -H 'Accept-Language: fr-CA,fr;q=0.8' 'localhost:8080/?count=2'")
+	http.ListenAndServe(":8080", localizationMiddleware(store, mux))
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sample struct to represent a user
+type User struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Role  string `json:"role,omitempty"` // filled in from the user's detail endpoint
+}
+
+// Semaphore is a weighted semaphore. Waiters are served in order, so a
+// large request is not starved by a stream of small ones, and a waiter
+// whose context ends gives up without taking any capacity.
+type Semaphore struct {
+	mu      sync.Mutex
+	size    int64
+	cur     int64
+	waiters list.List // of *waiter
+}
+
+type waiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+func NewSemaphore(size int64) *Semaphore {
+	return &Semaphore{size: size}
+}
+
+// Acquire takes n units, blocking until they are free or ctx is done.
+func (s *Semaphore) Acquire(ctx context.Context, n int64) error {
+	s.mu.Lock()
+	if n > s.size {
+		s.mu.Unlock()
+		return fmt.Errorf("semaphore: acquiring %d of %d can never succeed", n, s.size)
+	}
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+	w := &waiter{n: n, ready: make(chan struct{})}
+	elem := s.waiters.PushBack(w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// Granted while we were giving up; hand the units back.
+			s.cur -= n
+			s.notifyLocked()
+		default:
+			isFront := s.waiters.Front() == elem
+			s.waiters.Remove(elem)
+			// Removing the head may let the waiters behind it proceed.
+			if isFront {
+				s.notifyLocked()
+			}
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// TryAcquire takes n units only if they are free right now.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size-s.cur >= n && s.waiters.Len() == 0 {
+		s.cur += n
+		return true
+	}
+	return false
+}
+
+// Release returns n units.
+func (s *Semaphore) Release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cur -= n
+	if s.cur < 0 {
+		panic("semaphore: released more than held")
+	}
+	s.notifyLocked()
+}
+
+func (s *Semaphore) notifyLocked() {
+	for {
+		front := s.waiters.Front()
+		if front == nil {
+			return
+		}
+		w := front.Value.(*waiter)
+		if s.size-s.cur < w.n {
+			return // keep FIFO order: nobody jumps the head of the queue
+		}
+		s.cur += w.n
+		s.waiters.Remove(front)
+		close(w.ready)
+	}
+}
+
+// usersPage is one page of the users API. The next page comes from a
+// Link rel="next" header if there is one, otherwise from NextCursor.
+type usersPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// statusError is returned for non-2xx responses.
+type statusError struct {
+	code int
+	url  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.url, e.code, http.StatusText(e.code))
+}
+
+func retryable(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusTooManyRequests || se.code >= 500
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// UserResult is one line of the NDJSON stream.
+type UserResult struct {
+	User  *User  `json:"user,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Fetcher pages through the users API and fetches each user's details.
+// Every request holds units of Sem, including across its retries:
+// PageWeight for a page, UserWeight for a detail, so pages can cost more.
+type Fetcher struct {
+	Client     *http.Client
+	BaseURL    string
+	Sem        *Semaphore
+	PageWeight int64
+	UserWeight int64
+	MaxRetries int
+	RetryDelay time.Duration
+}
+
+// makeAPIRequest performs a GET, retrying failures that may succeed on
+// another attempt. The caller holds the semaphore.
+func (f *Fetcher) makeAPIRequest(ctx context.Context, rawURL string) (*http.Response, []byte, error) {
+	var lastErr error
+	for attempt := 0; attempt <= f.MaxRetries; attempt++ {
+		if attempt > 0 {
+			delay := f.RetryDelay << (attempt - 1)
+			select {
+			case <-time.After(delay + time.Duration(rand.Int63n(int64(delay)/2+1))):
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			}
+		}
+		resp, body, err := f.get(ctx, rawURL)
+		if err == nil {
+			return resp, body, nil
+		}
+		lastErr = err
+		if !retryable(err) {
+			break
+		}
+	}
+	return nil, nil, fmt.Errorf("giving up on %s: %w", rawURL, lastErr)
+}
+
+func (f *Fetcher) get(ctx context.Context, rawURL string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, nil, &statusError{resp.StatusCode, rawURL}
+	}
+	return resp, body, nil
+}
+
+// nextLink returns the rel="next" target of a Link header, resolved
+// against the request URL.
+func nextLink(resp *http.Response) string {
+	for _, header := range resp.Header.Values("Link") {
+		for _, link := range strings.Split(header, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.Contains(params, `rel="next"`) {
+				continue
+			}
+			u, err := resp.Request.URL.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+			if err == nil {
+				return u.String()
+			}
+		}
+	}
+	return ""
+}
+
+// fetchUsers walks every page, calling onPage for each one in order. It
+// returns when the last page has been handed over or on the first error.
+func (f *Fetcher) fetchUsers(ctx context.Context, onPage func([]User) error) error {
+	next := f.BaseURL + "/users"
+	for next != "" {
+		if err := f.Sem.Acquire(ctx, f.PageWeight); err != nil {
+			return err
+		}
+		resp, body, err := f.makeAPIRequest(ctx, next)
+		f.Sem.Release(f.PageWeight)
+		if err != nil {
+			return err
+		}
+		var page usersPage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return fmt.Errorf("decoding %s: %w", next, err)
+		}
+		if err := onPage(page.Users); err != nil {
+			return err
+		}
+
+		next = nextLink(resp)
+		if next == "" && page.NextCursor != "" {
+			u, _ := url.Parse(f.BaseURL + "/users")
+			u.RawQuery = url.Values{"cursor": {page.NextCursor}}.Encode()
+			next = u.String()
+		}
+	}
+	return nil
+}
+
+// processUser fetches the user's details. The caller holds UserWeight.
+func (f *Fetcher) processUser(ctx context.Context, user User) (User, error) {
+	_, body, err := f.makeAPIRequest(ctx, f.BaseURL+"/users/"+strconv.Itoa(user.ID))
+	if err != nil {
+		return user, err
+	}
+	var detail User
+	if err := json.Unmarshal(body, &detail); err != nil {
+		return user, err
+	}
+	return detail, nil
+}
+
+// processUsersConcurrently pages through the API and processes users as
+// their page arrives, sending each result to out as soon as it is ready.
+// A user's goroutine is only started once it holds its semaphore units
+// and keeps them until it finishes, so a huge page cannot spawn thousands
+// of goroutines. It closes out and returns the first page error, if any.
+func (f *Fetcher) processUsersConcurrently(ctx context.Context, out chan<- UserResult) error {
+	defer close(out)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	send := func(r UserResult) {
+		select {
+		case out <- r:
+		case <-ctx.Done():
+		}
+	}
+	return f.fetchUsers(ctx, func(users []User) error {
+		for _, user := range users {
+			if err := f.Sem.Acquire(ctx, f.UserWeight); err != nil {
+				return err
+			}
+			wg.Add(1)
+			go func(user User) {
+				defer wg.Done()
+				defer f.Sem.Release(f.UserWeight)
+				detail, err := f.processUser(ctx, user)
+				if err != nil {
+					send(UserResult{Error: fmt.Sprintf("user %d: %v", user.ID, err)})
+					return
+				}
+				send(UserResult{User: &detail})
+			}(user)
+		}
+		return nil
+	})
+}
+
+// handleUsersRequest streams users as NDJSON while they are still being
+// fetched. A failure part-way through is reported as a final error line,
+// since the 200 status has already been sent.
+func handleUsersRequest(f *Fetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		results := make(chan UserResult)
+		fetchErr := make(chan error, 1)
+		go func() { fetchErr <- f.processUsersConcurrently(ctx, results) }()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		rc := http.NewResponseController(w)
+		enc := json.NewEncoder(w)
+		for res := range results {
+			if err := enc.Encode(res); err != nil {
+				cancel() // client went away
+				continue
+			}
+			rc.Flush()
+		}
+		if err := <-fetchErr; err != nil && ctx.Err() == nil {
+			log.Println("Error fetching users:", err)
+			enc.Encode(UserResult{Error: err.Error()})
+		}
+	}
+}
+
+// newFakeAPI serves paginated users for the demo, with some latency and
+// an occasional 503.
+func newFakeAPI(total, pageSize int) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(50+rand.Intn(50)) * time.Millisecond)
+		if rand.Intn(5) == 0 {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+		page := usersPage{}
+		for id := start + 1; id <= min(start+pageSize, total); id++ {
+			page.Users = append(page.Users, User{ID: id, Name: fmt.Sprintf("user%d", id), Email: fmt.Sprintf("user%d@example.com", id)})
+		}
+		if start+pageSize < total {
+			page.NextCursor = strconv.Itoa(start + pageSize)
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+	mux.HandleFunc("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(20+rand.Intn(80)) * time.Millisecond)
+		id, _ := strconv.Atoi(r.PathValue("id"))
+		json.NewEncoder(w).Encode(User{ID: id, Name: fmt.Sprintf("user%d", id), Email: fmt.Sprintf("user%d@example.com", id), Role: []string{"admin", "member"}[id%2]})
+	})
+	return httptest.NewServer(mux)
+}
+
+func main() {
+	api := newFakeAPI(25, 10)
+	defer api.Close()
+
+	fetcher := &Fetcher{
+		Client:     api.Client(),
+		BaseURL:    api.URL,
+		Sem:        NewSemaphore(4),
+		PageWeight: 2,
+		UserWeight: 1,
+		MaxRetries: 3,
+		RetryDelay: 100 * time.Millisecond,
+	}
+
+	http.HandleFunc("/process-users", handleUsersRequest(fetcher))
+	fmt.Println("Server running on :8080; try curl -N localhost:8080/process-users")
+	log.Fatal(http.ListenAndServe(":8080", nil))
+}
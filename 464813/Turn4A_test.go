@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAPI is a paginated users API with injectable latency and failures.
+type fakeAPI struct {
+	total, pageSize int
+	linkHeader      bool          // paginate with Link headers instead of next_cursor
+	latency         time.Duration // added to every request
+
+	mu       sync.Mutex
+	failures map[string]int // request path+query -> 500s still to return
+	gate     map[int]chan struct{}
+	requests map[string]int
+
+	inFlight, maxInFlight atomic.Int32
+}
+
+func newTestAPI(t *testing.T, api *fakeAPI) *httptest.Server {
+	t.Helper()
+	api.failures = make(map[string]int)
+	api.gate = make(map[int]chan struct{})
+	api.requests = make(map[string]int)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", api.serveUsers)
+	mux.HandleFunc("/users/{id}", api.serveUser)
+	srv := httptest.NewServer(api.track(mux))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (a *fakeAPI) failNext(requestURI string, times int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failures[requestURI] = times
+}
+
+// hold blocks the page starting at cursor until the returned func is called.
+func (a *fakeAPI) hold(cursor int) func() {
+	ch := make(chan struct{})
+	a.mu.Lock()
+	a.gate[cursor] = ch
+	a.mu.Unlock()
+	return func() { close(ch) }
+}
+
+func (a *fakeAPI) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := a.inFlight.Add(1)
+		defer a.inFlight.Add(-1)
+		for {
+			max := a.maxInFlight.Load()
+			if n <= max || a.maxInFlight.CompareAndSwap(max, n) {
+				break
+			}
+		}
+
+		a.mu.Lock()
+		a.requests[r.URL.RequestURI()]++
+		fail := a.failures[r.URL.RequestURI()] > 0
+		if fail {
+			a.failures[r.URL.RequestURI()]--
+		}
+		a.mu.Unlock()
+
+		time.Sleep(a.latency)
+		if fail {
+			http.Error(w, "injected failure", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *fakeAPI) serveUsers(w http.ResponseWriter, r *http.Request) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("cursor"))
+	a.mu.Lock()
+	gate := a.gate[start]
+	a.mu.Unlock()
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	var page usersPage
+	for id := start + 1; id <= min(start+a.pageSize, a.total); id++ {
+		page.Users = append(page.Users, User{ID: id, Name: "user" + strconv.Itoa(id)})
+	}
+	if next := start + a.pageSize; next < a.total {
+		if a.linkHeader {
+			w.Header().Set("Link", fmt.Sprintf(`</users?cursor=%d>; rel="next", </users>; rel="first"`, next))
+		} else {
+			page.NextCursor = strconv.Itoa(next)
+		}
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+func (a *fakeAPI) serveUser(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.PathValue("id"))
+	json.NewEncoder(w).Encode(User{ID: id, Name: "user" + strconv.Itoa(id), Role: "member"})
+}
+
+func newTestFetcher(srv *httptest.Server, limit int64) *Fetcher {
+	return &Fetcher{
+		Client:     srv.Client(),
+		BaseURL:    srv.URL,
+		Sem:        NewSemaphore(limit),
+		PageWeight: 1,
+		UserWeight: 1,
+		MaxRetries: 2,
+		RetryDelay: time.Millisecond,
+	}
+}
+
+func collect(t *testing.T, f *Fetcher) ([]UserResult, error) {
+	t.Helper()
+	out := make(chan UserResult)
+	errCh := make(chan error, 1)
+	go func() { errCh <- f.processUsersConcurrently(context.Background(), out) }()
+	var results []UserResult
+	for r := range out {
+		results = append(results, r)
+	}
+	return results, <-errCh
+}
+
+func checkAllUsers(t *testing.T, results []UserResult, total int) {
+	t.Helper()
+	seen := make(map[int]bool)
+	for _, r := range results {
+		if r.Error != "" {
+			t.Errorf("unexpected error result: %s", r.Error)
+			continue
+		}
+		if seen[r.User.ID] {
+			t.Errorf("user %d returned twice", r.User.ID)
+		}
+		if r.User.Role != "member" {
+			t.Errorf("user %d was not processed", r.User.ID)
+		}
+		seen[r.User.ID] = true
+	}
+	if len(seen) != total {
+		t.Fatalf("got %d users, want %d", len(seen), total)
+	}
+}
+
+func TestSemaphoreWeightedFIFO(t *testing.T) {
+	s := NewSemaphore(3)
+	ctx := context.Background()
+	if err := s.Acquire(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	big := make(chan struct{})
+	go func() {
+		s.Acquire(ctx, 3)
+		close(big)
+	}()
+	waitUntil(t, func() bool { s.mu.Lock(); defer s.mu.Unlock(); return s.waiters.Len() == 1 })
+
+	// One unit is free, but the big waiter is first in line.
+	if s.TryAcquire(1) {
+		t.Fatal("small acquire jumped ahead of a queued large one")
+	}
+	s.Release(2)
+	select {
+	case <-big:
+	case <-time.After(time.Second):
+		t.Fatal("large waiter not woken after release")
+	}
+	s.Release(3)
+	if !s.TryAcquire(3) {
+		t.Fatal("capacity not fully returned")
+	}
+}
+
+func TestSemaphoreCancelledWaiterTakesNothing(t *testing.T) {
+	s := NewSemaphore(2)
+	s.Acquire(context.Background(), 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Acquire(ctx, 2) }()
+	waitUntil(t, func() bool { s.mu.Lock(); defer s.mu.Unlock(); return s.waiters.Len() == 1 })
+
+	// A smaller waiter queued behind the cancelled one must get through.
+	small := make(chan struct{})
+	go func() {
+		s.Acquire(context.Background(), 1)
+		close(small)
+	}()
+	waitUntil(t, func() bool { s.mu.Lock(); defer s.mu.Unlock(); return s.waiters.Len() == 2 })
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Acquire = %v, want context.Canceled", err)
+	}
+	s.Release(1)
+	select {
+	case <-small:
+	case <-time.After(time.Second):
+		t.Fatal("waiter behind a cancelled one stayed blocked")
+	}
+	s.Release(1)
+	s.Release(1)
+	if !s.TryAcquire(2) {
+		t.Fatal("cancelled waiter leaked capacity")
+	}
+}
+
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFollowsCursorAndLinkPagination(t *testing.T) {
+	for _, link := range []bool{false, true} {
+		t.Run(fmt.Sprintf("link=%v", link), func(t *testing.T) {
+			api := &fakeAPI{total: 23, pageSize: 5, linkHeader: link}
+			srv := newTestAPI(t, api)
+			results, err := collect(t, newTestFetcher(srv, 4))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkAllUsers(t, results, 23)
+			for _, cursor := range []string{"/users", "/users?cursor=5", "/users?cursor=20"} {
+				if api.requests[cursor] != 1 {
+					t.Errorf("%s requested %d times", cursor, api.requests[cursor])
+				}
+			}
+		})
+	}
+}
+
+func TestFailedPagesAndUsersAreRetried(t *testing.T) {
+	api := &fakeAPI{total: 12, pageSize: 4}
+	srv := newTestAPI(t, api)
+	api.failNext("/users?cursor=4", 2)
+	api.failNext("/users/7", 1)
+
+	results, err := collect(t, newTestFetcher(srv, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAllUsers(t, results, 12)
+	if got := api.requests["/users?cursor=4"]; got != 3 {
+		t.Errorf("failing page requested %d times, want 3", got)
+	}
+}
+
+func TestGivesUpAfterMaxRetries(t *testing.T) {
+	api := &fakeAPI{total: 10, pageSize: 5}
+	srv := newTestAPI(t, api)
+	api.failNext("/users?cursor=5", 100)
+	api.failNext("/users/2", 100)
+
+	results, err := collect(t, newTestFetcher(srv, 3))
+	var se *statusError
+	if !errors.As(err, &se) || se.code != http.StatusInternalServerError {
+		t.Fatalf("err = %v, want the page's 500", err)
+	}
+	errorLines := 0
+	for _, r := range results {
+		if r.Error != "" {
+			errorLines++
+		}
+	}
+	if len(results) != 5 || errorLines != 1 {
+		t.Fatalf("got %d results with %d errors, want the first page's 5 with user 2 failed", len(results), errorLines)
+	}
+}
+
+func TestConcurrencyStaysWithinLimit(t *testing.T) {
+	api := &fakeAPI{total: 60, pageSize: 20, latency: 5 * time.Millisecond}
+	srv := newTestAPI(t, api)
+	results, err := collect(t, newTestFetcher(srv, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkAllUsers(t, results, 60)
+	if got := api.maxInFlight.Load(); got > 4 {
+		t.Fatalf("%d requests in flight at once, limit is 4", got)
+	}
+	if got := api.maxInFlight.Load(); got < 2 {
+		t.Fatalf("max %d requests in flight; users were not fetched concurrently", got)
+	}
+}
+
+func TestHandleUsersRequestStreamsNDJSON(t *testing.T) {
+	api := &fakeAPI{total: 6, pageSize: 3}
+	srv := newTestAPI(t, api)
+	release := api.hold(3) // the second page waits until we have read the first
+
+	handler := httptest.NewServer(handleUsersRequest(newTestFetcher(srv, 2)))
+	defer handler.Close()
+	resp, err := http.Get(handler.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	ids := make(map[int]bool)
+	read := func() {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("stream ended early: %v", lines.Err())
+		}
+		var r UserResult
+		if err := json.Unmarshal(lines.Bytes(), &r); err != nil || r.User == nil {
+			t.Fatalf("bad line %q: %v", lines.Text(), err)
+		}
+		ids[r.User.ID] = true
+	}
+	// All of page one arrives while page two is still blocked.
+	for i := 0; i < 3; i++ {
+		read()
+	}
+	release()
+	for i := 0; i < 3; i++ {
+		read()
+	}
+	if lines.Scan() {
+		t.Fatalf("unexpected extra line %q", lines.Text())
+	}
+	if len(ids) != 6 {
+		t.Fatalf("got users %v", ids)
+	}
+}
+
+func TestHandleUsersRequestReportsFailureInStream(t *testing.T) {
+	api := &fakeAPI{total: 4, pageSize: 2}
+	srv := newTestAPI(t, api)
+	api.failNext("/users?cursor=2", 100)
+
+	rec := httptest.NewRecorder()
+	handleUsersRequest(newTestFetcher(srv, 2))(rec, httptest.NewRequest(http.MethodGet, "/process-users", nil))
+
+	sc := bufio.NewScanner(rec.Body)
+	var last UserResult
+	n := 0
+	for sc.Scan() {
+		n++
+		last = UserResult{}
+		json.Unmarshal(sc.Bytes(), &last)
+	}
+	if n != 3 || last.Error == "" {
+		t.Fatalf("got %d lines ending with %+v, want 2 users and an error line", n, last)
+	}
+}
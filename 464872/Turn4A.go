@@ -0,0 +1,351 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Define a type for actions. path is relative to the watched root and uses
+// forward slashes; op holds every operation seen during the debounce window.
+type action func(path string, op fsnotify.Op) error
+
+// rule routes events whose path matches pattern and whose operation
+// overlaps ops to an action.
+type rule struct {
+	pattern string // glob; "**" matches any number of directories
+	ops     fsnotify.Op
+	action  action
+}
+
+// ErrQueueFull is reported when an event is dropped because every worker
+// is busy and the job queue is full.
+var ErrQueueFull = errors.New("handler queue full, event dropped")
+
+// matchGlob reports whether name matches pattern, both slash separated.
+// Segments are matched with path.Match; a "**" segment matches zero or
+// more whole segments.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+type pendingEvent struct {
+	op    fsnotify.Op
+	first time.Time
+	timer *time.Timer
+}
+
+type job struct {
+	path   string
+	op     fsnotify.Op
+	action action
+}
+
+// directoryWatcher watches a directory tree, including subdirectories
+// created after it starts. Events for one path are coalesced until the path
+// has been quiet for debounce (or maxWait has passed since the first one),
+// then routed to every matching rule and run on a fixed pool of workers.
+type directoryWatcher struct {
+	root     string
+	rules    []rule
+	debounce time.Duration
+	maxWait  time.Duration
+
+	fsw     *fsnotify.Watcher
+	jobs    chan job
+	errs    chan error
+	dropped atomic.Uint64 // errors that did not fit in errs
+
+	mu      sync.Mutex
+	pending map[string]*pendingEvent
+	closed  bool
+
+	loopDone chan struct{}
+	workers  sync.WaitGroup
+}
+
+// newDirectoryWatcher starts watching root. queueSize bounds the jobs
+// waiting for a worker; events beyond it are dropped and reported.
+func newDirectoryWatcher(root string, rules []rule, debounce time.Duration, workers, queueSize int) (*directoryWatcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &directoryWatcher{
+		root:     filepath.Clean(root),
+		rules:    rules,
+		debounce: debounce,
+		maxWait:  10 * debounce,
+		fsw:      fsw,
+		jobs:     make(chan job, queueSize),
+		errs:     make(chan error, 64),
+		pending:  make(map[string]*pendingEvent),
+		loopDone: make(chan struct{}),
+	}
+	if err := w.addTree(w.root, false); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+	for i := 0; i < max(1, workers); i++ {
+		w.workers.Add(1)
+		go w.work()
+	}
+	go w.loop()
+	return w, nil
+}
+
+// Errors reports watch failures, fsnotify overflows, dropped events and
+// handler errors. Errors that arrive while nobody is reading are counted
+// in Dropped instead.
+func (w *directoryWatcher) Errors() <-chan error { return w.errs }
+
+func (w *directoryWatcher) Dropped() uint64 { return w.dropped.Load() }
+
+func (w *directoryWatcher) report(err error) {
+	select {
+	case w.errs <- err:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// addTree watches dir and every directory below it. When synthesize is
+// set, files found inside are reported as created: they may have appeared
+// before the new directory's watch was in place.
+func (w *directoryWatcher) addTree(dir string, synthesize bool) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // removed while we were walking
+			}
+			return err
+		}
+		if d.IsDir() {
+			if err := w.fsw.Add(p); err != nil {
+				return fmt.Errorf("watching %s: %w", p, err)
+			}
+			if p == dir {
+				return nil
+			}
+		}
+		if synthesize {
+			w.queue(p, fsnotify.Create)
+		}
+		return nil
+	})
+}
+
+func (w *directoryWatcher) loop() {
+	defer close(w.loopDone)
+	for {
+		select {
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				err = fmt.Errorf("kernel event queue overflowed, some changes under %s were missed: %w", w.root, err)
+			}
+			w.report(err)
+		}
+	}
+}
+
+func (w *directoryWatcher) handle(event fsnotify.Event) {
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+			if err := w.addTree(event.Name, true); err != nil {
+				w.report(err)
+			}
+		}
+	}
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		// The kernel drops watches on deleted directories itself; this only
+		// matters for renames, and fails harmlessly for plain files.
+		w.fsw.Remove(event.Name)
+	}
+	w.queue(event.Name, event.Op)
+}
+
+// queue adds op to the pending event for name and (re)starts its timer.
+func (w *directoryWatcher) queue(name string, op fsnotify.Op) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	p, ok := w.pending[name]
+	if !ok {
+		p = &pendingEvent{first: time.Now()}
+		p.timer = time.AfterFunc(w.debounce, func() { w.flush(name, p) })
+		w.pending[name] = p
+	} else {
+		wait := w.debounce
+		if remaining := w.maxWait - time.Since(p.first); remaining < wait {
+			wait = max(0, remaining)
+		}
+		p.timer.Reset(wait)
+	}
+	p.op |= op
+}
+
+// flush routes a path's coalesced operations once it has gone quiet. It
+// holds the lock throughout so Close cannot close jobs under it; the sends
+// never block.
+func (w *directoryWatcher) flush(name string, p *pendingEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.pending[name] != p {
+		return // closed, or a timer re-armed after this entry was flushed
+	}
+	delete(w.pending, name)
+
+	rel, err := filepath.Rel(w.root, name)
+	if err != nil {
+		w.report(err)
+		return
+	}
+	rel = filepath.ToSlash(rel)
+	for _, r := range w.rules {
+		if p.op&r.ops == 0 || !matchGlob(r.pattern, rel) {
+			continue
+		}
+		select {
+		case w.jobs <- job{path: rel, op: p.op, action: r.action}:
+		default:
+			w.report(fmt.Errorf("%s (%s) for rule %q: %w", rel, p.op, r.pattern, ErrQueueFull))
+		}
+	}
+}
+
+func (w *directoryWatcher) work() {
+	defer w.workers.Done()
+	for j := range w.jobs {
+		if err := runAction(j); err != nil {
+			w.report(fmt.Errorf("handling %s (%s): %w", j.path, j.op, err))
+		}
+	}
+}
+
+func runAction(j job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return j.action(j.path, j.op)
+}
+
+// Close stops watching. Events still inside their debounce window are
+// discarded; handlers already queued run to completion. Errors is closed
+// once they have.
+func (w *directoryWatcher) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	for _, p := range w.pending {
+		p.timer.Stop()
+	}
+	w.pending = nil
+	w.mu.Unlock()
+
+	err := w.fsw.Close()
+	<-w.loopDone
+	close(w.jobs)
+	w.workers.Wait()
+	close(w.errs)
+	return err
+}
+
+// Example action function for configuration files anywhere in the tree
+func handleConfigChange(path string, op fsnotify.Op) error {
+	fmt.Printf("Reloading configuration %s (%s)\n", path, op)
+	return nil
+}
+
+// Example action function for anything under dir1
+func handleDir1Event(path string, op fsnotify.Op) error {
+	fmt.Printf("Event detected in dir1: %s (%s)\n", path, op)
+	return nil
+}
+
+func handleRemoval(path string, op fsnotify.Op) error {
+	fmt.Printf("Removed: %s\n", path)
+	return nil
+}
+
+func main() {
+	root := "dir"
+	if len(os.Args) > 1 {
+		root = os.Args[1]
+	}
+
+	rules := []rule{
+		{pattern: "**/*.yaml", ops: fsnotify.Create | fsnotify.Write, action: handleConfigChange},
+		{pattern: "dir1/**", ops: fsnotify.Create | fsnotify.Write | fsnotify.Remove | fsnotify.Rename, action: handleDir1Event},
+		{pattern: "**", ops: fsnotify.Remove | fsnotify.Rename, action: handleRemoval},
+	}
+
+	watcher, err := newDirectoryWatcher(root, rules, 100*time.Millisecond, 3, 100)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Watching %s recursively\n", root)
+
+	go func() {
+		for err := range watcher.Errors() {
+			log.Println("error:", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	<-signals
+
+	if err := watcher.Close(); err != nil {
+		log.Println("error closing watcher:", err)
+	}
+	if n := watcher.Dropped(); n > 0 {
+		log.Printf("%d errors were dropped because nobody was reading them\n", n)
+	}
+}
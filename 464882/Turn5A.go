@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LargeDataset represents a large dataset
+type LargeDataset []int
+
+// WorkerPool runs tasks on a fixed number of worker goroutines. With
+// balance set, each task goes to whichever worker is idle; without it,
+// tasks are dealt round-robin to per-worker queues, so one slow task holds
+// up everything queued behind it even while other workers sit idle.
+type WorkerPool struct {
+	tasks   chan func()      // Channel to hold tasks
+	workers chan chan func() // Idle workers, in balanced mode
+	queues  []chan func()    // Per-worker queues, in unbalanced mode
+	wg      sync.WaitGroup
+	size    int
+	balance bool // Enable dynamic workload balancing
+}
+
+// NewWorkerPool creates a new worker pool with a specified number of workers
+func NewWorkerPool(size int, balance bool) *WorkerPool {
+	size = max(1, size)
+	return &WorkerPool{
+		tasks:   make(chan func(), size),
+		workers: make(chan chan func(), size),
+		size:    size,
+		balance: balance,
+	}
+}
+
+// Start starts the workers and the dispatcher
+func (wp *WorkerPool) Start() {
+	for i := 0; i < wp.size; i++ {
+		wp.wg.Add(1)
+		if wp.balance {
+			go wp.worker()
+		} else {
+			q := make(chan func(), 16)
+			wp.queues = append(wp.queues, q)
+			go wp.drain(q)
+		}
+	}
+	go wp.dispatch()
+}
+
+// worker registers itself as idle, runs the task it is handed and
+// registers again. It exits when the dispatcher closes its channel.
+func (wp *WorkerPool) worker() {
+	defer wp.wg.Done()
+	workerCh := make(chan func())
+	for {
+		wp.workers <- workerCh
+		task, ok := <-workerCh
+		if !ok {
+			return
+		}
+		task()
+	}
+}
+
+// drain runs the tasks of one worker's queue in order.
+func (wp *WorkerPool) drain(q chan func()) {
+	defer wp.wg.Done()
+	for task := range q {
+		task()
+	}
+}
+
+// dispatch hands submitted tasks to workers until Stop closes tasks, then
+// shuts the workers down.
+func (wp *WorkerPool) dispatch() {
+	if !wp.balance {
+		next := 0
+		for task := range wp.tasks {
+			wp.queues[next] <- task
+			next = (next + 1) % wp.size
+		}
+		for _, q := range wp.queues {
+			close(q)
+		}
+		return
+	}
+	for task := range wp.tasks {
+		workerCh := <-wp.workers // Wait for an available worker channel
+		workerCh <- task
+	}
+	for i := 0; i < wp.size; i++ {
+		close(<-wp.workers)
+	}
+}
+
+// Submit queues a task, blocking while the pool is saturated or until ctx
+// is done.
+func (wp *WorkerPool) Submit(ctx context.Context, task func()) error {
+	select {
+	case wp.tasks <- task:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop waits for every submitted task to finish and stops the workers.
+func (wp *WorkerPool) Stop() {
+	close(wp.tasks)
+	wp.wg.Wait()
+}
+
+// Source yields the input one chunk at a time and reports why it stopped
+// early, if it did. Each chunk must be a fresh slice: it is handed to a
+// worker while the source goes on reading.
+type Source[T any] func(yield func([]T) bool) error
+
+// FromSeq splits seq into chunks of up to size items.
+func FromSeq[T any](seq iter.Seq[T], size int) Source[T] {
+	return func(yield func([]T) bool) error {
+		for chunk := range chunks(seq, size) {
+			if !yield(chunk) {
+				break
+			}
+		}
+		return nil
+	}
+}
+
+func chunks[T any](seq iter.Seq[T], size int) iter.Seq[[]T] {
+	return func(yield func([]T) bool) {
+		chunk := make([]T, 0, size)
+		for v := range seq {
+			chunk = append(chunk, v)
+			if len(chunk) == size {
+				if !yield(chunk) {
+					return
+				}
+				chunk = make([]T, 0, size)
+			}
+		}
+		if len(chunk) > 0 {
+			yield(chunk)
+		}
+	}
+}
+
+// FromReader reads r line by line, parses each line and yields chunks of
+// up to size items. Only the lines of chunks still in flight are held in
+// memory, however large r is.
+func FromReader[T any](r io.Reader, size int, parse func(line string) (T, error)) Source[T] {
+	return func(yield func([]T) bool) error {
+		sc := bufio.NewScanner(r)
+		chunk := make([]T, 0, size)
+		for line := 1; sc.Scan(); line++ {
+			v, err := parse(sc.Text())
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			chunk = append(chunk, v)
+			if len(chunk) == size {
+				if !yield(chunk) {
+					return nil
+				}
+				chunk = make([]T, 0, size)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return err
+		}
+		if len(chunk) > 0 {
+			yield(chunk)
+		}
+		return nil
+	}
+}
+
+// Options control how MapChunks schedules work.
+type Options struct {
+	// MaxInFlight bounds the chunks that have been read but whose results
+	// have not yet been emitted, including results held back for ordering.
+	MaxInFlight int
+	// Ordered emits results in input order rather than as they complete.
+	Ordered bool
+}
+
+// Result is the output of one chunk; Index is the chunk's position in the
+// input, counting from 0.
+type Result[R any] struct {
+	Index int
+	Value R
+	err   error
+}
+
+// MapChunks applies fn to every chunk of src on wp's workers and calls emit
+// with each result, always from the calling goroutine. The source is read
+// only while fewer than opts.MaxInFlight chunks are outstanding, so a slow
+// emit slows reading down instead of letting results pile up. The first
+// error from the source, fn or emit cancels the context passed to the
+// remaining fn calls and is returned once they have finished.
+func MapChunks[T, R any](ctx context.Context, wp *WorkerPool, src Source[T], opts Options, fn func(context.Context, []T) (R, error), emit func(Result[R]) error) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	inFlight := max(1, opts.MaxInFlight)
+	tokens := make(chan struct{}, inFlight)
+	// Every outstanding chunk holds a token, so results never exceeds its
+	// capacity and workers never block sending to it.
+	results := make(chan Result[R], inFlight)
+
+	go func() {
+		var tasks sync.WaitGroup
+		defer close(results)
+		defer tasks.Wait()
+
+		index := 0
+		err := src(func(chunk []T) bool {
+			select {
+			case tokens <- struct{}{}:
+			case <-ctx.Done():
+				return false
+			}
+			i := index
+			index++
+			tasks.Add(1)
+			err := wp.Submit(ctx, func() {
+				defer tasks.Done()
+				if err := ctx.Err(); err != nil {
+					results <- Result[R]{Index: i, err: err}
+					return
+				}
+				v, err := fn(ctx, chunk)
+				if err != nil {
+					err = fmt.Errorf("chunk %d: %w", i, err)
+				}
+				results <- Result[R]{Index: i, Value: v, err: err}
+			})
+			if err != nil {
+				tasks.Done()
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			cancel(fmt.Errorf("reading input: %w", err))
+		}
+	}()
+
+	held := make(map[int]Result[R]) // completed out of order, Ordered only
+	next := 0
+	deliver := func(r Result[R]) {
+		defer func() { <-tokens }()
+		if ctx.Err() != nil {
+			return
+		}
+		if err := emit(r); err != nil {
+			cancel(err)
+		}
+	}
+	for r := range results {
+		if r.err != nil {
+			cancel(r.err) // no-op unless this is the first error
+			<-tokens
+			continue
+		}
+		if !opts.Ordered {
+			deliver(r)
+			continue
+		}
+		held[r.Index] = r
+		for {
+			r, ok := held[next]
+			if !ok {
+				break
+			}
+			delete(held, next)
+			next++
+			deliver(r)
+		}
+	}
+	return context.Cause(ctx)
+}
+
+// MapReduce folds the results of fn over every chunk of src into a single
+// value. Chunks are combined as they complete, so combine must not depend
+// on their order.
+func MapReduce[T, R, A any](ctx context.Context, wp *WorkerPool, src Source[T], opts Options, fn func(context.Context, []T) (R, error), combine func(A, R) A, acc A) (A, error) {
+	err := MapChunks(ctx, wp, src, opts, fn, func(r Result[R]) error {
+		acc = combine(acc, r.Value)
+		return nil
+	})
+	return acc, err
+}
+
+// sumSquares is the example processing operation.
+func sumSquares(ctx context.Context, chunk []int) (int, error) {
+	sum := 0
+	for _, value := range chunk {
+		sum += value * value
+	}
+	return sum, nil
+}
+
+// ProcessLargeDataset returns the sum of the squares of dataset, computed
+// in chunks on wp.
+func ProcessLargeDataset(ctx context.Context, dataset LargeDataset, wp *WorkerPool) (int, error) {
+	chunkSize := max(1, len(dataset)/(4*wp.size))
+	return MapReduce(ctx, wp, FromSeq(slices.Values(dataset), chunkSize), Options{MaxInFlight: 2 * wp.size},
+		sumSquares, func(total, sum int) int { return total + sum }, 0)
+}
+
+func main() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wp := NewWorkerPool(runtime.NumCPU(), true)
+	wp.Start()
+	defer wp.Stop()
+
+	// Create a large dataset
+	largeDataset := make(LargeDataset, 1000000)
+	for i := range largeDataset {
+		largeDataset[i] = i % 1000
+	}
+	total, err := ProcessLargeDataset(ctx, largeDataset, wp)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Sum of squares:", total)
+
+	// Stream numbers from a reader and print per-chunk sums in input order.
+	input := strings.NewReader("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n")
+	err = MapChunks(ctx, wp, FromReader(input, 3, strconv.Atoi), Options{MaxInFlight: 4, Ordered: true},
+		sumSquares, func(r Result[int]) error {
+			fmt.Printf("chunk %d: %d\n", r.Index, r.Value)
+			return nil
+		})
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	// A malformed line stops the run and is reported with its position.
+	err = MapChunks(ctx, wp, FromReader(strings.NewReader("1\n2\nthree\n"), 2, strconv.Atoi), Options{MaxInFlight: 4},
+		sumSquares, func(Result[int]) error { return nil })
+	var numErr *strconv.NumError
+	fmt.Println("Error:", err, errors.As(err, &numErr))
+}
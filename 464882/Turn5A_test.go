@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startPool(tb testing.TB, size int, balance bool) *WorkerPool {
+	tb.Helper()
+	wp := NewWorkerPool(size, balance)
+	wp.Start()
+	tb.Cleanup(wp.Stop)
+	return wp
+}
+
+func ints(n int) LargeDataset {
+	data := make(LargeDataset, n)
+	for i := range data {
+		data[i] = i
+	}
+	return data
+}
+
+func TestProcessLargeDataset(t *testing.T) {
+	for _, balance := range []bool{true, false} {
+		got, err := ProcessLargeDataset(context.Background(), ints(10_001), startPool(t, 4, balance))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		for i := range 10_001 {
+			want += i * i
+		}
+		if got != want {
+			t.Fatalf("balance=%v: got %d, want %d", balance, got, want)
+		}
+	}
+}
+
+// reverseDelay makes early chunks the slowest, so they complete last.
+func reverseDelay(ctx context.Context, chunk []int) (int, error) {
+	time.Sleep(time.Duration(100-chunk[0]) * 50 * time.Microsecond)
+	return chunk[0], nil
+}
+
+func TestOrderedResultsFollowInput(t *testing.T) {
+	wp := startPool(t, 8, true)
+	var got []int
+	err := MapChunks(context.Background(), wp, FromSeq(slices.Values(ints(100)), 5), Options{MaxInFlight: 8, Ordered: true},
+		reverseDelay, func(r Result[int]) error {
+			if r.Index != len(got) {
+				t.Errorf("result %d emitted at position %d", r.Index, len(got))
+			}
+			got = append(got, r.Value)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 20 || !slices.IsSorted(got) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestUnorderedResultsArriveAsCompleted(t *testing.T) {
+	wp := startPool(t, 8, true)
+	var got []int
+	err := MapChunks(context.Background(), wp, FromSeq(slices.Values(ints(100)), 5), Options{MaxInFlight: 8},
+		reverseDelay, func(r Result[int]) error {
+			got = append(got, r.Index)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 20 || slices.IsSorted(got) {
+		t.Fatalf("got %v, want all 20 chunks out of order", got)
+	}
+}
+
+func TestInFlightIsBounded(t *testing.T) {
+	wp := startPool(t, 4, true)
+	var read, emitted, maxAhead atomic.Int64
+	src := func(yield func([]int) bool) error {
+		for i := range 50 {
+			if ahead := read.Add(1) - emitted.Load(); ahead > maxAhead.Load() {
+				maxAhead.Store(ahead)
+			}
+			if !yield([]int{i}) {
+				return nil
+			}
+		}
+		return nil
+	}
+	err := MapChunks(context.Background(), wp, src, Options{MaxInFlight: 3, Ordered: true},
+		reverseDelay, func(Result[int]) error {
+			time.Sleep(time.Millisecond) // a slow consumer
+			emitted.Add(1)
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The source may have built one more chunk that is waiting for a slot.
+	if got := maxAhead.Load(); got > 3+1 {
+		t.Fatalf("%d chunks read ahead of the consumer, limit is 3", got)
+	}
+}
+
+func TestFirstErrorCancelsTheRest(t *testing.T) {
+	wp := startPool(t, 2, true)
+	boom := errors.New("boom")
+	var calls atomic.Int32
+	err := MapChunks(context.Background(), wp, FromSeq(slices.Values(ints(1000)), 1), Options{MaxInFlight: 4},
+		func(ctx context.Context, chunk []int) (int, error) {
+			calls.Add(1)
+			if chunk[0] == 3 {
+				return 0, boom
+			}
+			return 0, nil
+		}, func(Result[int]) error { return nil })
+	if !errors.Is(err, boom) || !strings.Contains(err.Error(), "chunk 3") {
+		t.Fatalf("err = %v, want chunk 3's error", err)
+	}
+	if n := calls.Load(); n > 10 {
+		t.Fatalf("%d chunks processed after the failure", n)
+	}
+}
+
+func TestReaderErrorsAreReported(t *testing.T) {
+	wp := startPool(t, 2, false)
+	src := FromReader(strings.NewReader("1\n2\nx\n4\n"), 2, strconv.Atoi)
+	_, err := MapReduce(context.Background(), wp, src, Options{MaxInFlight: 2}, sumSquares,
+		func(a, b int) int { return a + b }, 0)
+	var numErr *strconv.NumError
+	if !errors.As(err, &numErr) || !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("err = %v, want a parse error on line 3", err)
+	}
+}
+
+func TestEmitErrorStopsTheRun(t *testing.T) {
+	wp := startPool(t, 2, true)
+	stop := errors.New("enough")
+	emitted := 0
+	err := MapChunks(context.Background(), wp, FromSeq(slices.Values(ints(1000)), 10), Options{MaxInFlight: 2, Ordered: true},
+		sumSquares, func(Result[int]) error {
+			emitted++
+			if emitted == 2 {
+				return stop
+			}
+			return nil
+		})
+	if !errors.Is(err, stop) || emitted != 2 {
+		t.Fatalf("err = %v after %d results", err, emitted)
+	}
+}
+
+// skewed simulates I/O-bound chunks where every eighth one is 20 times
+// slower; dealt round-robin to 8 workers, they all land on the same one.
+// Sleeping rather than spinning keeps the comparison meaningful on
+// machines with few cores.
+func skewed(ctx context.Context, chunk []int) (int, error) {
+	d := time.Millisecond
+	if chunk[0]%8 == 0 {
+		d *= 20
+	}
+	time.Sleep(d)
+	return len(chunk), nil
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	data := ints(64)
+	for _, balance := range []bool{true, false} {
+		for _, ordered := range []bool{false, true} {
+			b.Run(fmt.Sprintf("balance=%v/ordered=%v", balance, ordered), func(b *testing.B) {
+				wp := startPool(b, 8, balance)
+				opts := Options{MaxInFlight: 32, Ordered: ordered}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					err := MapChunks(context.Background(), wp, FromSeq(slices.Values(data), 1), opts,
+						skewed, func(Result[int]) error { return nil })
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,801 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type CacheItem struct {
+	Key    string
+	Data   interface{}
+	Expiry time.Time
+}
+
+// Cache is implemented by the single-file FileCache and by shardedCache,
+// which spreads keys over several of them.
+type Cache interface {
+	Get(key string) (interface{}, bool)
+	Set(key string, data interface{}, ttl time.Duration) error
+	Delete(key string) error
+	Stats() Stats
+	Close() error
+}
+
+var ErrClosed = errors.New("cache is closed")
+
+// Stats counts cache activity since the cache was opened.
+type Stats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64 // removed to make room
+	Expirations uint64 // removed because their TTL ran out
+	Compactions uint64
+	// CompactionFailures counts compactions that failed after the write
+	// that triggered them had already been committed.
+	CompactionFailures uint64
+	Entries            int
+}
+
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d entries, %d hits, %d misses (%.0f%% hit rate), %d evicted, %d expired, %d compactions (%d failed)",
+		s.Entries, s.Hits, s.Misses, 100*s.HitRate(), s.Evictions, s.Expirations, s.Compactions, s.CompactionFailures)
+}
+
+func (s *Stats) add(o Stats) {
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.Evictions += o.Evictions
+	s.Expirations += o.Expirations
+	s.Compactions += o.Compactions
+	s.CompactionFailures += o.CompactionFailures
+	s.Entries += o.Entries
+}
+
+// EvictionPolicy selects which entry makes room when the cache is full.
+type EvictionPolicy int
+
+const (
+	LRU EvictionPolicy = iota // least recently used
+	LFU                       // least frequently used, oldest first on ties
+)
+
+// evictor tracks usage for an EvictionPolicy.
+type evictor interface {
+	add(key string)
+	touch(key string)
+	remove(key string)
+	victim() (string, bool)
+}
+
+func newEvictor(p EvictionPolicy) evictor {
+	if p == LFU {
+		return &lfu{entries: make(map[string]*lfuEntry)}
+	}
+	return &lru{elems: make(map[string]*list.Element)}
+}
+
+type lru struct {
+	order list.List // most recently used at the front
+	elems map[string]*list.Element
+}
+
+func (l *lru) add(key string) { l.elems[key] = l.order.PushFront(key) }
+
+func (l *lru) touch(key string) { l.order.MoveToFront(l.elems[key]) }
+
+func (l *lru) remove(key string) {
+	l.order.Remove(l.elems[key])
+	delete(l.elems, key)
+}
+
+func (l *lru) victim() (string, bool) {
+	if back := l.order.Back(); back != nil {
+		return back.Value.(string), true
+	}
+	return "", false
+}
+
+type lfuEntry struct {
+	key   string
+	count uint64
+	used  uint64 // tick of last use, to break ties
+	index int
+}
+
+// lfu is a min-heap ordered by use count, then by last use.
+type lfu struct {
+	heap    []*lfuEntry
+	entries map[string]*lfuEntry
+	clock   uint64
+}
+
+func (l *lfu) Len() int { return len(l.heap) }
+func (l *lfu) Less(i, j int) bool {
+	a, b := l.heap[i], l.heap[j]
+	return a.count < b.count || a.count == b.count && a.used < b.used
+}
+func (l *lfu) Swap(i, j int) {
+	l.heap[i], l.heap[j] = l.heap[j], l.heap[i]
+	l.heap[i].index, l.heap[j].index = i, j
+}
+func (l *lfu) Push(x any) {
+	e := x.(*lfuEntry)
+	e.index = len(l.heap)
+	l.heap = append(l.heap, e)
+}
+func (l *lfu) Pop() any {
+	e := l.heap[len(l.heap)-1]
+	l.heap = l.heap[:len(l.heap)-1]
+	return e
+}
+
+func (l *lfu) add(key string) {
+	l.clock++
+	e := &lfuEntry{key: key, count: 1, used: l.clock}
+	l.entries[key] = e
+	heap.Push(l, e)
+}
+
+func (l *lfu) touch(key string) {
+	l.clock++
+	e := l.entries[key]
+	e.count++
+	e.used = l.clock
+	heap.Fix(l, e.index)
+}
+
+func (l *lfu) remove(key string) {
+	heap.Remove(l, l.entries[key].index)
+	delete(l.entries, key)
+}
+
+func (l *lfu) victim() (string, bool) {
+	if len(l.heap) == 0 {
+		return "", false
+	}
+	return l.heap[0].key, true
+}
+
+// timingWheel schedules expiries in slots of one tick each. A key due
+// further out than one turn of the wheel waits out the extra turns in its
+// slot, so scheduling and cancelling are O(1) however long the TTL.
+type timingWheel struct {
+	tick  time.Duration
+	slots []map[string]int // key -> full turns still to wait
+	where map[string]int   // key -> slot
+	pos   int
+}
+
+func newTimingWheel(tick time.Duration, size int) *timingWheel {
+	tw := &timingWheel{tick: tick, slots: make([]map[string]int, size), where: make(map[string]int)}
+	for i := range tw.slots {
+		tw.slots[i] = make(map[string]int)
+	}
+	return tw
+}
+
+// schedule (re)schedules key to expire after d, rounded up to a whole tick.
+// The current tick is already partly over, so the key can still come due
+// up to a tick early; callers check the real expiry.
+func (tw *timingWheel) schedule(key string, d time.Duration) {
+	tw.cancel(key)
+	ticks := max(1, int((d+tw.tick-1)/tw.tick))
+	slot := (tw.pos + ticks) % len(tw.slots)
+	tw.slots[slot][key] = (ticks - 1) / len(tw.slots)
+	tw.where[key] = slot
+}
+
+func (tw *timingWheel) cancel(key string) {
+	if slot, ok := tw.where[key]; ok {
+		delete(tw.slots[slot], key)
+		delete(tw.where, key)
+	}
+}
+
+// advance moves the wheel on one tick and returns the keys now due.
+func (tw *timingWheel) advance() []string {
+	tw.pos = (tw.pos + 1) % len(tw.slots)
+	var due []string
+	for key, turns := range tw.slots[tw.pos] {
+		if turns > 0 {
+			tw.slots[tw.pos][key] = turns - 1
+			continue
+		}
+		due = append(due, key)
+		delete(tw.slots[tw.pos], key)
+		delete(tw.where, key)
+	}
+	return due
+}
+
+// The log is a sequence of records, each written with a single Write:
+//
+//	crc32c(4) | length(4) | op(1) | payload(length)
+//
+// The checksum covers everything after itself. A record that is short or
+// fails its checksum can only be the tail of a write cut off by a crash; it
+// and anything after it are discarded when the log is opened.
+const (
+	opSet    byte = 1 // payload is a JSON CacheItem
+	opDelete byte = 2 // payload is the key
+
+	recordHeaderSize = 9
+	maxRecordSize    = 64 << 20
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func encodeRecord(op byte, payload []byte) []byte {
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(payload)))
+	buf[8] = op
+	copy(buf[recordHeaderSize:], payload)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.Checksum(buf[4:], castagnoli))
+	return buf
+}
+
+// errTornRecord marks the end of the usable part of a log.
+var errTornRecord = errors.New("torn or corrupt record")
+
+// readRecords calls fn for each intact record and returns the offset just
+// past the last one.
+func readRecords(r io.Reader, fn func(op byte, payload []byte) error) (int64, error) {
+	br := bufio.NewReader(r)
+	var good int64
+	header := make([]byte, recordHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return good, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return good, errTornRecord
+			}
+			return good, err
+		}
+		n := binary.LittleEndian.Uint32(header[4:8])
+		if n > maxRecordSize {
+			return good, errTornRecord
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return good, errTornRecord
+			}
+			return good, err
+		}
+		crc := crc32.Update(crc32.Checksum(header[4:], castagnoli), castagnoli, payload)
+		if crc != binary.LittleEndian.Uint32(header[0:4]) {
+			return good, errTornRecord
+		}
+		if err := fn(header[8], payload); err != nil {
+			return good, err
+		}
+		good += recordHeaderSize + int64(n)
+	}
+}
+
+// FileCache is a size-bounded cache persisted to an append-only log. Every
+// change is appended and fsynced before it takes effect in memory, and the
+// log is compacted by writing the live entries to a new file and renaming
+// it into place, so a crash at any point leaves a log that replays to the
+// last acknowledged state.
+//
+// Values round-trip through JSON, so after a restart they come back as
+// JSON-decoded types. LFU use counts are not persisted; replayed entries
+// start at one use each, in log order.
+type FileCache struct {
+	filename string
+	maxSize  int
+
+	mu      sync.Mutex
+	file    *os.File
+	cache   map[string]CacheItem
+	evict   evictor
+	wheel   *timingWheel
+	records int // records in the log, live or superseded
+	stats   Stats
+	closed  bool
+	broken  error // set when a failed append could not be rolled back
+	// dirDirty is set when a compaction renamed the log but could not sync
+	// the directory; the next append retries before writing anything.
+	dirDirty bool
+
+	expiryTicker *time.Ticker
+	done         chan struct{}
+	stopped      chan struct{}
+}
+
+var _ Cache = (*FileCache)(nil)
+
+// NewFileCache opens or creates the log at filename. Expiry is checked
+// every second; Get never returns an expired entry in between.
+func NewFileCache(filename string, maxSize int, policy EvictionPolicy) (*FileCache, error) {
+	cache := &FileCache{
+		filename: filename,
+		maxSize:  max(1, maxSize),
+		cache:    make(map[string]CacheItem),
+		evict:    newEvictor(policy),
+		wheel:    newTimingWheel(time.Second, 512),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if err := cache.loadCacheFromFile(); err != nil {
+		return nil, err
+	}
+	cache.expiryTicker = time.NewTicker(cache.wheel.tick)
+	go cache.handleExpiry()
+	return cache, nil
+}
+
+// loadCacheFromFile replays the log, cuts off a torn tail and opens the
+// log for appending.
+func (cache *FileCache) loadCacheFromFile() error {
+	file, err := os.OpenFile(cache.filename, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	good, err := readRecords(file, func(op byte, payload []byte) error {
+		cache.records++
+		switch op {
+		case opSet:
+			var item CacheItem
+			if err := json.Unmarshal(payload, &item); err != nil {
+				return fmt.Errorf("decoding record %d: %w", cache.records, err)
+			}
+			if _, ok := cache.cache[item.Key]; ok {
+				cache.evict.remove(item.Key)
+			}
+			cache.cache[item.Key] = item
+			cache.evict.add(item.Key)
+		case opDelete:
+			if _, ok := cache.cache[string(payload)]; ok {
+				delete(cache.cache, string(payload))
+				cache.evict.remove(string(payload))
+			}
+		default:
+			return fmt.Errorf("record %d: unknown op %d", cache.records, op)
+		}
+		return nil
+	})
+	if errors.Is(err, errTornRecord) {
+		fmt.Printf("Discarding torn write at offset %d of %s\n", good, cache.filename)
+		if err = file.Truncate(good); err == nil {
+			err = file.Sync()
+		}
+	}
+	if err == nil {
+		_, err = file.Seek(good, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("loading %s: %w", cache.filename, err)
+	}
+	cache.file = file
+
+	now := time.Now()
+	for key, item := range cache.cache {
+		if !now.Before(item.Expiry) {
+			cache.removeItem(key)
+			continue
+		}
+		cache.wheel.schedule(key, item.Expiry.Sub(now))
+	}
+	// maxSize may have been lowered since the log was written.
+	if err := cache.evictOverflow(cache.maxSize); err != nil {
+		return err
+	}
+	cache.maybeCompact()
+	return nil
+}
+
+// appendRecord writes one record and syncs it. Callers hold mu and change
+// memory only once it has succeeded. A failed write or sync is cut back off
+// the log, so later records never land behind a torn one; if that fails
+// too, the cache refuses all further writes.
+func (cache *FileCache) appendRecord(op byte, payload []byte) error {
+	if cache.closed {
+		return ErrClosed
+	}
+	if cache.broken != nil {
+		return cache.broken
+	}
+	if cache.dirDirty {
+		if err := syncDir(filepath.Dir(cache.filename)); err != nil {
+			return err
+		}
+		cache.dirDirty = false
+	}
+	offset, err := cache.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := cache.file.Write(encodeRecord(op, payload)); err != nil {
+		return cache.rollback(offset, err)
+	}
+	if err := cache.file.Sync(); err != nil {
+		return cache.rollback(offset, err)
+	}
+	cache.records++
+	return nil
+}
+
+func (cache *FileCache) rollback(offset int64, cause error) error {
+	err := cache.file.Truncate(offset)
+	if err == nil {
+		_, err = cache.file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		cache.broken = fmt.Errorf("cache log unusable after failed append: %w", errors.Join(cause, err))
+		return cache.broken
+	}
+	return cause
+}
+
+func (cache *FileCache) Get(key string) (interface{}, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	item, exists := cache.cache[key]
+	if !exists {
+		cache.stats.Misses++
+		return nil, false
+	}
+	if !time.Now().Before(item.Expiry) {
+		// Expiry needs no record: replay skips expired entries anyway.
+		cache.removeItem(key)
+		cache.stats.Expirations++
+		cache.stats.Misses++
+		return nil, false
+	}
+	cache.stats.Hits++
+	cache.evict.touch(key)
+	return item.Data, true
+}
+
+func (cache *FileCache) Set(key string, data interface{}, ttl time.Duration) error {
+	item := CacheItem{Key: key, Data: data, Expiry: time.Now().Add(ttl)}
+	payload, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if err := cache.appendRecord(opSet, payload); err != nil {
+		return err
+	}
+	if _, ok := cache.cache[key]; ok {
+		cache.evict.touch(key)
+	} else {
+		// Make room first: under LFU the new entry, with a single use,
+		// would otherwise be the first choice to evict.
+		if err := cache.evictOverflow(cache.maxSize - 1); err != nil {
+			return err
+		}
+		cache.evict.add(key)
+	}
+	cache.cache[key] = item
+	cache.wheel.schedule(key, ttl)
+	cache.maybeCompact()
+	return nil
+}
+
+func (cache *FileCache) Delete(key string) error {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if _, ok := cache.cache[key]; !ok {
+		return nil
+	}
+	if err := cache.appendRecord(opDelete, []byte(key)); err != nil {
+		return err
+	}
+	cache.removeItem(key)
+	cache.maybeCompact()
+	return nil
+}
+
+func (cache *FileCache) removeItem(key string) {
+	delete(cache.cache, key)
+	cache.evict.remove(key)
+	cache.wheel.cancel(key)
+}
+
+// evictOverflow removes entries chosen by the eviction policy until at
+// most limit are left. Evictions are logged so replay does not bring the
+// entries back.
+func (cache *FileCache) evictOverflow(limit int) error {
+	for len(cache.cache) > limit {
+		key, _ := cache.evict.victim()
+		if err := cache.appendRecord(opDelete, []byte(key)); err != nil {
+			return err
+		}
+		cache.removeItem(key)
+		cache.stats.Evictions++
+	}
+	return nil
+}
+
+// maybeCompact rewrites the log once superseded records outnumber live
+// ones, and there are enough of them to be worth it. It runs after a write
+// has been committed, so a failure is counted rather than returned; the
+// old log stays valid and the next write tries again.
+func (cache *FileCache) maybeCompact() {
+	if cache.records < 64 || cache.records < 2*len(cache.cache) {
+		return
+	}
+	if err := cache.compact(); err != nil {
+		cache.stats.CompactionFailures++
+		fmt.Printf("Compacting %s: %v\n", cache.filename, err)
+	}
+}
+
+// compact writes the live entries to a temporary file and renames it over
+// the log. Until the rename the old log is untouched; after it, the new
+// one is complete and synced.
+func (cache *FileCache) compact() error {
+	tmpName := cache.filename + ".compact"
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpName) // no-op once renamed
+
+	w := bufio.NewWriter(tmp)
+	now := time.Now()
+	records := 0
+	for _, item := range cache.cache {
+		if !now.Before(item.Expiry) {
+			continue
+		}
+		payload, err := json.Marshal(item)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(encodeRecord(opSet, payload))
+		records++
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmpName, cache.filename); err != nil {
+		tmp.Close()
+		return err
+	}
+	// The old log is unlinked now: switch to the new one before anything
+	// else can fail, or later appends would go to a file nobody reopens.
+	cache.file.Close()
+	cache.file = tmp // already positioned at the end
+	cache.records = records
+	cache.broken = nil // the new log holds exactly the acknowledged state
+	cache.stats.Compactions++
+	if err := syncDir(filepath.Dir(cache.filename)); err != nil {
+		cache.dirDirty = true
+		return err
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// handleExpiry turns the timing wheel once per tick and drops the entries
+// that came due.
+func (cache *FileCache) handleExpiry() {
+	defer close(cache.stopped)
+	for {
+		select {
+		case <-cache.expiryTicker.C:
+		case <-cache.done:
+			return
+		}
+		cache.mu.Lock()
+		now := time.Now()
+		for _, key := range cache.wheel.advance() {
+			item, ok := cache.cache[key]
+			if !ok {
+				continue
+			}
+			if now.Before(item.Expiry) {
+				// The ticker ran ahead of the clock; wait out the rest.
+				cache.wheel.schedule(key, item.Expiry.Sub(now))
+				continue
+			}
+			cache.removeItem(key)
+			cache.stats.Expirations++
+		}
+		cache.mu.Unlock()
+	}
+}
+
+func (cache *FileCache) Stats() Stats {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	s := cache.stats
+	s.Entries = len(cache.cache)
+	return s
+}
+
+func (cache *FileCache) Close() error {
+	cache.mu.Lock()
+	if cache.closed {
+		cache.mu.Unlock()
+		return nil
+	}
+	cache.closed = true
+	cache.mu.Unlock()
+
+	cache.expiryTicker.Stop()
+	close(cache.done)
+	<-cache.stopped
+	return cache.file.Close()
+}
+
+// shardedCache spreads keys over a directory of FileCaches by hash, so
+// writers to different shards do not contend for one lock or one log.
+// Each shard evicts on its own, holding maxSize/shards entries.
+type shardedCache struct {
+	cacheDir string
+	shards   []*FileCache
+}
+
+var _ Cache = (*shardedCache)(nil)
+
+func newShardedCache(cacheDir string, shards, maxSize int, policy EvictionPolicy) (*shardedCache, error) {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, err
+	}
+	shards = max(1, shards)
+	c := &shardedCache{cacheDir: cacheDir}
+	for i := range shards {
+		shard, err := NewFileCache(filepath.Join(cacheDir, fmt.Sprintf("shard-%02d.log", i)), maxSize/shards, policy)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.shards = append(c.shards, shard)
+	}
+	return c, nil
+}
+
+func (c *shardedCache) shard(key string) *FileCache {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+func (c *shardedCache) Get(key string) (interface{}, bool) { return c.shard(key).Get(key) }
+
+func (c *shardedCache) Set(key string, data interface{}, ttl time.Duration) error {
+	return c.shard(key).Set(key, data, ttl)
+}
+
+func (c *shardedCache) Delete(key string) error { return c.shard(key).Delete(key) }
+
+func (c *shardedCache) Stats() Stats {
+	var s Stats
+	for _, shard := range c.shards {
+		s.add(shard.Stats())
+	}
+	return s
+}
+
+func (c *shardedCache) Close() error {
+	var errs []error
+	for _, shard := range c.shards {
+		errs = append(errs, shard.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func must(err error) {
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	filename := "turn4A.log"
+	os.Remove(filename)
+
+	// LRU: reading key0 keeps it while key1, the least recently used,
+	// makes room for key3.
+	cache, err := NewFileCache(filename, 3, LRU)
+	must(err)
+	for i := range 3 {
+		must(cache.Set(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i), time.Minute))
+	}
+	cache.Get("key0")
+	must(cache.Set("key3", "value3", time.Minute))
+	_, ok := cache.Get("key1")
+	fmt.Println("LRU kept key1:", ok)
+	fmt.Println("LRU:", cache.Stats())
+	must(cache.Close())
+
+	// Simulate a crash part-way through appending a record.
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0)
+	must(err)
+	f.Write(encodeRecord(opSet, []byte(`{"Key":"key4","Data":"value4"}`))[:20])
+	f.Close()
+
+	cache, err = NewFileCache(filename, 3, LRU)
+	must(err)
+	for _, key := range []string{"key0", "key2", "key3", "key4"} {
+		value, ok := cache.Get(key)
+		fmt.Printf("After restart %s: %v %v\n", key, value, ok)
+	}
+	must(cache.Close())
+
+	// LFU: key0, read twice, outlives key1, read once.
+	lfuCache, err := NewFileCache("turn4A-lfu.log", 2, LFU)
+	must(err)
+	defer os.Remove("turn4A-lfu.log")
+	must(lfuCache.Set("key0", 0, time.Minute))
+	must(lfuCache.Set("key1", 1, time.Minute))
+	lfuCache.Get("key0")
+	lfuCache.Get("key0")
+	lfuCache.Get("key1")
+	must(lfuCache.Set("key2", 2, time.Minute))
+	_, ok = lfuCache.Get("key0")
+	fmt.Println("LFU kept key0:", ok)
+
+	// TTL expiry runs off the timing wheel without any reads.
+	must(lfuCache.Set("short", "lived", 1500*time.Millisecond))
+	time.Sleep(3500 * time.Millisecond)
+	fmt.Println("LFU:", lfuCache.Stats())
+	must(lfuCache.Close())
+
+	// The sharded backend behind the same interface.
+	var sharded Cache
+	sharded, err = newShardedCache("cache4A", 4, 100, LRU)
+	must(err)
+	defer os.RemoveAll("cache4A")
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			for j := range 50 {
+				must(sharded.Set(key, j, time.Minute))
+				sharded.Get(key)
+			}
+		}()
+	}
+	wg.Wait()
+	sharded.Get("missing")
+	fmt.Println("Sharded:", sharded.Stats())
+	must(sharded.Close())
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Map returns f applied to every element of s, in order. A nil slice maps
+// to nil and an empty one to an empty one, so callers comparing with
+// equalSlices see the same shape they passed in.
+func Map[S ~[]E, E, U any](s S, f func(E) U) []U {
+	if s == nil {
+		return nil
+	}
+	out := make([]U, len(s))
+	for i, v := range s {
+		out[i] = f(v)
+	}
+	return out
+}
+
+// Filter returns the elements of s for which keep returns true, in order.
+func Filter[S ~[]E, E any](s S, keep func(E) bool) S {
+	if s == nil {
+		return nil
+	}
+	out := make(S, 0, len(s))
+	for _, v := range s {
+		if keep(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Reduce folds s into a single value, left to right.
+func Reduce[S ~[]E, E, A any](s S, acc A, f func(A, E) A) A {
+	for _, v := range s {
+		acc = f(acc, v)
+	}
+	return acc
+}
+
+// GroupBy buckets the elements of s by key. Each bucket keeps the order
+// the elements had in s.
+func GroupBy[S ~[]E, E any, K comparable](s S, key func(E) K) map[K]S {
+	groups := make(map[K]S)
+	for _, v := range s {
+		k := key(v)
+		groups[k] = append(groups[k], v)
+	}
+	return groups
+}
+
+// Chunk splits s into consecutive pieces of size elements; the last one
+// may be shorter. The pieces share s's backing array but have their
+// capacity clipped, so appending to one cannot overwrite the next.
+func Chunk[S ~[]E, E any](s S, size int) []S {
+	if size < 1 {
+		panic("Chunk: size must be positive")
+	}
+	chunks := make([]S, 0, (len(s)+size-1)/size)
+	for i := 0; i < len(s); i += size {
+		end := min(i+size, len(s))
+		chunks = append(chunks, s[i:end:end])
+	}
+	return chunks
+}
+
+// Partition splits s into the elements that satisfy pred and those that
+// do not, both in order.
+func Partition[S ~[]E, E any](s S, pred func(E) bool) (yes, no S) {
+	for _, v := range s {
+		if pred(v) {
+			yes = append(yes, v)
+		} else {
+			no = append(no, v)
+		}
+	}
+	return yes, no
+}
+
+// MapEntries builds a new map from f applied to every entry of m. If f
+// maps two keys to the same new key, which value wins is unspecified.
+func MapEntries[M ~map[K]V, K comparable, V any, K2 comparable, V2 any](m M, f func(K, V) (K2, V2)) map[K2]V2 {
+	if m == nil {
+		return nil
+	}
+	out := make(map[K2]V2, len(m))
+	for k, v := range m {
+		k2, v2 := f(k, v)
+		out[k2] = v2
+	}
+	return out
+}
+
+// MapValues is MapEntries for the common case of keeping the keys.
+func MapValues[M ~map[K]V, K comparable, V, U any](m M, f func(V) U) map[K]U {
+	return MapEntries(m, func(k K, v V) (K, U) { return k, f(v) })
+}
+
+// FilterEntries returns the entries of m for which keep returns true.
+func FilterEntries[M ~map[K]V, K comparable, V any](m M, keep func(K, V) bool) M {
+	if m == nil {
+		return nil
+	}
+	out := make(M)
+	for k, v := range m {
+		if keep(k, v) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// ReduceEntries folds the entries of m in key order, so the result does
+// not depend on Go's randomized map iteration.
+func ReduceEntries[M ~map[K]V, K cmp.Ordered, V, A any](m M, acc A, f func(A, K, V) A) A {
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		acc = f(acc, k, m[k])
+	}
+	return acc
+}
+
+// GroupEntries buckets the entries of m by key.
+func GroupEntries[M ~map[K]V, K comparable, V any, G comparable](m M, key func(K, V) G) map[G]M {
+	groups := make(map[G]M)
+	for k, v := range m {
+		g := key(k, v)
+		if groups[g] == nil {
+			groups[g] = make(M)
+		}
+		groups[g][k] = v
+	}
+	return groups
+}
+
+// PartitionEntries splits m into the entries that satisfy pred and those
+// that do not. Both results are non-nil.
+func PartitionEntries[M ~map[K]V, K comparable, V any](m M, pred func(K, V) bool) (yes, no M) {
+	yes, no = make(M), make(M)
+	for k, v := range m {
+		if pred(k, v) {
+			yes[k] = v
+		} else {
+			no[k] = v
+		}
+	}
+	return yes, no
+}
+
+// parallelRange calls fn over [0, n) split into small blocks that workers
+// claim as they go, so uneven per-element cost does not leave workers idle.
+// workers <= 0 means GOMAXPROCS.
+func parallelRange(n, workers int, fn func(lo, hi int)) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	workers = min(workers, n)
+	if workers <= 1 {
+		if n > 0 {
+			fn(0, n)
+		}
+		return
+	}
+	block := max(1, n/(workers*8))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				lo := int(next.Add(int64(block))) - block
+				if lo >= n {
+					return
+				}
+				fn(lo, min(lo+block, n))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// ParallelMap is Map with f called from up to workers goroutines. Each
+// result is written to its element's index, so the output is in input
+// order however the calls interleave. f must be safe to call concurrently.
+func ParallelMap[S ~[]E, E, U any](s S, workers int, f func(E) U) []U {
+	if s == nil {
+		return nil
+	}
+	out := make([]U, len(s))
+	parallelRange(len(s), workers, func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			out[i] = f(s[i])
+		}
+	})
+	return out
+}
+
+// ParallelFilter is Filter with keep called from up to workers goroutines.
+// The kept elements are gathered afterwards, in input order.
+func ParallelFilter[S ~[]E, E any](s S, workers int, keep func(E) bool) S {
+	if s == nil {
+		return nil
+	}
+	kept := ParallelMap(s, workers, keep)
+	out := make(S, 0, len(s))
+	for i, v := range s {
+		if kept[i] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// MapSeq lazily applies f to every value of seq.
+func MapSeq[E, U any](seq iter.Seq[E], f func(E) U) iter.Seq[U] {
+	return func(yield func(U) bool) {
+		for v := range seq {
+			if !yield(f(v)) {
+				return
+			}
+		}
+	}
+}
+
+// FilterSeq lazily yields the values of seq for which keep returns true.
+func FilterSeq[E any](seq iter.Seq[E], keep func(E) bool) iter.Seq[E] {
+	return func(yield func(E) bool) {
+		for v := range seq {
+			if keep(v) && !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// ReduceSeq folds seq into a single value.
+func ReduceSeq[E, A any](seq iter.Seq[E], acc A, f func(A, E) A) A {
+	for v := range seq {
+		acc = f(acc, v)
+	}
+	return acc
+}
+
+// ChunkSeq groups the values of seq into slices of size; the last may be
+// shorter. Each slice is freshly allocated, so callers may keep it.
+func ChunkSeq[E any](seq iter.Seq[E], size int) iter.Seq[[]E] {
+	if size < 1 {
+		panic("ChunkSeq: size must be positive")
+	}
+	return func(yield func([]E) bool) {
+		var chunk []E
+		for v := range seq {
+			chunk = append(chunk, v)
+			if len(chunk) == size {
+				if !yield(chunk) {
+					return
+				}
+				chunk = nil
+			}
+		}
+		if len(chunk) > 0 {
+			yield(chunk)
+		}
+	}
+}
+
+// MapSeq2 lazily applies f to every pair of seq, such as maps.All(m).
+func MapSeq2[K, V, K2, V2 any](seq iter.Seq2[K, V], f func(K, V) (K2, V2)) iter.Seq2[K2, V2] {
+	return func(yield func(K2, V2) bool) {
+		for k, v := range seq {
+			if !yield(f(k, v)) {
+				return
+			}
+		}
+	}
+}
+
+// FilterSeq2 lazily yields the pairs of seq for which keep returns true.
+func FilterSeq2[K, V any](seq iter.Seq2[K, V], keep func(K, V) bool) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		for k, v := range seq {
+			if keep(k, v) && !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// Helper functions to compare collections. As before, nil and empty are
+// different: a nil result where an empty one was expected is a bug.
+
+// equalSlices compares element by element. It covers what equalStrings
+// and equalStructs did for any comparable element type, structs included.
+func equalSlices[S ~[]E, E comparable](a, b S) bool {
+	return equalSlicesFunc(a, b, func(x, y E) bool { return x == y })
+}
+
+// equalSlicesFunc compares with eq, for elements that are not comparable
+// or whose equality is looser than ==.
+func equalSlicesFunc[S1 ~[]E1, S2 ~[]E2, E1, E2 any](a S1, b S2, eq func(E1, E2) bool) bool {
+	if (a == nil) != (b == nil) {
+		return false
+	}
+	return slices.EqualFunc(a, b, eq)
+}
+
+func equalMaps[M1 ~map[K]V, M2 ~map[K]V, K, V comparable](a M1, b M2) bool {
+	return equalMapsFunc(a, b, func(x, y V) bool { return x == y })
+}
+
+func equalMapsFunc[M1 ~map[K]V1, M2 ~map[K]V2, K comparable, V1, V2 any](a M1, b M2, eq func(V1, V2) bool) bool {
+	if (a == nil) != (b == nil) {
+		return false
+	}
+	return maps.EqualFunc(a, b, eq)
+}
+
+// Sample struct to use for testing slices of structs
+type Person struct {
+	Name string
+	Age  int
+}
+
+// addPrefix and friends, rewritten on top of the generic functions.
+func addPrefix(strings []string, prefix string) []string {
+	return Map(strings, func(s string) string { return prefix + s })
+}
+
+func addPrefixToKeys[V any](m map[string]V, prefix string) map[string]V {
+	return MapEntries(m, func(k string, v V) (string, V) { return prefix + k, v })
+}
+
+func addPrefixToNames(people []Person, prefix string) []Person {
+	return Map(people, func(p Person) Person {
+		p.Name = prefix + p.Name
+		return p
+	})
+}
+
+func doubleSlice(input []int) []int {
+	return Map(input, func(v int) int { return v * 2 })
+}
+
+func main() {
+	people := []Person{{"Alice", 25}, {"Bob", 30}, {"Carol", 35}, {"Dan", 17}}
+
+	fmt.Println(addPrefix([]string{"a", "b", "c"}, "test"))
+	fmt.Println(addPrefixToNames(people, "user"))
+	fmt.Println(doubleSlice([]int{1, 2, 3}))
+
+	adults, minors := Partition(people, func(p Person) bool { return p.Age >= 18 })
+	fmt.Println("adults:", adults, "minors:", minors)
+
+	byDecade := GroupBy(people, func(p Person) int { return p.Age / 10 * 10 })
+	for _, decade := range slices.Sorted(maps.Keys(byDecade)) {
+		fmt.Printf("%ds: %v\n", decade, byDecade[decade])
+	}
+
+	total := Reduce(people, 0, func(sum int, p Person) int { return sum + p.Age })
+	fmt.Println("total age:", total)
+	fmt.Println("chunks:", Chunk(people, 3))
+
+	ages := map[string]int{"Alice": 25, "Bob": 30}
+	fmt.Println(addPrefixToKeys(ages, "user:"))
+	fmt.Println(ReduceEntries(ages, "", func(acc, name string, age int) string {
+		return acc + fmt.Sprintf("%s=%d ", name, age)
+	}))
+
+	squares := ParallelMap([]int{1, 2, 3, 4, 5}, 4, func(v int) int { return v * v })
+	fmt.Println("squares:", squares)
+
+	evens := FilterSeq(slices.Values(squares), func(v int) bool { return v%2 == 0 })
+	for chunk := range ChunkSeq(MapSeq(evens, func(v int) string { return fmt.Sprint(v) }), 2) {
+		fmt.Println("chunk:", chunk)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMapKeepsShapeAndOrder(t *testing.T) {
+	testCases := []struct {
+		input    []string
+		prefix   string
+		expected []string
+	}{
+		{[]string{"a", "b", "c"}, "test", []string{"testa", "testb", "testc"}},
+		{[]string{}, "x", []string{}},
+		{nil, "x", nil},
+	}
+	for _, testCase := range testCases {
+		actualOutput := addPrefix(testCase.input, testCase.prefix)
+		if !equalSlices(actualOutput, testCase.expected) {
+			t.Errorf("addPrefix(%#v, %q) = %#v, want %#v", testCase.input, testCase.prefix, actualOutput, testCase.expected)
+		}
+	}
+
+	if got := doubleSlice([]int{-1, 0, 5}); !equalSlices(got, []int{-2, 0, 10}) {
+		t.Errorf("doubleSlice = %v", got)
+	}
+	people := []Person{{"Alice", 25}, {"Bob", 30}}
+	if got := addPrefixToNames(people, "user"); !equalSlices(got, []Person{{"userAlice", 25}, {"userBob", 30}}) {
+		t.Errorf("addPrefixToNames = %v", got)
+	}
+	if people[0].Name != "Alice" {
+		t.Error("addPrefixToNames modified its input")
+	}
+}
+
+func TestFilterReducePartition(t *testing.T) {
+	nums := []int{1, 2, 3, 4, 5, 6}
+	isEven := func(v int) bool { return v%2 == 0 }
+
+	if got := Filter(nums, isEven); !equalSlices(got, []int{2, 4, 6}) {
+		t.Errorf("Filter = %v", got)
+	}
+	if got := Filter([]int{}, isEven); got == nil || len(got) != 0 {
+		t.Errorf("Filter of empty = %#v, want empty", got)
+	}
+	if got := Reduce(nums, "", func(acc string, v int) string { return acc + strconv.Itoa(v) }); got != "123456" {
+		t.Errorf("Reduce = %q, want left-to-right order", got)
+	}
+	yes, no := Partition(nums, isEven)
+	if !equalSlices(yes, []int{2, 4, 6}) || !equalSlices(no, []int{1, 3, 5}) {
+		t.Errorf("Partition = %v, %v", yes, no)
+	}
+}
+
+func TestGroupByKeepsOrderWithinGroups(t *testing.T) {
+	words := []string{"bob", "al", "cy", "dave", "eve", "jo"}
+	groups := GroupBy(words, func(s string) int { return len(s) })
+	expected := map[int][]string{2: {"al", "cy", "jo"}, 3: {"bob", "eve"}, 4: {"dave"}}
+	if !equalMapsFunc(groups, expected, equalSlices[[]string]) {
+		t.Errorf("GroupBy = %v, want %v", groups, expected)
+	}
+}
+
+func TestChunk(t *testing.T) {
+	nums := []int{1, 2, 3, 4, 5}
+	chunks := Chunk(nums, 2)
+	expected := [][]int{{1, 2}, {3, 4}, {5}}
+	if !equalSlicesFunc(chunks, expected, equalSlices[[]int]) {
+		t.Fatalf("Chunk = %v, want %v", chunks, expected)
+	}
+	chunks[0] = append(chunks[0], 99)
+	if nums[2] != 3 {
+		t.Error("appending to a chunk overwrote the next one")
+	}
+	if got := Chunk([]int{}, 3); len(got) != 0 {
+		t.Errorf("Chunk of empty = %v", got)
+	}
+}
+
+func TestMapFunctions(t *testing.T) {
+	ages := map[string]int{"Alice": 25, "Bob": 30, "Dan": 17}
+
+	if got := addPrefixToKeys(ages, "user:"); !equalMaps(got, map[string]int{"user:Alice": 25, "user:Bob": 30, "user:Dan": 17}) {
+		t.Errorf("addPrefixToKeys = %v", got)
+	}
+	if got := MapValues(ages, func(age int) bool { return age >= 18 }); !equalMaps(got, map[string]bool{"Alice": true, "Bob": true, "Dan": false}) {
+		t.Errorf("MapValues = %v", got)
+	}
+	adult := func(_ string, age int) bool { return age >= 18 }
+	if got := FilterEntries(ages, adult); !equalMaps(got, map[string]int{"Alice": 25, "Bob": 30}) {
+		t.Errorf("FilterEntries = %v", got)
+	}
+	yes, no := PartitionEntries(ages, adult)
+	if len(yes) != 2 || !equalMaps(no, map[string]int{"Dan": 17}) {
+		t.Errorf("PartitionEntries = %v, %v", yes, no)
+	}
+	groups := GroupEntries(ages, func(name string, _ int) byte { return name[0] })
+	if len(groups) != 3 || !equalMaps(groups['B'], map[string]int{"Bob": 30}) {
+		t.Errorf("GroupEntries = %v", groups)
+	}
+	names := ReduceEntries(ages, "", func(acc, name string, _ int) string { return acc + name + "," })
+	if names != "Alice,Bob,Dan," {
+		t.Errorf("ReduceEntries = %q, want key order", names)
+	}
+	var nilMap map[string]int
+	if MapEntries(nilMap, func(k string, v int) (string, int) { return k, v }) != nil || FilterEntries(nilMap, adult) != nil {
+		t.Error("nil map did not map to nil")
+	}
+}
+
+func TestParallelVariantsPreserveOrder(t *testing.T) {
+	nums := make([]int, 1000)
+	for i := range nums {
+		nums[i] = i
+	}
+	// Early elements take longest, so they finish last.
+	slowFirst := func(v int) int {
+		if v < 10 {
+			time.Sleep(time.Millisecond)
+		}
+		return v * v
+	}
+	for _, workers := range []int{0, 1, 3, 16, 5000} {
+		got := ParallelMap(nums, workers, slowFirst)
+		if !equalSlices(got, Map(nums, slowFirst)) {
+			t.Fatalf("workers=%d: ParallelMap out of order", workers)
+		}
+		keep := func(v int) bool { return v%7 == 0 }
+		if !equalSlices(ParallelFilter(nums, workers, keep), Filter(nums, keep)) {
+			t.Fatalf("workers=%d: ParallelFilter out of order", workers)
+		}
+	}
+	if ParallelMap([]int(nil), 4, slowFirst) != nil || ParallelMap([]int{}, 4, slowFirst) == nil {
+		t.Error("ParallelMap changed nil-ness")
+	}
+}
+
+func TestSeqAdapters(t *testing.T) {
+	seq := slices.Values([]int{1, 2, 3, 4, 5, 6, 7})
+	evens := FilterSeq(seq, func(v int) bool { return v%2 == 0 })
+	labels := slices.Collect(MapSeq(evens, strconv.Itoa))
+	if !equalSlices(labels, []string{"2", "4", "6"}) {
+		t.Errorf("MapSeq(FilterSeq) = %v", labels)
+	}
+	if sum := ReduceSeq(seq, 0, func(a, v int) int { return a + v }); sum != 28 {
+		t.Errorf("ReduceSeq = %d", sum)
+	}
+	chunks := slices.Collect(ChunkSeq(seq, 3))
+	if !equalSlicesFunc(chunks, [][]int{{1, 2, 3}, {4, 5, 6}, {7}}, equalSlices[[]int]) {
+		t.Errorf("ChunkSeq = %v", chunks)
+	}
+
+	// Stopping early must stop pulling from the source.
+	pulled := 0
+	counting := func(yield func(int) bool) {
+		for i := 0; ; i++ {
+			pulled++
+			if !yield(i) {
+				return
+			}
+		}
+	}
+	for range MapSeq(counting, func(v int) int { return v }) {
+		if pulled == 3 {
+			break
+		}
+	}
+	if pulled != 3 {
+		t.Errorf("pulled %d values after breaking at 3", pulled)
+	}
+
+	ages := map[string]int{"Alice": 25, "Bob": 30, "Dan": 17}
+	adults := maps.Collect(FilterSeq2(maps.All(ages), func(_ string, age int) bool { return age >= 18 }))
+	prefixed := maps.Collect(MapSeq2(maps.All(adults), func(name string, age int) (string, string) {
+		return "user:" + name, strconv.Itoa(age)
+	}))
+	if !equalMaps(prefixed, map[string]string{"user:Alice": "25", "user:Bob": "30"}) {
+		t.Errorf("MapSeq2(FilterSeq2) = %v", prefixed)
+	}
+}
+
+func TestEqualityHelpers(t *testing.T) {
+	if equalSlices([]string{}, nil) || equalSlices(nil, []string{}) {
+		t.Error("nil and empty slices compared equal")
+	}
+	if !equalSlices([]Person{{"Alice", 25}}, []Person{{"Alice", 25}}) || equalSlices([]Person{{"Alice", 25}}, []Person{{"Alice", 26}}) {
+		t.Error("equalSlices on structs")
+	}
+	if equalMaps(map[string]int{}, map[string]int(nil)) || !equalMaps(map[string]int{"a": 1}, map[string]int{"a": 1}) {
+		t.Error("equalMaps")
+	}
+	if equalMaps(map[string]int{"a": 0}, map[string]int{"b": 0}) {
+		t.Error("equalMaps treated a missing key as a zero value")
+	}
+	type named map[string]int
+	if !equalMaps(named{"a": 1}, map[string]int{"a": 1}) {
+		t.Error("equalMaps across named map types")
+	}
+	lengths := func(s string, n int) bool { return len(s) == n }
+	if !equalSlicesFunc([]string{"ab", "c"}, []int{2, 1}, lengths) {
+		t.Error("equalSlicesFunc across element types")
+	}
+}
+
+// addPrefixReflect is the reflection-based version the generic functions
+// replace: it accepts anything and prefixes strings in slice elements, map
+// keys and struct fields, copying as it goes.
+func addPrefixReflect(input interface{}, prefix string) interface{} {
+	if input == nil {
+		return nil
+	}
+	return prefixValue(reflect.ValueOf(input), prefix).Interface()
+}
+
+func prefixValue(v reflect.Value, prefix string) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(prefix + v.String()).Convert(v.Type())
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(prefixValue(v.Index(i), prefix))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(prefixValue(iter.Key(), prefix), iter.Value())
+		}
+		return out
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := out.Field(i); f.Kind() == reflect.String && f.CanSet() {
+				f.Set(prefixValue(f, prefix))
+			}
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func TestReflectVersionAgrees(t *testing.T) {
+	strs := []string{"a", "b"}
+	people := []Person{{"Alice", 25}}
+	ages := map[string]int{"Alice": 25}
+	if got := addPrefixReflect(strs, "p").([]string); !equalSlices(got, addPrefix(strs, "p")) {
+		t.Errorf("strings: %v", got)
+	}
+	if got := addPrefixReflect(people, "p").([]Person); !equalSlices(got, addPrefixToNames(people, "p")) {
+		t.Errorf("structs: %v", got)
+	}
+	if got := addPrefixReflect(ages, "p").(map[string]int); !equalMaps(got, addPrefixToKeys(ages, "p")) {
+		t.Errorf("maps: %v", got)
+	}
+}
+
+func benchData(n int) ([]string, []Person, map[string]int) {
+	strs := make([]string, n)
+	people := make([]Person, n)
+	ages := make(map[string]int, n)
+	for i := range n {
+		strs[i] = fmt.Sprintf("item%d", i)
+		people[i] = Person{Name: strs[i], Age: i % 100}
+		ages[strs[i]] = i % 100
+	}
+	return strs, people, ages
+}
+
+func BenchmarkAddPrefix(b *testing.B) {
+	strs, people, ages := benchData(10_000)
+	b.Run("strings/generic", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			addPrefix(strs, "prefix_")
+		}
+	})
+	b.Run("strings/reflect", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			addPrefixReflect(strs, "prefix_")
+		}
+	})
+	b.Run("structs/generic", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			addPrefixToNames(people, "prefix_")
+		}
+	})
+	b.Run("structs/reflect", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			addPrefixReflect(people, "prefix_")
+		}
+	})
+	b.Run("map/generic", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			addPrefixToKeys(ages, "prefix_")
+		}
+	})
+	b.Run("map/reflect", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			addPrefixReflect(ages, "prefix_")
+		}
+	})
+}
+
+func BenchmarkParallelMap(b *testing.B) {
+	nums := make([]int, 100_000)
+	for i := range nums {
+		nums[i] = i
+	}
+	work := func(v int) int {
+		for range 50 {
+			v = v*31 + 7
+		}
+		return v
+	}
+	b.Run("serial", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			Map(nums, work)
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			ParallelMap(nums, 0, work)
+		}
+	})
+}
@@ -0,0 +1,809 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Frame is one request or reply. A request's first part is the command.
+// An error reply carries Err and no parts.
+type Frame struct {
+	Parts []string
+	Err   string
+}
+
+// maxFrameSize bounds what a peer can make us buffer for one frame.
+const maxFrameSize = 1 << 20
+
+// ProtocolError reports a malformed frame. The stream cannot be resynced
+// after one, so the connection is closed.
+type ProtocolError struct{ msg string }
+
+func (e *ProtocolError) Error() string { return "protocol error: " + e.msg }
+
+func protocolErrorf(format string, args ...any) error {
+	return &ProtocolError{fmt.Sprintf(format, args...)}
+}
+
+// Codec frames messages on a stream. ReadFrame and WriteFrame are called
+// from one goroutine at a time per connection.
+type Codec interface {
+	ReadFrame(r *bufio.Reader) (Frame, error)
+	WriteFrame(w *bufio.Writer, f Frame) error
+}
+
+var codecs = map[string]Codec{
+	"line":   LineCodec{},
+	"length": LengthCodec{},
+	"resp":   RESPCodec{},
+}
+
+// LineCodec is one frame per line: space-separated parts, or "-message"
+// for an error. Replies start with "+"; requests may omit it, so the
+// server can be driven by hand with telnet or nc. Parts cannot be empty
+// or contain whitespace.
+type LineCodec struct{}
+
+func (LineCodec) ReadFrame(r *bufio.Reader) (Frame, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return Frame{}, err
+	}
+	if msg, ok := strings.CutPrefix(line, "-"); ok {
+		return Frame{Err: msg}, nil
+	}
+	return Frame{Parts: strings.Fields(strings.TrimPrefix(line, "+"))}, nil
+}
+
+func (LineCodec) WriteFrame(w *bufio.Writer, f Frame) error {
+	if f.Err != "" {
+		_, err := w.WriteString("-" + strings.ReplaceAll(f.Err, "\n", " ") + "\n")
+		return err
+	}
+	for _, p := range f.Parts {
+		if p == "" || strings.ContainsAny(p, " \t\r\n") {
+			return fmt.Errorf("line codec cannot encode %q", p)
+		}
+	}
+	_, err := w.WriteString("+" + strings.Join(f.Parts, " ") + "\n")
+	return err
+}
+
+// readLine reads up to and strips "\n" or "\r\n", refusing lines longer
+// than maxFrameSize.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxFrameSize {
+			return "", protocolErrorf("line longer than %d bytes", maxFrameSize)
+		}
+		if err == nil {
+			break
+		}
+		if err == io.EOF && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		if err != bufio.ErrBufferFull {
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// LengthCodec is binary: a kind byte (0 for parts, 1 for an error), a
+// big-endian uint32 part count and each part as a uint32 length and its
+// bytes. Parts may hold anything.
+type LengthCodec struct{}
+
+func (LengthCodec) ReadFrame(r *bufio.Reader) (Frame, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Frame{}, err
+	}
+	kind, n := header[0], binary.BigEndian.Uint32(header[1:])
+	if kind > 1 || kind == 1 && n != 1 {
+		return Frame{}, protocolErrorf("bad frame header %x", header)
+	}
+	parts := make([]string, 0, min(n, 64))
+	total := 0
+	for range n {
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return Frame{}, unexpectedEOF(err)
+		}
+		total += 4 + int(binary.BigEndian.Uint32(size[:]))
+		if total > maxFrameSize {
+			return Frame{}, protocolErrorf("frame larger than %d bytes", maxFrameSize)
+		}
+		part := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(r, part); err != nil {
+			return Frame{}, unexpectedEOF(err)
+		}
+		parts = append(parts, string(part))
+	}
+	if kind == 1 {
+		return Frame{Err: parts[0]}, nil
+	}
+	return Frame{Parts: parts}, nil
+}
+
+func (LengthCodec) WriteFrame(w *bufio.Writer, f Frame) error {
+	kind, parts := byte(0), f.Parts
+	if f.Err != "" {
+		kind, parts = 1, []string{f.Err}
+	}
+	w.WriteByte(kind)
+	w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(parts))))
+	for _, p := range parts {
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(len(p))))
+		if _, err := w.WriteString(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unexpectedEOF turns an EOF part-way through a frame into
+// io.ErrUnexpectedEOF, so only a clean EOF between frames reads as one.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// RESPCodec speaks a subset of Redis's protocol: arrays of bulk strings,
+// "+simple" strings and "-error" replies. Requests may also be inline
+// commands (a plain line), as redis-cli and telnet send them.
+type RESPCodec struct{}
+
+func (RESPCodec) ReadFrame(r *bufio.Reader) (Frame, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return Frame{}, err
+	}
+	if line == "" {
+		return Frame{}, nil
+	}
+	switch line[0] {
+	case '-':
+		return Frame{Err: line[1:]}, nil
+	case '+':
+		return Frame{Parts: []string{line[1:]}}, nil
+	case '*':
+	default:
+		return Frame{Parts: strings.Fields(line)}, nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxFrameSize/4 {
+		return Frame{}, protocolErrorf("bad array length %q", line)
+	}
+	parts := make([]string, 0, min(n, 64))
+	total := 0
+	for range n {
+		header, err := readLine(r)
+		if err != nil {
+			return Frame{}, unexpectedEOF(err)
+		}
+		size, err := strconv.Atoi(strings.TrimPrefix(header, "$"))
+		if !strings.HasPrefix(header, "$") || err != nil || size < 0 {
+			return Frame{}, protocolErrorf("bad bulk string header %q", header)
+		}
+		if total += size; total > maxFrameSize {
+			return Frame{}, protocolErrorf("frame larger than %d bytes", maxFrameSize)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return Frame{}, unexpectedEOF(err)
+		}
+		if string(buf[size:]) != "\r\n" {
+			return Frame{}, protocolErrorf("bulk string not terminated by CRLF")
+		}
+		parts = append(parts, string(buf[:size]))
+	}
+	return Frame{Parts: parts}, nil
+}
+
+func (RESPCodec) WriteFrame(w *bufio.Writer, f Frame) error {
+	if f.Err != "" {
+		_, err := w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(f.Err) + "\r\n")
+		return err
+	}
+	fmt.Fprintf(w, "*%d\r\n", len(f.Parts))
+	for _, p := range f.Parts {
+		fmt.Fprintf(w, "$%d\r\n", len(p))
+		w.WriteString(p)
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandlerFunc serves one command. args excludes the command itself.
+type HandlerFunc func(ctx context.Context, args []string) ([]string, error)
+
+// Router dispatches requests by command name, case-insensitively.
+type Router struct {
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+}
+
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]HandlerFunc)}
+}
+
+func (r *Router) Handle(command string, h HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[strings.ToUpper(command)] = h
+}
+
+// Dispatch runs the request's handler and turns its result, error or
+// panic into a reply.
+func (r *Router) Dispatch(ctx context.Context, req Frame) (reply Frame) {
+	if len(req.Parts) == 0 {
+		return Frame{Err: "ERR empty command"}
+	}
+	command := strings.ToUpper(req.Parts[0])
+	r.mu.RLock()
+	h, ok := r.handlers[command]
+	r.mu.RUnlock()
+	if !ok {
+		return Frame{Err: fmt.Sprintf("ERR unknown command %q", req.Parts[0])}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("Handler for %s panicked: %v\n", command, p)
+			reply = Frame{Err: "ERR internal error"}
+		}
+	}()
+	parts, err := h(ctx, req.Parts[1:])
+	if err != nil {
+		return Frame{Err: "ERR " + err.Error()}
+	}
+	return Frame{Parts: parts}
+}
+
+var ErrServerClosed = errors.New("server closed")
+
+// Server serves framed requests over TCP. Requests on one connection are
+// handled in order, so clients may pipeline them.
+type Server struct {
+	Addr         string
+	Codec        Codec
+	Router       *Router
+	MaxConns     int           // connections beyond this are told so and closed
+	IdleTimeout  time.Duration // close connections idle this long; 0 for never
+	WriteTimeout time.Duration // per reply; 0 for none
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*serverConn]struct{}
+	draining atomic.Bool
+	wg       sync.WaitGroup
+	ctx      context.Context // passed to handlers; cancelled on forced close
+	cancel   context.CancelFunc
+}
+
+type serverConn struct {
+	net.Conn
+	mu   sync.Mutex
+	idle bool // waiting for the next request
+}
+
+// ListenAndServe listens on s.Addr and serves until Shutdown.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Shutdown, when it returns
+// ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.draining.Load() {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.conns = make(map[*serverConn]struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.mu.Unlock()
+
+	slots := make(chan struct{}, max(1, s.MaxConns))
+	var backoff time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.draining.Load() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() || errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) {
+				backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+				log.Printf("Error accepting connection: %v; retrying in %v\n", err, backoff)
+				time.Sleep(backoff)
+				continue
+			}
+			return err
+		}
+		backoff = 0
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			go s.reject(conn)
+			continue
+		}
+		sc := &serverConn{Conn: conn}
+		s.mu.Lock()
+		if s.draining.Load() {
+			// Accepted just as Shutdown closed the listener.
+			s.mu.Unlock()
+			conn.Close()
+			<-slots
+			return ErrServerClosed
+		}
+		s.conns[sc] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer func() { <-slots }()
+			s.serveConn(sc)
+		}()
+	}
+}
+
+func (s *Server) reject(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	bw := bufio.NewWriter(conn)
+	s.Codec.WriteFrame(bw, Frame{Err: "ERR max connections reached"})
+	bw.Flush()
+}
+
+func (s *Server) serveConn(conn *serverConn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	br := bufio.NewReader(conn)
+	bw := bufio.NewWriter(conn)
+	for {
+		if !s.awaitRequest(conn, br) {
+			return
+		}
+		req, err := s.Codec.ReadFrame(br)
+		conn.mu.Lock()
+		conn.idle = false
+		conn.mu.Unlock()
+		if err != nil {
+			var pe *ProtocolError
+			var ne net.Error
+			switch {
+			case errors.As(err, &pe):
+				s.Codec.WriteFrame(bw, Frame{Err: "ERR " + err.Error()})
+				bw.Flush()
+			case errors.As(err, &ne) && ne.Timeout() && !s.draining.Load():
+				log.Println("Closing idle connection from", conn.RemoteAddr())
+			case err != io.EOF && !s.draining.Load():
+				log.Printf("Error reading from %s: %v\n", conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		reply := s.Router.Dispatch(s.ctx, req)
+		if s.WriteTimeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+		}
+		if err := s.Codec.WriteFrame(bw, reply); err != nil {
+			s.Codec.WriteFrame(bw, Frame{Err: "ERR " + err.Error()})
+		}
+		// Replies to pipelined requests go out together once the
+		// requests already received have been answered.
+		if br.Buffered() == 0 {
+			if err := bw.Flush(); err != nil {
+				log.Printf("Error writing to %s: %v\n", conn.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+}
+
+// awaitRequest marks conn idle and arms its idle timeout, or reports that
+// it should close because the server is draining and it has no requests
+// left to answer. Shutdown takes conn.mu to find idle connections, so a
+// connection cannot slip back into a long read after being woken.
+func (s *Server) awaitRequest(conn *serverConn, br *bufio.Reader) bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if s.draining.Load() {
+		if br.Buffered() == 0 {
+			return false
+		}
+		return true // answer what the client already sent
+	}
+	conn.idle = true
+	if s.IdleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.IdleTimeout))
+	} else {
+		conn.SetReadDeadline(time.Time{})
+	}
+	return true
+}
+
+// Shutdown stops accepting, lets every connection finish the requests it
+// has received and closes it. If ctx ends first, the remaining
+// connections are closed and their handlers' context is cancelled.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining.Store(true)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.mu.Lock()
+		if conn.idle {
+			conn.SetReadDeadline(time.Now()) // wake it up to close
+		}
+		conn.mu.Unlock()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		if s.cancel != nil {
+			s.cancel()
+		}
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if s.cancel != nil {
+			s.cancel()
+		}
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// ServerError is an error reply from the server.
+type ServerError struct{ Msg string }
+
+func (e *ServerError) Error() string { return e.Msg }
+
+// Client sends requests over one connection. Any number of goroutines may
+// call it; requests are written as they arrive without waiting for earlier
+// replies, and replies are matched to requests in order.
+type Client struct {
+	conn  net.Conn
+	codec Codec
+
+	writeMu sync.Mutex
+	pending chan *Call // awaiting replies, in request order; bounds pipelining
+
+	failOnce sync.Once
+	err      error
+	done     chan struct{}
+}
+
+// Call is a request in flight. Done is closed when Reply or Err is set.
+type Call struct {
+	Reply []string
+	Err   error
+	Done  chan struct{}
+}
+
+// Dial connects to addr. At most maxPipeline requests are outstanding at
+// once; further calls block until replies arrive.
+func Dial(ctx context.Context, addr string, codec Codec, maxPipeline int) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		conn:    conn,
+		codec:   codec,
+		pending: make(chan *Call, max(1, maxPipeline)),
+		done:    make(chan struct{}),
+	}
+	go c.readReplies()
+	return c, nil
+}
+
+func (c *Client) readReplies() {
+	br := bufio.NewReader(c.conn)
+	for {
+		f, err := c.codec.ReadFrame(br)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		var call *Call
+		select {
+		case call = <-c.pending:
+		default:
+			// Unsolicited, such as being turned away at the limit.
+			if f.Err != "" {
+				err = &ServerError{f.Err}
+			} else {
+				err = protocolErrorf("unexpected reply %q", f.Parts)
+			}
+			c.fail(err)
+			return
+		}
+		if f.Err != "" {
+			call.Err = &ServerError{f.Err}
+		} else {
+			call.Reply = f.Parts
+		}
+		close(call.Done)
+	}
+}
+
+// fail closes the connection and fails every outstanding call with err.
+func (c *Client) fail(err error) {
+	c.failOnce.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	})
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	for {
+		select {
+		case call := <-c.pending:
+			call.Err = c.err
+			close(call.Done)
+		default:
+			return
+		}
+	}
+}
+
+// Go sends a request without waiting for its reply.
+func (c *Client) Go(command string, args ...string) *Call {
+	call := &Call{Done: make(chan struct{})}
+	// Encode first, so a request the codec cannot carry fails on its own
+	// instead of leaving half a frame on the connection.
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	err := c.codec.WriteFrame(bw, Frame{Parts: append([]string{command}, args...)})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		call.Err = err
+		close(call.Done)
+		return call
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	// Checked first on its own: once fail has drained pending, a call
+	// queued here would never be answered.
+	select {
+	case <-c.done:
+		call.Err = c.err
+		close(call.Done)
+		return call
+	default:
+	}
+	select {
+	case <-c.done:
+		call.Err = c.err
+		close(call.Done)
+		return call
+	case c.pending <- call:
+	}
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		// The stream may now hold half a frame; nothing after it can be
+		// trusted. fail needs writeMu, which we hold.
+		go c.fail(err)
+	}
+	return call
+}
+
+// Do sends a request and waits for its reply. If ctx ends first, Do
+// returns; the reply is discarded when it arrives.
+func (c *Client) Do(ctx context.Context, command string, args ...string) ([]string, error) {
+	call := c.Go(command, args...)
+	select {
+	case <-call.Done:
+		return call.Reply, call.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) Close() error {
+	c.fail(net.ErrClosed)
+	return nil
+}
+
+// newStoreRouter registers a few commands over an in-memory store.
+func newStoreRouter() *Router {
+	var mu sync.Mutex
+	store := make(map[string]string)
+
+	r := NewRouter()
+	r.Handle("PING", func(ctx context.Context, args []string) ([]string, error) {
+		return []string{"PONG"}, nil
+	})
+	r.Handle("ECHO", func(ctx context.Context, args []string) ([]string, error) {
+		return args, nil
+	})
+	r.Handle("SET", func(ctx context.Context, args []string) ([]string, error) {
+		if len(args) != 2 {
+			return nil, errors.New("usage: SET key value")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		store[args[0]] = args[1]
+		return []string{"OK"}, nil
+	})
+	r.Handle("GET", func(ctx context.Context, args []string) ([]string, error) {
+		if len(args) != 1 {
+			return nil, errors.New("usage: GET key")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if v, ok := store[args[0]]; ok {
+			return []string{v}, nil
+		}
+		return []string{}, nil
+	})
+	r.Handle("INCR", func(ctx context.Context, args []string) ([]string, error) {
+		if len(args) != 1 {
+			return nil, errors.New("usage: INCR key")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		n, err := strconv.Atoi(store[args[0]])
+		if err != nil && store[args[0]] != "" {
+			return nil, errors.New("value is not an integer")
+		}
+		store[args[0]] = strconv.Itoa(n + 1)
+		return []string{store[args[0]]}, nil
+	})
+	r.Handle("SLEEP", func(ctx context.Context, args []string) ([]string, error) {
+		ms, err := strconv.Atoi(strings.Join(args, ""))
+		if err != nil {
+			return nil, errors.New("usage: SLEEP milliseconds")
+		}
+		select {
+		case <-time.After(time.Duration(ms) * time.Millisecond):
+			return []string{"OK"}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	return r
+}
+
+// TCP server function to handle incoming client connections
+func startTCPServer(addr string, codec Codec, maxConns int, idle time.Duration) {
+	srv := &Server{
+		Addr:         addr,
+		Codec:        codec,
+		Router:       newStoreRouter(),
+		MaxConns:     maxConns,
+		IdleTimeout:  idle,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down: draining connections")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Println("Forced remaining connections closed:", err)
+		}
+	}()
+
+	log.Printf("Server is listening on %s\n", addr)
+	if err := srv.ListenAndServe(); err != ErrServerClosed {
+		log.Fatalf("Error starting server: %v\n", err)
+	}
+	// Serve returns as soon as the listener closes; wait for the drain.
+	srv.Shutdown(context.Background())
+	log.Println("Server stopped")
+}
+
+// runClient pipelines a batch of requests and prints the replies.
+func runClient(addr string, codec Codec) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := Dial(ctx, addr, codec, 64)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	start := time.Now()
+	calls := make([]*Call, 0, 1000)
+	for range 1000 {
+		calls = append(calls, client.Go("INCR", "counter"))
+	}
+	for _, call := range calls {
+		<-call.Done
+		if call.Err != nil {
+			return call.Err
+		}
+	}
+	log.Printf("1000 pipelined INCRs in %v, counter is now %s\n", time.Since(start), calls[len(calls)-1].Reply[0])
+
+	for _, req := range [][]string{{"PING"}, {"SET", "greeting", "hello"}, {"GET", "greeting"}, {"GET", "missing"}, {"NOPE"}} {
+		reply, err := client.Do(ctx, req[0], req[1:]...)
+		log.Printf("%v -> %q %v\n", req, reply, err)
+	}
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:9090", "address to listen on or connect to")
+	codecName := flag.String("codec", "line", "framing: line, length or resp")
+	maxConns := flag.Int("max-conns", 100, "maximum concurrent connections")
+	idle := flag.Duration("idle", time.Minute, "close connections idle this long")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [serve|client]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	codec, ok := codecs[*codecName]
+	if !ok {
+		log.Fatalf("Unknown codec %q\n", *codecName)
+	}
+	switch flag.Arg(0) {
+	case "", "serve":
+		startTCPServer(*addr, codec, *maxConns, *idle)
+	case "client":
+		if err := runClient(*addr, codec); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
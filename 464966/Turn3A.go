@@ -0,0 +1,788 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// Options says where a Config reads from. Layers are applied in order,
+// each overriding the last: struct defaults, Files (in the order given),
+// environment variables, then Args.
+type Options struct {
+	// Files are read by extension: .yaml/.yml, .json, or .toml/.ini for
+	// the TOML subset. Missing files are skipped.
+	Files []string
+	// EnvPrefix maps key "server.port" to $PREFIX_SERVER_PORT.
+	EnvPrefix string
+	// Args are command-line flags such as -server.port=9000.
+	Args []string
+	// LookupEnv defaults to os.LookupEnv.
+	LookupEnv func(string) (string, bool)
+}
+
+// Config holds the current, validated configuration of type T. Fields of
+// T are mapped to dotted keys by their `config` tag (default: the
+// lower-cased field name), nested structs adding a level. A `default` tag
+// supplies the value used when no layer sets one, and a `validate` tag
+// lists constraints: required, min=N, max=N and oneof=a|b|c. min and max
+// bound numbers and durations by value and strings and slices by length.
+// If *T has a Validate() error method, it runs after the field checks.
+type Config[T any] struct {
+	opts   Options
+	fields []field
+	known  map[string]*field
+
+	reloadMu sync.Mutex // serializes Reload
+	current  atomic.Pointer[snapshot[T]]
+
+	mu          sync.Mutex
+	subscribers map[int]func(old, new *T, changed []string)
+	nextID      int
+}
+
+type snapshot[T any] struct {
+	cfg  *T
+	flat map[string]string // key -> effective value, for Get and diffs
+}
+
+// NewConfig loads and validates the configuration once. It fails rather
+// than start with an invalid one.
+func NewConfig[T any](opts Options) (*Config[T], error) {
+	if opts.LookupEnv == nil {
+		opts.LookupEnv = os.LookupEnv
+	}
+	fields, err := schemaOf(reflect.TypeFor[T](), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config[T]{opts: opts, fields: fields, known: make(map[string]*field), subscribers: make(map[int]func(old, new *T, changed []string))}
+	for i := range c.fields {
+		c.known[c.fields[i].key] = &c.fields[i]
+	}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Current returns the configuration in effect. Callers must not modify it.
+func (c *Config[T]) Current() *T { return c.current.Load().cfg }
+
+// Get returns the effective value of a key as a string.
+func (c *Config[T]) Get(key string) (string, bool) {
+	val, ok := c.current.Load().flat[key]
+	return val, ok
+}
+
+// Subscribe calls fn after every reload that changes at least one key,
+// with the keys that changed, sorted. It returns a function that cancels
+// the subscription.
+func (c *Config[T]) Subscribe(fn func(old, new *T, changed []string)) (cancel func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.nextID
+	c.nextID++
+	c.subscribers[id] = fn
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subscribers, id)
+	}
+}
+
+// Reload re-reads every layer. The new configuration replaces the current
+// one in a single step, and only if it decodes and validates; otherwise
+// the current one stays and the error lists every problem found.
+func (c *Config[T]) Reload() error {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	cfg, flat, err := c.build()
+	if err != nil {
+		return err
+	}
+	old := c.current.Swap(&snapshot[T]{cfg: cfg, flat: flat})
+	if old == nil {
+		return nil
+	}
+	var changed []string
+	for key, val := range flat {
+		if old.flat[key] != val {
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	slices.Sort(changed)
+
+	c.mu.Lock()
+	subscribers := slices.Collect(maps.Values(c.subscribers))
+	c.mu.Unlock()
+	for _, fn := range subscribers {
+		fn(old.cfg, cfg, changed)
+	}
+	return nil
+}
+
+// Watch reloads whenever one of the files changes, until ctx is done.
+// Editors often save by writing a new file and renaming it over the old
+// one, so the files' directories are watched rather than the files. A
+// failed reload is logged and the previous configuration kept.
+func (c *Config[T]) Watch(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	files := make(map[string]bool)
+	for _, f := range c.opts.Files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return err
+		}
+		files[abs] = true
+		if err := w.Add(filepath.Dir(abs)); err != nil {
+			return err
+		}
+	}
+
+	// Saves often arrive as several events; reload once they settle.
+	const settle = 100 * time.Millisecond
+	timer := time.NewTimer(settle)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-w.Events:
+			if abs, _ := filepath.Abs(event.Name); files[abs] {
+				timer.Reset(settle)
+			}
+		case err := <-w.Errors:
+			log.Println("Error watching configuration files:", err)
+		case <-timer.C:
+			if err := c.Reload(); err != nil {
+				log.Printf("Configuration rejected, keeping the previous one:\n%v\n", err)
+			}
+		}
+	}
+}
+
+type layer struct {
+	name   string
+	values map[string]any
+}
+
+// build merges the layers and decodes and validates the result.
+func (c *Config[T]) build() (*T, map[string]string, error) {
+	defaults := make(map[string]any)
+	for _, f := range c.fields {
+		if f.hasDefault {
+			defaults[f.key] = f.def
+		}
+	}
+	layers := []layer{{"defaults", defaults}}
+	for _, path := range c.opts.Files {
+		values, err := readFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		layers = append(layers, layer{path, values})
+	}
+	layers = append(layers, c.envLayer())
+	flags, err := c.flagLayer()
+	if err != nil {
+		return nil, nil, err
+	}
+	layers = append(layers, flags)
+
+	var errs []error
+	merged := make(map[string]any)
+	origin := make(map[string]string)
+	for _, l := range layers {
+		for _, key := range slices.Sorted(maps.Keys(l.values)) {
+			if c.known[key] == nil {
+				errs = append(errs, fmt.Errorf("%s: unknown key %q", l.name, key))
+				continue
+			}
+			merged[key] = l.values[key]
+			origin[key] = l.name
+		}
+	}
+
+	cfg := new(T)
+	root := reflect.ValueOf(cfg).Elem()
+	flat := make(map[string]string, len(c.fields))
+	for _, f := range c.fields {
+		fv := root.FieldByIndex(f.index)
+		raw, set := merged[f.key]
+		if set {
+			if err := decodeValue(fv, raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s (from %s): %w", f.key, origin[f.key], err))
+				continue
+			}
+		}
+		if err := f.check(fv); err != nil {
+			if set {
+				err = fmt.Errorf("%s (from %s): %w", f.key, origin[f.key], err)
+			} else {
+				err = fmt.Errorf("%s: %w", f.key, err)
+			}
+			errs = append(errs, err)
+		}
+		flat[f.key] = formatValue(fv)
+	}
+	if v, ok := any(cfg).(interface{ Validate() error }); ok && len(errs) == 0 {
+		if err := v.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return cfg, flat, nil
+}
+
+func (c *Config[T]) envName(key string) string {
+	name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+	if c.opts.EnvPrefix != "" {
+		name = strings.ToUpper(c.opts.EnvPrefix) + "_" + name
+	}
+	return name
+}
+
+// envLayer looks up a variable for each known key, so keys containing
+// underscores map unambiguously.
+func (c *Config[T]) envLayer() layer {
+	values := make(map[string]any)
+	for _, f := range c.fields {
+		if v, ok := c.opts.LookupEnv(c.envName(f.key)); ok {
+			values[f.key] = v
+		}
+	}
+	return layer{"environment", values}
+}
+
+// flagValue collects one flag's value as a string. Boolean fields get
+// boolean flags, so -debug works without =true.
+type flagValue struct {
+	values map[string]any
+	key    string
+	isBool bool
+}
+
+func (v *flagValue) String() string   { return "" }
+func (v *flagValue) IsBoolFlag() bool { return v.isBool }
+func (v *flagValue) Set(s string) error {
+	v.values[v.key] = s
+	return nil
+}
+
+func (c *Config[T]) flagLayer() (layer, error) {
+	values := make(map[string]any)
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	for _, f := range c.fields {
+		usage := f.typ.String()
+		if f.hasDefault {
+			usage += " (default " + f.def + ")"
+		}
+		fs.Var(&flagValue{values, f.key, f.typ.Kind() == reflect.Bool}, f.key, usage)
+	}
+	if err := fs.Parse(c.opts.Args); err != nil {
+		return layer{}, fmt.Errorf("command line: %w", err)
+	}
+	return layer{"command line", values}, nil
+}
+
+// field is one leaf of the configuration struct.
+type field struct {
+	key        string
+	index      []int
+	typ        reflect.Type
+	def        string
+	hasDefault bool
+	rules      []rule
+}
+
+type rule struct{ name, arg string }
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+func schemaOf(t reflect.Type, prefix string, index []int) ([]field, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("configuration type %s is not a struct", t)
+	}
+	var fields []field
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Tag.Get("config")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		idx := append(slices.Clone(index), i)
+
+		if sf.Type.Kind() == reflect.Struct {
+			nested, err := schemaOf(sf.Type, key, idx)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, nested...)
+			continue
+		}
+		f := field{key: key, index: idx, typ: sf.Type}
+		f.def, f.hasDefault = sf.Tag.Lookup("default")
+		for _, r := range strings.Split(sf.Tag.Get("validate"), ",") {
+			if r = strings.TrimSpace(r); r != "" {
+				name, arg, _ := strings.Cut(r, "=")
+				f.rules = append(f.rules, rule{name, arg})
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// check applies the field's validation rules to its decoded value.
+func (f *field) check(v reflect.Value) error {
+	for _, r := range f.rules {
+		switch r.name {
+		case "required":
+			if v.IsZero() {
+				return errors.New("is required")
+			}
+		case "min", "max":
+			got, limit, err := measure(v, r.arg)
+			if err != nil {
+				return fmt.Errorf("bad %s rule: %w", r.name, err)
+			}
+			if r.name == "min" && got < limit {
+				return fmt.Errorf("must be at least %s, got %s", r.arg, formatValue(v))
+			}
+			if r.name == "max" && got > limit {
+				return fmt.Errorf("must be at most %s, got %s", r.arg, formatValue(v))
+			}
+		case "oneof":
+			options := strings.Split(r.arg, "|")
+			if !slices.Contains(options, formatValue(v)) {
+				return fmt.Errorf("must be one of %s, got %q", strings.Join(options, ", "), formatValue(v))
+			}
+		default:
+			return fmt.Errorf("unknown validation rule %q", r.name)
+		}
+	}
+	return nil
+}
+
+// measure returns the quantity min and max compare: the value of numbers
+// and durations, the length of strings and slices.
+func measure(v reflect.Value, arg string) (got, limit float64, err error) {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(arg)
+		return float64(v.Int()), float64(d), err
+	case v.Kind() == reflect.String || v.Kind() == reflect.Slice:
+		got = float64(v.Len())
+	case v.CanInt():
+		got = float64(v.Int())
+	case v.CanUint():
+		got = float64(v.Uint())
+	case v.CanFloat():
+		got = v.Float()
+	default:
+		return 0, 0, fmt.Errorf("not applicable to %s", v.Type())
+	}
+	limit, err = strconv.ParseFloat(arg, 64)
+	return got, limit, err
+}
+
+// decodeValue sets v from a raw layer value: a string from defaults, env
+// or flags, or whatever type a file produced.
+func decodeValue(v reflect.Value, raw any) error {
+	if v.Kind() == reflect.Slice {
+		var items []any
+		switch r := raw.(type) {
+		case []any:
+			items = r
+		case string:
+			for _, s := range strings.Split(r, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					items = append(items, s)
+				}
+			}
+		default:
+			items = []any{r}
+		}
+		out := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeValue(out.Index(i), item); err != nil {
+				return fmt.Errorf("item %d: %w", i, err)
+			}
+		}
+		v.Set(out)
+		return nil
+	}
+
+	switch r := raw.(type) {
+	case []any, map[string]any:
+		return fmt.Errorf("expected a single %s, got %T", v.Type(), raw)
+	case float64:
+		// JSON numbers; refuse to truncate into integer fields.
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 && r != float64(int64(r)) {
+			return fmt.Errorf("%v is not an integer", r)
+		}
+		if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
+			raw = int64(r)
+		}
+	}
+	s := fmt.Sprint(raw)
+
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.CanInt():
+		n, err := strconv.ParseInt(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case v.CanUint():
+		n, err := strconv.ParseUint(s, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case v.CanFloat():
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.Slice {
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatValue(v.Index(i))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// readFile parses a configuration file into flat, lower-cased dotted keys.
+func readFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tree := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".json":
+		err = json.Unmarshal(data, &tree)
+	case ".toml", ".ini":
+		tree, err = parseTOML(string(data))
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	flat := make(map[string]any)
+	flatten("", tree, flat)
+	return flat, nil
+}
+
+func flatten(prefix string, tree map[string]any, out map[string]any) {
+	for k, v := range tree {
+		key := strings.ToLower(k)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if sub, ok := v.(map[string]any); ok {
+			flatten(key, sub, out)
+			continue
+		}
+		out[key] = v
+	}
+}
+
+// parseTOML reads the subset of TOML that configuration files need:
+// [tables] and [dotted.tables], key = value with bare or dotted keys, and
+// values that are strings ("basic" or 'literal'), integers, floats,
+// booleans or single-line arrays of those. Comments start with #.
+func parseTOML(src string) (map[string]any, error) {
+	root := make(map[string]any)
+	table := root
+	for n, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" {
+			continue
+		}
+		fail := func(format string, args ...any) (map[string]any, error) {
+			return nil, fmt.Errorf("line %d: %s", n+1, fmt.Sprintf(format, args...))
+		}
+
+		if strings.HasPrefix(line, "[") {
+			name, ok := strings.CutSuffix(strings.TrimPrefix(line, "["), "]")
+			if !ok || strings.TrimSpace(name) == "" {
+				return fail("bad table header %q", line)
+			}
+			t, err := subTable(root, strings.Split(strings.TrimSpace(name), "."))
+			if err != nil {
+				return fail("%v", err)
+			}
+			table = t
+			continue
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return fail("expected key = value, got %q", line)
+		}
+		path := strings.Split(strings.TrimSpace(key), ".")
+		for i := range path {
+			path[i] = strings.TrimSpace(path[i])
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return fail("%s: %v", strings.TrimSpace(key), err)
+		}
+		t, err := subTable(table, path[:len(path)-1])
+		if err != nil {
+			return fail("%v", err)
+		}
+		t[path[len(path)-1]] = value
+	}
+	return root, nil
+}
+
+func subTable(t map[string]any, path []string) (map[string]any, error) {
+	for _, name := range path {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, errors.New("empty key")
+		}
+		next, ok := t[name]
+		if !ok {
+			next = make(map[string]any)
+			t[name] = next
+		}
+		sub, ok := next.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%q is a value, not a table", name)
+		}
+		t = sub
+	}
+	return t, nil
+}
+
+// stripComment removes a # comment that is not inside a string.
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func parseTOMLValue(s string) (any, error) {
+	switch {
+	case s == "":
+		return nil, errors.New("missing value")
+	case s == "true" || s == "false":
+		return s == "true", nil
+	case strings.HasPrefix(s, `"`):
+		if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : len(s)-1], nil
+	case strings.HasPrefix(s, "["):
+		inner, ok := strings.CutSuffix(s[1:], "]")
+		if !ok {
+			return nil, errors.New("arrays must fit on one line")
+		}
+		var items []any
+		for _, item := range splitArray(inner) {
+			if item = strings.TrimSpace(item); item == "" {
+				continue // trailing comma
+			}
+			v, err := parseTOMLValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	}
+	clean := strings.ReplaceAll(s, "_", "")
+	if n, err := strconv.ParseInt(clean, 0, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(clean, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("cannot parse value %q (strings must be quoted)", s)
+}
+
+// splitArray splits on commas outside quotes.
+func splitArray(s string) []string {
+	var parts []string
+	var quote rune
+	start := 0
+	escaped := false
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == ',':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// AppConfig is this service's configuration.
+type AppConfig struct {
+	Server struct {
+		Host        string        `config:"host" default:"localhost" validate:"required"`
+		Port        int           `config:"port" default:"8080" validate:"min=1,max=65535"`
+		ReadTimeout time.Duration `config:"read_timeout" default:"5s" validate:"min=1ms,max=5m"`
+	} `config:"server"`
+	Log struct {
+		Level string `config:"level" default:"info" validate:"oneof=debug|info|warn|error"`
+	} `config:"log"`
+	Database struct {
+		URL      string `config:"url" validate:"required"`
+		MaxConns int    `config:"max_conns" default:"10" validate:"min=1"`
+	} `config:"database"`
+	Features []string `config:"features"`
+	Debug    bool     `config:"debug"`
+}
+
+// Validate checks constraints that span fields.
+func (c *AppConfig) Validate() error {
+	if c.Debug && c.Log.Level != "debug" {
+		return errors.New("debug: requires log.level = debug")
+	}
+	return nil
+}
+
+func main() {
+	dir, err := os.MkdirTemp("", "config-demo")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	yamlPath := filepath.Join(dir, "config.yaml")
+	tomlPath := filepath.Join(dir, "local.toml")
+	os.WriteFile(yamlPath, []byte("server:\n  port: 9000\nlog:\n  level: warn\ndatabase:\n  url: postgres://localhost/app\nfeatures: [search, export]\n"), 0o644)
+	os.WriteFile(tomlPath, []byte("# local overrides\n[server]\nread_timeout = \"10s\"\n"), 0o644)
+	os.Setenv("APP_DATABASE_MAX_CONNS", "25")
+
+	config, err := NewConfig[AppConfig](Options{
+		Files:     []string{yamlPath, tomlPath, "config.ini"},
+		EnvPrefix: "APP",
+		Args:      os.Args[1:],
+	})
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	cfg := config.Current()
+	fmt.Printf("Loaded: %s:%d, timeout %v, log %s, %d db conns, features %v\n",
+		cfg.Server.Host, cfg.Server.Port, cfg.Server.ReadTimeout, cfg.Log.Level, cfg.Database.MaxConns, cfg.Features)
+
+	changes := make(chan []string, 1)
+	config.Subscribe(func(old, new *AppConfig, changed []string) {
+		changes <- changed
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go config.Watch(ctx)
+	time.Sleep(100 * time.Millisecond) // let the watch start
+
+	os.WriteFile(yamlPath, []byte("server:\n  port: 9001\nlog:\n  level: debug\ndatabase:\n  url: postgres://localhost/app\nfeatures: [search, export]\n"), 0o644)
+	fmt.Println("Changed keys:", <-changes)
+	level, _ := config.Get("log.level")
+	fmt.Println("log.level is now", level)
+
+	// An invalid edit is rejected and the previous configuration stays.
+	os.WriteFile(yamlPath, []byte("server:\n  port: 70000\nlog:\n  level: loud\ndatabase:\n  url: postgres://localhost/app\n"), 0o644)
+	time.Sleep(300 * time.Millisecond)
+	fmt.Println("Port after invalid edit:", config.Current().Server.Port)
+}
@@ -0,0 +1,803 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileManager interface for file operations. Names are slash-separated
+// paths relative to the manager's root, as accepted by fs.ValidPath, so
+// the same names work with every backend. Errors are *fs.PathError and
+// match fs.ErrNotExist, fs.ErrInvalid, ErrReadOnly and so on with
+// errors.Is.
+type FileManager interface {
+	// Open starts a new version of a file. Reads from the handle see the
+	// content the file had when it was opened; writes build the
+	// replacement, which becomes visible all at once on Close. A handle
+	// is not safe for concurrent use.
+	Open(name string) (io.ReadWriter, error)
+	// Close commits a handle returned by Open. If a write to it failed,
+	// Close discards the new version and returns the error.
+	Close(io.ReadWriter) error
+	Write(io.ReadWriter, []byte) error
+
+	// Read returns the whole content of a file.
+	Read(name string) ([]byte, error)
+	Stat(name string) (fs.FileInfo, error)
+	// List returns the entries of a directory, sorted by name. The root
+	// is ".".
+	List(dir string) ([]fs.DirEntry, error)
+	// Rename moves a file or directory, creating missing parents of the
+	// new name and replacing a file already there.
+	Rename(oldname, newname string) error
+	// Remove deletes a file or an empty directory.
+	Remove(name string) error
+}
+
+var (
+	// ErrReadOnly is returned by modifying operations on read-only
+	// backends. It matches fs.ErrPermission.
+	ErrReadOnly = fmt.Errorf("read-only file system: %w", fs.ErrPermission)
+	ErrIsDir    = errors.New("is a directory")
+	ErrNotDir   = errors.New("not a directory")
+	ErrNotEmpty = errors.New("directory not empty")
+)
+
+func checkName(op, name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+// write is the Write method shared by every backend.
+func write(rw io.ReadWriter, data []byte) error {
+	_, err := rw.Write(data)
+	return err
+}
+
+func foreignHandle(rw io.ReadWriter) error {
+	return fmt.Errorf("cannot close %T: not opened by this FileManager", rw)
+}
+
+// OSFileManager stores files under a root directory. Commits write a
+// temporary file next to the target, fsync it, rename it into place and
+// fsync the directory, so after a crash a file holds either its old or
+// its new content, never a mix.
+type OSFileManager struct {
+	root string
+}
+
+// Temporary files carry this prefix and are left out of List.
+const tempPrefix = ".fm-tmp-"
+
+func NewOSFileManager(root string) *OSFileManager {
+	return &OSFileManager{root: root}
+}
+
+func (m *OSFileManager) path(op, name string) (string, error) {
+	if err := checkName(op, name); err != nil {
+		return "", err
+	}
+	return filepath.Join(m.root, filepath.FromSlash(name)), nil
+}
+
+// relative reports errors by the caller's name rather than the full path,
+// so the root does not leak into messages.
+func relative(err error, op, name string) error {
+	var pe *fs.PathError
+	var le *os.LinkError
+	switch {
+	case errors.As(err, &pe):
+		return &fs.PathError{Op: op, Path: name, Err: pe.Err}
+	case errors.As(err, &le):
+		return &fs.PathError{Op: op, Path: name, Err: le.Err}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+type osHandle struct {
+	m      *OSFileManager
+	name   string
+	path   string
+	src    *os.File // current content, nil for a new file
+	tmp    *os.File
+	perm   fs.FileMode
+	err    error // first write error
+	closed bool
+}
+
+func (h *osHandle) Read(p []byte) (int, error) {
+	if h.closed {
+		return 0, &fs.PathError{Op: "read", Path: h.name, Err: fs.ErrClosed}
+	}
+	if h.src == nil {
+		return 0, io.EOF
+	}
+	return h.src.Read(p)
+}
+
+func (h *osHandle) Write(p []byte) (int, error) {
+	if h.closed {
+		return 0, &fs.PathError{Op: "write", Path: h.name, Err: fs.ErrClosed}
+	}
+	n, err := h.tmp.Write(p)
+	if err != nil && h.err == nil {
+		h.err = relative(err, "write", h.name)
+	}
+	return n, err
+}
+
+func (m *OSFileManager) Open(name string) (io.ReadWriter, error) {
+	p, err := m.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	h := &osHandle{m: m, name: name, path: p, perm: 0o644}
+	src, err := os.Open(p)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, relative(err, "open", name)
+	default:
+		info, err := src.Stat()
+		if err == nil && info.IsDir() {
+			err = ErrIsDir
+		}
+		if err != nil {
+			src.Close()
+			return nil, relative(err, "open", name)
+		}
+		h.src, h.perm = src, info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		h.closeSource()
+		return nil, relative(err, "open", name)
+	}
+	if h.tmp, err = os.CreateTemp(dir, tempPrefix+filepath.Base(p)+"-*"); err != nil {
+		h.closeSource()
+		return nil, relative(err, "open", name)
+	}
+	return h, nil
+}
+
+func (h *osHandle) closeSource() {
+	if h.src != nil {
+		h.src.Close()
+	}
+}
+
+func (m *OSFileManager) Close(rw io.ReadWriter) error {
+	h, ok := rw.(*osHandle)
+	if !ok || h.m != m {
+		return foreignHandle(rw)
+	}
+	if h.closed {
+		return &fs.PathError{Op: "close", Path: h.name, Err: fs.ErrClosed}
+	}
+	h.closed = true
+	h.closeSource()
+
+	tmp := h.tmp.Name()
+	err := h.err
+	if err == nil {
+		err = h.tmp.Chmod(h.perm)
+	}
+	if err == nil {
+		err = h.tmp.Sync()
+	}
+	if cerr := h.tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, h.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return relative(err, "close", h.name)
+	}
+	if err := syncDir(filepath.Dir(h.path)); err != nil {
+		return relative(err, "close", h.name)
+	}
+	return nil
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (m *OSFileManager) Write(rw io.ReadWriter, data []byte) error {
+	return write(rw, data)
+}
+
+func (m *OSFileManager) Read(name string) ([]byte, error) {
+	p, err := m.path("read", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, relative(err, "read", name)
+	}
+	return data, nil
+}
+
+func (m *OSFileManager) Stat(name string) (fs.FileInfo, error) {
+	p, err := m.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, relative(err, "stat", name)
+	}
+	return info, nil
+}
+
+func (m *OSFileManager) List(dir string) ([]fs.DirEntry, error) {
+	p, err := m.path("list", dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, relative(err, "list", dir)
+	}
+	return slices.DeleteFunc(entries, func(e fs.DirEntry) bool {
+		return strings.HasPrefix(e.Name(), tempPrefix)
+	}), nil
+}
+
+func (m *OSFileManager) Rename(oldname, newname string) error {
+	oldp, err := m.path("rename", oldname)
+	if err != nil {
+		return err
+	}
+	newp, err := m.path("rename", newname)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(oldp); err != nil {
+		return relative(err, "rename", oldname)
+	}
+	if err := os.MkdirAll(filepath.Dir(newp), 0o755); err != nil {
+		return relative(err, "rename", newname)
+	}
+	if err := os.Rename(oldp, newp); err != nil {
+		return relative(err, "rename", oldname)
+	}
+	if err := syncDir(filepath.Dir(newp)); err != nil {
+		return relative(err, "rename", newname)
+	}
+	return nil
+}
+
+func (m *OSFileManager) Remove(name string) error {
+	p, err := m.path("remove", name)
+	if err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	if err := os.Remove(p); err != nil {
+		return relative(err, "remove", name)
+	}
+	return nil
+}
+
+// MemFileManager keeps files in memory, for tests. It behaves like
+// OSFileManager, down to directories outliving their last file.
+type MemFileManager struct {
+	mu    sync.RWMutex
+	files map[string]memFile
+	dirs  map[string]time.Time // always holds "."
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemFileManager() *MemFileManager {
+	return &MemFileManager{
+		files: make(map[string]memFile),
+		dirs:  map[string]time.Time{".": time.Now()},
+	}
+}
+
+// memInfo implements fs.FileInfo for MemFileManager entries.
+type memInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.dir }
+func (i memInfo) Sys() any           { return nil }
+func (i memInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// stat must be called with m.mu held.
+func (m *MemFileManager) stat(name string) (memInfo, bool) {
+	if f, ok := m.files[name]; ok {
+		return memInfo{path.Base(name), int64(len(f.data)), f.modTime, false}, true
+	}
+	if t, ok := m.dirs[name]; ok {
+		return memInfo{path.Base(name), 0, t, true}, true
+	}
+	return memInfo{}, false
+}
+
+// mkdirAll creates the parents of name. It must be called with m.mu held.
+func (m *MemFileManager) mkdirAll(name string) error {
+	var missing []string
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return ErrNotDir
+		}
+		if _, ok := m.dirs[dir]; ok {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	now := time.Now()
+	for _, dir := range missing {
+		m.dirs[dir] = now
+	}
+	return nil
+}
+
+type memHandle struct {
+	m      *MemFileManager
+	name   string
+	old    *bytes.Reader
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (h *memHandle) Read(p []byte) (int, error) {
+	if h.closed {
+		return 0, &fs.PathError{Op: "read", Path: h.name, Err: fs.ErrClosed}
+	}
+	return h.old.Read(p)
+}
+
+func (h *memHandle) Write(p []byte) (int, error) {
+	if h.closed {
+		return 0, &fs.PathError{Op: "write", Path: h.name, Err: fs.ErrClosed}
+	}
+	return h.buf.Write(p)
+}
+
+func (m *MemFileManager) Open(name string) (io.ReadWriter, error) {
+	if err := checkName("open", name); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.dirs[name]; ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrIsDir}
+	}
+	// Like a copy-on-write snapshot: commits replace the slice, never
+	// modify it, so the handle can read it without the lock.
+	return &memHandle{m: m, name: name, old: bytes.NewReader(m.files[name].data)}, nil
+}
+
+func (m *MemFileManager) Close(rw io.ReadWriter) error {
+	h, ok := rw.(*memHandle)
+	if !ok || h.m != m {
+		return foreignHandle(rw)
+	}
+	if h.closed {
+		return &fs.PathError{Op: "close", Path: h.name, Err: fs.ErrClosed}
+	}
+	h.closed = true
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.dirs[h.name]; ok {
+		return &fs.PathError{Op: "close", Path: h.name, Err: ErrIsDir}
+	}
+	if err := m.mkdirAll(h.name); err != nil {
+		return &fs.PathError{Op: "close", Path: h.name, Err: err}
+	}
+	m.files[h.name] = memFile{data: bytes.Clone(h.buf.Bytes()), modTime: time.Now()}
+	return nil
+}
+
+func (m *MemFileManager) Write(rw io.ReadWriter, data []byte) error {
+	return write(rw, data)
+}
+
+func (m *MemFileManager) Read(name string) ([]byte, error) {
+	if err := checkName("read", name); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if f, ok := m.files[name]; ok {
+		return bytes.Clone(f.data), nil
+	}
+	if _, ok := m.dirs[name]; ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: ErrIsDir}
+	}
+	return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFileManager) Stat(name string) (fs.FileInfo, error) {
+	if err := checkName("stat", name); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	info, ok := m.stat(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return info, nil
+}
+
+func (m *MemFileManager) List(dir string) ([]fs.DirEntry, error) {
+	if err := checkName("list", dir); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.dirs[dir]; !ok {
+		err := fs.ErrNotExist
+		if _, ok := m.files[dir]; ok {
+			err = ErrNotDir
+		}
+		return nil, &fs.PathError{Op: "list", Path: dir, Err: err}
+	}
+	var entries []fs.DirEntry
+	add := func(name string) {
+		if name != "." && path.Dir(name) == dir {
+			info, _ := m.stat(name)
+			entries = append(entries, fs.FileInfoToDirEntry(info))
+		}
+	}
+	for name := range m.files {
+		add(name)
+	}
+	for name := range m.dirs {
+		add(name)
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+// within reports whether name is dir or lies below it.
+func within(name, dir string) bool {
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+func (m *MemFileManager) Rename(oldname, newname string) error {
+	if err := checkName("rename", oldname); err != nil {
+		return err
+	}
+	if err := checkName("rename", newname); err != nil {
+		return err
+	}
+	fail := func(name string, err error) error {
+		return &fs.PathError{Op: "rename", Path: name, Err: err}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.stat(oldname)
+	switch {
+	case !ok:
+		return fail(oldname, fs.ErrNotExist)
+	case oldname == ".":
+		return fail(oldname, fs.ErrInvalid)
+	case oldname == newname:
+		return nil
+	case old.dir && within(newname, oldname):
+		return fail(newname, fs.ErrInvalid) // moving a directory into itself
+	}
+	if target, ok := m.stat(newname); ok && (target.dir || old.dir) {
+		// A directory may only replace an empty directory, and a file
+		// never replaces a directory.
+		if !target.dir || !old.dir || m.hasChildren(newname) {
+			return fail(newname, fs.ErrExist)
+		}
+		delete(m.dirs, newname)
+	}
+	if err := m.mkdirAll(newname); err != nil {
+		return fail(newname, err)
+	}
+
+	if !old.dir {
+		m.files[newname] = m.files[oldname]
+		delete(m.files, oldname)
+		return nil
+	}
+	moved := func(name string) string { return newname + strings.TrimPrefix(name, oldname) }
+	for name, f := range m.files {
+		if within(name, oldname) {
+			delete(m.files, name)
+			m.files[moved(name)] = f
+		}
+	}
+	for name, t := range m.dirs {
+		if within(name, oldname) {
+			delete(m.dirs, name)
+			m.dirs[moved(name)] = t
+		}
+	}
+	return nil
+}
+
+// hasChildren must be called with m.mu held.
+func (m *MemFileManager) hasChildren(dir string) bool {
+	for name := range m.files {
+		if within(name, dir) {
+			return true
+		}
+	}
+	for name := range m.dirs {
+		if name != dir && within(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemFileManager) Remove(name string) error {
+	if err := checkName("remove", name); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	var err error
+	switch _, ok := m.dirs[name]; {
+	case name == ".":
+		err = fs.ErrInvalid
+	case !ok:
+		err = fs.ErrNotExist
+	case m.hasChildren(name):
+		err = ErrNotEmpty
+	default:
+		delete(m.dirs, name)
+		return nil
+	}
+	return &fs.PathError{Op: "remove", Path: name, Err: err}
+}
+
+// ZipFileManager serves the contents of a zip archive. It is read-only:
+// Open, Rename and Remove fail with ErrReadOnly.
+type ZipFileManager struct {
+	fsys fs.FS
+}
+
+// NewZipFileManager wraps r, which must stay open while the manager is
+// in use.
+func NewZipFileManager(r *zip.Reader) *ZipFileManager {
+	return &ZipFileManager{fsys: r}
+}
+
+func (m *ZipFileManager) Open(name string) (io.ReadWriter, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: ErrReadOnly}
+}
+
+func (m *ZipFileManager) Close(rw io.ReadWriter) error {
+	return foreignHandle(rw)
+}
+
+func (m *ZipFileManager) Write(rw io.ReadWriter, data []byte) error {
+	return write(rw, data)
+}
+
+func (m *ZipFileManager) Read(name string) ([]byte, error) {
+	info, err := m.Stat(name)
+	if err != nil {
+		return nil, relative(err, "read", name)
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: ErrIsDir}
+	}
+	data, err := fs.ReadFile(m.fsys, name)
+	if err != nil {
+		return nil, relative(err, "read", name)
+	}
+	return data, nil
+}
+
+func (m *ZipFileManager) Stat(name string) (fs.FileInfo, error) {
+	if err := checkName("stat", name); err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(m.fsys, name)
+	if err != nil {
+		return nil, relative(err, "stat", name)
+	}
+	return info, nil
+}
+
+func (m *ZipFileManager) List(dir string) ([]fs.DirEntry, error) {
+	if err := checkName("list", dir); err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(m.fsys, dir)
+	if err == nil && !info.IsDir() {
+		err = ErrNotDir
+	}
+	if err != nil {
+		return nil, relative(err, "list", dir)
+	}
+	entries, err := fs.ReadDir(m.fsys, dir)
+	if err != nil {
+		return nil, relative(err, "list", dir)
+	}
+	return entries, nil
+}
+
+func (m *ZipFileManager) Rename(oldname, newname string) error {
+	return &fs.PathError{Op: "rename", Path: oldname, Err: ErrReadOnly}
+}
+
+func (m *ZipFileManager) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+}
+
+// writeFile replaces a file's content through fm.
+func writeFile(fm FileManager, name string, data []byte) error {
+	f, err := fm.Open(name)
+	if err != nil {
+		return err
+	}
+	if err := fm.Write(f, data); err != nil {
+		fm.Close(f)
+		return err
+	}
+	return fm.Close(f)
+}
+
+// archive copies every file fm holds into a zip archive.
+func archive(fm FileManager, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := fm.List(dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			name := path.Join(dir, e.Name())
+			if e.IsDir() {
+				if err := walk(name); err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := fm.Read(name)
+			if err != nil {
+				return err
+			}
+			fw, err := zw.Create(name)
+			if err != nil {
+				return err
+			}
+			if _, err := fw.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("."); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func printTree(label string, fm FileManager) {
+	fmt.Println(label + ":")
+	var walk func(dir, indent string)
+	walk = func(dir, indent string) {
+		entries, err := fm.List(dir)
+		if err != nil {
+			fmt.Println(indent+"error:", err)
+			return
+		}
+		for _, e := range entries {
+			name := path.Join(dir, e.Name())
+			if e.IsDir() {
+				fmt.Printf("%s%s/\n", indent, e.Name())
+				walk(name, indent+"  ")
+				continue
+			}
+			data, _ := fm.Read(name)
+			fmt.Printf("%s%s: %q\n", indent, e.Name(), data)
+		}
+	}
+	walk(".", "  ")
+}
+
+func main() {
+	root, err := os.MkdirTemp("", "filemanager")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(root)
+
+	disk := NewOSFileManager(root)
+	mem := NewMemFileManager()
+	for _, fm := range []FileManager{disk, mem} {
+		if err := writeFile(fm, "example.txt", []byte("Hello, World!")); err != nil {
+			panic(err)
+		}
+		if err := writeFile(fm, "notes/todo.txt", []byte("write tests")); err != nil {
+			panic(err)
+		}
+	}
+
+	// Nothing is visible until Close, and the handle reads the old content.
+	f, err := disk.Open("example.txt")
+	if err != nil {
+		panic(err)
+	}
+	disk.Write(f, []byte("Hello again!"))
+	during, _ := disk.Read("example.txt")
+	old, _ := io.ReadAll(f)
+	if err := disk.Close(f); err != nil {
+		panic(err)
+	}
+	after, _ := disk.Read("example.txt")
+	fmt.Printf("while open: %q, handle reads %q, after close: %q\n", during, old, after)
+
+	if err := mem.Rename("notes/todo.txt", "archive/2025/todo.txt"); err != nil {
+		panic(err)
+	}
+	if err := mem.Remove("notes"); err != nil {
+		panic(err)
+	}
+	printTree("disk", disk)
+	printTree("memory", mem)
+
+	var buf bytes.Buffer
+	if err := archive(disk, &buf); err != nil {
+		panic(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		panic(err)
+	}
+	zipped := NewZipFileManager(zr)
+	printTree("zip", zipped)
+	if err := zipped.Remove("example.txt"); errors.Is(err, fs.ErrPermission) {
+		fmt.Println("zip:", err)
+	}
+}
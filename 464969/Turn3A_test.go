@@ -0,0 +1,365 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// seed is the tree every backend starts with.
+var seed = map[string]string{
+	"a.txt":       "alpha",
+	"dir/b.txt":   "bravo",
+	"dir/sub/c":   "charlie",
+	"empty.txt":   "",
+	"other/d.txt": "delta",
+}
+
+type backend struct {
+	name     string
+	readOnly bool
+	new      func(t *testing.T, files map[string]string) FileManager
+}
+
+var backends = []backend{
+	{"os", false, func(t *testing.T, files map[string]string) FileManager {
+		return seeded(t, NewOSFileManager(t.TempDir()), files)
+	}},
+	{"memory", false, func(t *testing.T, files map[string]string) FileManager {
+		return seeded(t, NewMemFileManager(), files)
+	}},
+	{"zip", true, func(t *testing.T, files map[string]string) FileManager {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, name := range slices.Sorted(maps.Keys(files)) {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, files[name])
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewZipFileManager(zr)
+	}},
+}
+
+func seeded(t *testing.T, fm FileManager, files map[string]string) FileManager {
+	t.Helper()
+	for name, data := range files {
+		if err := writeFile(fm, name, []byte(data)); err != nil {
+			t.Fatalf("seeding %s: %v", name, err)
+		}
+	}
+	return fm
+}
+
+// forEach runs a conformance case against every backend, skipping
+// writable-only cases for read-only ones.
+func forEach(t *testing.T, writable bool, test func(t *testing.T, fm FileManager)) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			if writable && b.readOnly {
+				t.Skip("read-only backend")
+			}
+			test(t, b.new(t, seed))
+		})
+	}
+}
+
+func mustRead(t *testing.T, fm FileManager, name string) string {
+	t.Helper()
+	data, err := fm.Read(name)
+	if err != nil {
+		t.Fatalf("Read(%q): %v", name, err)
+	}
+	return string(data)
+}
+
+func wantErr(t *testing.T, what string, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Errorf("%s: got error %v, want %v", what, err, target)
+	}
+	var pe *fs.PathError
+	if err != nil && !errors.As(err, &pe) {
+		t.Errorf("%s: error %T is not a *fs.PathError", what, err)
+	}
+}
+
+func names(t *testing.T, fm FileManager, dir string) []string {
+	t.Helper()
+	entries, err := fm.List(dir)
+	if err != nil {
+		t.Fatalf("List(%q): %v", dir, err)
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+func TestRead(t *testing.T) {
+	forEach(t, false, func(t *testing.T, fm FileManager) {
+		for name, want := range seed {
+			if got := mustRead(t, fm, name); got != want {
+				t.Errorf("Read(%q) = %q, want %q", name, got, want)
+			}
+		}
+		_, err := fm.Read("missing.txt")
+		wantErr(t, "missing file", err, fs.ErrNotExist)
+		_, err = fm.Read("../a.txt")
+		wantErr(t, "path outside root", err, fs.ErrInvalid)
+		_, err = fm.Read("/a.txt")
+		wantErr(t, "absolute path", err, fs.ErrInvalid)
+		if _, err := fm.Read("dir"); err == nil {
+			t.Error("reading a directory succeeded")
+		}
+	})
+}
+
+func TestStat(t *testing.T) {
+	forEach(t, false, func(t *testing.T, fm FileManager) {
+		info, err := fm.Stat("dir/b.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name() != "b.txt" || info.Size() != 5 || info.IsDir() || !info.Mode().IsRegular() {
+			t.Errorf("Stat(dir/b.txt) = %s %d %v", info.Name(), info.Size(), info.Mode())
+		}
+		info, err = fm.Stat("dir/sub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Name() != "sub" || !info.IsDir() {
+			t.Errorf("Stat(dir/sub) = %s dir=%v", info.Name(), info.IsDir())
+		}
+		_, err = fm.Stat("dir/missing")
+		wantErr(t, "missing file", err, fs.ErrNotExist)
+	})
+}
+
+func TestList(t *testing.T) {
+	forEach(t, false, func(t *testing.T, fm FileManager) {
+		if got, want := names(t, fm, "."), []string{"a.txt", "dir/", "empty.txt", "other/"}; !slices.Equal(got, want) {
+			t.Errorf("List(.) = %v, want %v", got, want)
+		}
+		if got, want := names(t, fm, "dir"), []string{"b.txt", "sub/"}; !slices.Equal(got, want) {
+			t.Errorf("List(dir) = %v, want %v", got, want)
+		}
+		_, err := fm.List("nowhere")
+		wantErr(t, "missing directory", err, fs.ErrNotExist)
+		if _, err := fm.List("a.txt"); err == nil {
+			t.Error("listing a file succeeded")
+		}
+	})
+}
+
+func TestWriteIsAtomic(t *testing.T) {
+	forEach(t, true, func(t *testing.T, fm FileManager) {
+		f, err := fm.Open("a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fm.Write(f, []byte("new ")); err != nil {
+			t.Fatal(err)
+		}
+		if err := fm.Write(f, []byte("content")); err != nil {
+			t.Fatal(err)
+		}
+		if got := mustRead(t, fm, "a.txt"); got != "alpha" {
+			t.Errorf("before Close, Read = %q, want the old content", got)
+		}
+		if got := names(t, fm, "."); len(got) != 4 {
+			t.Errorf("before Close, List(.) = %v, want no temporary files", got)
+		}
+		old, err := io.ReadAll(f)
+		if err != nil || string(old) != "alpha" {
+			t.Errorf("handle read %q, %v; want the old content", old, err)
+		}
+		if err := fm.Close(f); err != nil {
+			t.Fatal(err)
+		}
+		if got := mustRead(t, fm, "a.txt"); got != "new content" {
+			t.Errorf("after Close, Read = %q", got)
+		}
+		wantErr(t, "second Close", fm.Close(f), fs.ErrClosed)
+		if _, err := f.Write([]byte("late")); !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("write after Close: %v", err)
+		}
+	})
+}
+
+func TestOpenNewFile(t *testing.T) {
+	forEach(t, true, func(t *testing.T, fm FileManager) {
+		f, err := fm.Open("new/deep/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := io.ReadAll(f); len(data) != 0 {
+			t.Errorf("new file handle read %q", data)
+		}
+		fm.Write(f, []byte("created"))
+		if _, err := fm.Stat("new/deep/file.txt"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("file visible before Close: %v", err)
+		}
+		if err := fm.Close(f); err != nil {
+			t.Fatal(err)
+		}
+		if got := mustRead(t, fm, "new/deep/file.txt"); got != "created" {
+			t.Errorf("Read = %q", got)
+		}
+		if got := names(t, fm, "new"); !slices.Equal(got, []string{"deep/"}) {
+			t.Errorf("List(new) = %v", got)
+		}
+
+		if _, err := fm.Open("dir"); err == nil {
+			t.Error("opening a directory succeeded")
+		}
+		if err := writeFile(fm, "a.txt/x", nil); err == nil {
+			t.Error("writing below a file succeeded")
+		}
+		if err := NewMemFileManager().Close(f); err == nil {
+			t.Error("closing another manager's handle succeeded")
+		}
+	})
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	forEach(t, true, func(t *testing.T, fm FileManager) {
+		const writers = 8
+		payload := func(i int) string { return fmt.Sprintf("%d:%s", i, bytes.Repeat([]byte{byte('a' + i)}, 64<<10)) }
+		var wg sync.WaitGroup
+		for i := range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := writeFile(fm, "shared", []byte(payload(i))); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		got := mustRead(t, fm, "shared")
+		for i := range writers {
+			if got == payload(i) {
+				return
+			}
+		}
+		t.Errorf("content is not any single writer's payload (%d bytes)", len(got))
+	})
+}
+
+func TestRename(t *testing.T) {
+	forEach(t, true, func(t *testing.T, fm FileManager) {
+		if err := fm.Rename("a.txt", "moved/a.txt"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := fm.Read("a.txt")
+		wantErr(t, "old name", err, fs.ErrNotExist)
+		if got := mustRead(t, fm, "moved/a.txt"); got != "alpha" {
+			t.Errorf("Read(moved/a.txt) = %q", got)
+		}
+
+		if err := fm.Rename("dir/b.txt", "other/d.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if got := mustRead(t, fm, "other/d.txt"); got != "bravo" {
+			t.Errorf("replaced file reads %q", got)
+		}
+
+		if err := fm.Rename("dir", "renamed"); err != nil {
+			t.Fatal(err)
+		}
+		if got := mustRead(t, fm, "renamed/sub/c"); got != "charlie" {
+			t.Errorf("Read(renamed/sub/c) = %q", got)
+		}
+		if err := fm.Rename("renamed", "renamed/sub/inside"); err == nil {
+			t.Error("moving a directory into itself succeeded")
+		}
+		if err := fm.Rename("empty.txt", "renamed"); err == nil {
+			t.Error("replacing a directory with a file succeeded")
+		}
+		wantErr(t, "missing source", fm.Rename("missing", "x"), fs.ErrNotExist)
+	})
+}
+
+func TestRemove(t *testing.T) {
+	forEach(t, true, func(t *testing.T, fm FileManager) {
+		if err := fm.Remove("dir/sub/c"); err != nil {
+			t.Fatal(err)
+		}
+		if got := names(t, fm, "dir/sub"); len(got) != 0 {
+			t.Errorf("List(dir/sub) = %v, want an empty directory", got)
+		}
+		if err := fm.Remove("dir"); err == nil {
+			t.Error("removing a non-empty directory succeeded")
+		}
+		if err := fm.Remove("dir/sub"); err != nil {
+			t.Fatal(err)
+		}
+		wantErr(t, "removed directory", func() error { _, err := fm.Stat("dir/sub"); return err }(), fs.ErrNotExist)
+		wantErr(t, "missing file", fm.Remove("missing"), fs.ErrNotExist)
+		wantErr(t, "root", fm.Remove("."), fs.ErrInvalid)
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	for _, b := range backends {
+		if !b.readOnly {
+			continue
+		}
+		t.Run(b.name, func(t *testing.T) {
+			fm := b.new(t, seed)
+			_, err := fm.Open("a.txt")
+			wantErr(t, "Open", err, ErrReadOnly)
+			wantErr(t, "Rename", fm.Rename("a.txt", "b.txt"), fs.ErrPermission)
+			wantErr(t, "Remove", fm.Remove("a.txt"), fs.ErrPermission)
+			if got := mustRead(t, fm, "a.txt"); got != "alpha" {
+				t.Errorf("Read(a.txt) = %q after failed writes", got)
+			}
+		})
+	}
+}
+
+// TestArchiveRoundTrip checks that a writable backend and the zip made
+// from it list and read the same.
+func TestArchiveRoundTrip(t *testing.T) {
+	mem := seeded(t, NewMemFileManager(), seed)
+	var buf bytes.Buffer
+	if err := archive(mem, &buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipped := NewZipFileManager(zr)
+	for _, dir := range []string{".", "dir", "dir/sub", "other"} {
+		if got, want := names(t, zipped, dir), names(t, mem, dir); !slices.Equal(got, want) {
+			t.Errorf("List(%q): zip has %v, memory has %v", dir, got, want)
+		}
+	}
+	for name, want := range seed {
+		if got := mustRead(t, zipped, name); got != want {
+			t.Errorf("Read(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
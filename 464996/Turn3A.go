@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const pingPeriod = 30 * time.Second
+
+var upGrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// pinger keeps a connection alive until done is closed or a ping fails.
+func pinger(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// WebSocket echo handler using defer for cleanup: every return path closes
+// the connection and stops the pinger.
+func serveWebSocketDefer(w http.ResponseWriter, r *http.Request) {
+	conn, err := upGrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go pinger(conn, done)
+
+	for {
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := conn.WriteMessage(mt, msg); err != nil {
+			return
+		}
+	}
+}
+
+// WebSocket echo handler cleaning up by hand at the end. Every exit from
+// the loop reaches the cleanup, so it releases the same resources as the
+// defer variant, just without defer.
+func serveWebSocketNoDefer(w http.ResponseWriter, r *http.Request) {
+	conn, err := upGrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	done := make(chan struct{})
+	go pinger(conn, done)
+
+	for {
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		if err := conn.WriteMessage(mt, msg); err != nil {
+			break
+		}
+	}
+	close(done)
+	conn.Close()
+}
+
+// serveWebSocketLeaky is a deliberately broken handler for checking that the
+// load test detects leaks. Its early return for clients that vanish without
+// a close handshake skips the manual cleanup, leaking the connection's file
+// descriptor and the pinger goroutine.
+func serveWebSocketLeaky(w http.ResponseWriter, r *http.Request) {
+	conn, err := upGrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	done := make(chan struct{})
+	go pinger(conn, done)
+
+	for {
+		mt, msg, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return
+			}
+			break
+		}
+		if err := conn.WriteMessage(mt, msg); err != nil {
+			break
+		}
+	}
+	close(done)
+	conn.Close()
+}
+
+// LoadConfig describes one load-test run.
+type LoadConfig struct {
+	Connections int           // peak concurrent connections
+	RampUp      time.Duration // dials are spread evenly over this
+	Hold        time.Duration // how long each connection sends before closing
+	Rate        float64       // messages per second per connection
+	MessageSize int
+	// Abrupt is the fraction of connections dropped without a close
+	// handshake, as when a client crashes or loses its network.
+	Abrupt float64
+	// Settle bounds the wait for the process to return to its baseline
+	// after the last client is gone.
+	Settle    time.Duration
+	Tolerance int // goroutines or descriptors above baseline still counted as settled
+}
+
+// ProcSample is a snapshot of a process's resources. Counts that could
+// not be read are -1.
+type ProcSample struct {
+	Goroutines int
+	FDs        int
+	Threads    int
+}
+
+// sampleProc reads /proc/<pid> (the current process if pid is 0).
+// Goroutines can only be counted for the current process. The descriptor
+// count includes the one used to list /proc/<pid>/fd, which cancels out
+// in differences.
+func sampleProc(pid int) ProcSample {
+	s := ProcSample{Goroutines: -1, FDs: -1, Threads: -1}
+	dir := "/proc/self"
+	if pid > 0 {
+		dir = "/proc/" + strconv.Itoa(pid)
+	} else {
+		s.Goroutines = runtime.NumGoroutine()
+	}
+	if entries, err := os.ReadDir(dir + "/fd"); err == nil {
+		s.FDs = len(entries)
+	}
+	if status, err := os.ReadFile(dir + "/status"); err == nil {
+		sc := bufio.NewScanner(bytes.NewReader(status))
+		for sc.Scan() {
+			if v, ok := strings.CutPrefix(sc.Text(), "Threads:"); ok {
+				s.Threads, _ = strconv.Atoi(strings.TrimSpace(v))
+			}
+		}
+	}
+	return s
+}
+
+func (s ProcSample) String() string {
+	count := func(n int) string {
+		if n < 0 {
+			return "n/a"
+		}
+		return strconv.Itoa(n)
+	}
+	return fmt.Sprintf("goroutines=%s fds=%s threads=%s", count(s.Goroutines), count(s.FDs), count(s.Threads))
+}
+
+// within reports whether s is back to base, give or take tolerance.
+func (s ProcSample) within(base ProcSample, tolerance int) bool {
+	return s.Goroutines-base.Goroutines <= tolerance && s.FDs-base.FDs <= tolerance
+}
+
+// latencies collects durations for percentile reporting.
+type latencies struct {
+	mu sync.Mutex
+	d  []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	l.d = append(l.d, d)
+	l.mu.Unlock()
+}
+
+// percentile returns the q-th quantile (0 <= q <= 1) by nearest rank.
+func (l *latencies) percentile(q float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.d) == 0 {
+		return 0
+	}
+	slices.Sort(l.d)
+	return l.d[min(len(l.d)-1, int(q*float64(len(l.d))))]
+}
+
+func (l *latencies) summary() string {
+	l.mu.Lock()
+	n := len(l.d)
+	l.mu.Unlock()
+	if n == 0 {
+		return "-"
+	}
+	round := func(d time.Duration) time.Duration { return d.Round(10 * time.Microsecond) }
+	return fmt.Sprintf("%v / %v / %v", round(l.percentile(0.5)), round(l.percentile(0.99)), round(l.percentile(1)))
+}
+
+// RunResult is what one run measured.
+type RunResult struct {
+	Name     string
+	Elapsed  time.Duration
+	Connect  latencies // dial through completed handshake
+	Echo     latencies // message sent to echo received
+	Close    latencies // close frame sent to the server's close frame received
+	Messages atomic.Int64
+	Abrupt   atomic.Int64
+	Errors   struct{ Dial, Echo, Close atomic.Int64 }
+
+	Before, Peak, After, Settled ProcSample
+	SettleTime                   time.Duration
+	Leaked                       bool
+}
+
+// runLoad ramps up cfg.Connections clients against url and samples the
+// process (pid, or this one if 0) around the run.
+func runLoad(ctx context.Context, name, url string, cfg LoadConfig, pid int) *RunResult {
+	res := &RunResult{Name: name}
+	res.Before = sampleProc(pid)
+	start := time.Now()
+
+	dialer := websocket.Dialer{HandshakeTimeout: 5 * time.Second}
+	var wg sync.WaitGroup
+	for i := range cfg.Connections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			delay := time.Duration(0)
+			if cfg.Connections > 1 {
+				delay = cfg.RampUp * time.Duration(i) / time.Duration(cfg.Connections-1)
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			runClient(ctx, &dialer, url, cfg, rand.Float64() < cfg.Abrupt, res)
+		}()
+	}
+
+	// Every client is connected once the ramp is over, if Hold outlasts it.
+	if cfg.Hold > 0 {
+		select {
+		case <-time.After(cfg.RampUp + cfg.Hold/2):
+			res.Peak = sampleProc(pid)
+		case <-ctx.Done():
+		}
+	}
+	wg.Wait()
+	res.Elapsed = time.Since(start)
+	res.After = sampleProc(pid)
+
+	// Give server handlers time to notice the closes, collecting garbage
+	// so that unreachable connections are not mistaken for leaks.
+	settleStart := time.Now()
+	deadline := settleStart.Add(cfg.Settle)
+	for {
+		runtime.GC()
+		res.Settled = sampleProc(pid)
+		if res.Settled.within(res.Before, cfg.Tolerance) || time.Now().After(deadline) || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	res.SettleTime = time.Since(settleStart)
+	res.Leaked = !res.Settled.within(res.Before, cfg.Tolerance)
+	return res
+}
+
+func runClient(ctx context.Context, dialer *websocket.Dialer, url string, cfg LoadConfig, abrupt bool, res *RunResult) {
+	t0 := time.Now()
+	conn, _, err := dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		res.Errors.Dial.Add(1)
+		return
+	}
+	res.Connect.add(time.Since(t0))
+	defer conn.Close()
+
+	payload := make([]byte, cfg.MessageSize)
+	hold := time.NewTimer(cfg.Hold)
+	defer hold.Stop()
+	var tick <-chan time.Time
+	if cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for seq := 0; ; seq++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hold.C:
+			closeClient(conn, abrupt, res)
+			return
+		case <-tick:
+		}
+		copy(payload, strconv.Itoa(seq))
+		t := time.Now()
+		conn.SetReadDeadline(t.Add(5 * time.Second))
+		if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+			res.Errors.Echo.Add(1)
+			return
+		}
+		_, echo, err := conn.ReadMessage()
+		if err != nil || !bytes.Equal(echo, payload) {
+			res.Errors.Echo.Add(1)
+			return
+		}
+		res.Echo.add(time.Since(t))
+		res.Messages.Add(1)
+	}
+}
+
+// closeClient ends a connection: abruptly by dropping the TCP connection,
+// or cleanly by a close handshake, timed until the server answers.
+func closeClient(conn *websocket.Conn, abrupt bool, res *RunResult) {
+	if abrupt {
+		res.Abrupt.Add(1)
+		conn.NetConn().Close()
+		return
+	}
+	t := time.Now()
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := conn.WriteControl(websocket.CloseMessage, msg, t.Add(time.Second)); err != nil {
+		res.Errors.Close.Add(1)
+		return
+	}
+	conn.SetReadDeadline(t.Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				res.Close.add(time.Since(t))
+			} else {
+				res.Errors.Close.Add(1)
+			}
+			return
+		}
+	}
+}
+
+func printReport(cfg LoadConfig, results []*RunResult) {
+	fmt.Printf("\n%d connections, %v ramp-up, %v hold, %.1f msg/s each, %d-byte messages, %.0f%% abrupt closes\n",
+		cfg.Connections, cfg.RampUp, cfg.Hold, cfg.Rate, cfg.MessageSize, cfg.Abrupt*100)
+	fmt.Println("latencies are p50 / p99 / max")
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nvariant\telapsed\tmessages\terrors d/e/c\tconnect\techo\tclose")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%v\t%d\t%d/%d/%d\t%s\t%s\t%s\n", r.Name, r.Elapsed.Round(time.Millisecond), r.Messages.Load(),
+			r.Errors.Dial.Load(), r.Errors.Echo.Load(), r.Errors.Close.Load(), r.Connect.summary(), r.Echo.summary(), r.Close.summary())
+	}
+	tw.Flush()
+
+	count := func(n int) string {
+		if n < 0 {
+			return "n/a"
+		}
+		return strconv.Itoa(n)
+	}
+	fmt.Fprintln(tw, "\nvariant\tgoroutines before/peak/after/settled\tfds before/peak/after/settled\tsettle\tverdict")
+	for _, r := range results {
+		verdict := "ok"
+		if r.Leaked {
+			verdict = fmt.Sprintf("LEAK: %+d goroutines, %+d fds (%d abrupt closes)",
+				r.Settled.Goroutines-r.Before.Goroutines, r.Settled.FDs-r.Before.FDs, r.Abrupt.Load())
+		}
+		fmt.Fprintf(tw, "%s\t%s/%s/%s/%s\t%s/%s/%s/%s\t%v\t%s\n", r.Name,
+			count(r.Before.Goroutines), count(r.Peak.Goroutines), count(r.After.Goroutines), count(r.Settled.Goroutines),
+			count(r.Before.FDs), count(r.Peak.FDs), count(r.After.FDs), count(r.Settled.FDs),
+			r.SettleTime.Round(time.Millisecond), verdict)
+	}
+	tw.Flush()
+}
+
+var variants = map[string]string{
+	"defer": "/ws/defer",
+	"nodef": "/ws/nodef",
+	"leaky": "/ws/leaky", // known leak, to check the detector
+}
+
+func main() {
+	var cfg LoadConfig
+	flag.IntVar(&cfg.Connections, "conns", 200, "peak concurrent connections")
+	flag.DurationVar(&cfg.RampUp, "ramp", time.Second, "time over which connections are opened")
+	flag.DurationVar(&cfg.Hold, "hold", 2*time.Second, "how long each connection stays open")
+	flag.Float64Var(&cfg.Rate, "rate", 10, "messages per second per connection")
+	flag.IntVar(&cfg.MessageSize, "size", 64, "message size in bytes")
+	flag.Float64Var(&cfg.Abrupt, "abrupt", 0.2, "fraction of connections dropped without a close handshake")
+	flag.DurationVar(&cfg.Settle, "settle", 3*time.Second, "how long to wait for resources to return to baseline")
+	flag.IntVar(&cfg.Tolerance, "tolerance", 2, "goroutines or fds above baseline not counted as a leak")
+	target := flag.String("url", "", "base URL of an external server (ws://host:port); default runs one in-process")
+	pid := flag.Int("pid", 0, "process to sample with -url; goroutines are only counted in-process")
+	only := flag.String("variants", "defer,nodef", "comma-separated handler variants to compare (defer, nodef, leaky)")
+	flag.Parse()
+
+	base := *target
+	if base == "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc(variants["defer"], serveWebSocketDefer)
+		mux.HandleFunc(variants["nodef"], serveWebSocketNoDefer)
+		mux.HandleFunc(variants["leaky"], serveWebSocketLeaky)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			log.Fatal(err)
+		}
+		server := &http.Server{Handler: mux}
+		go func() {
+			if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("Server error: %v", err)
+			}
+		}()
+		defer server.Close()
+		base = "ws://" + ln.Addr().String()
+		*pid = 0
+	}
+
+	ctx := context.Background()
+	var results []*RunResult
+	for _, name := range strings.Split(*only, ",") {
+		path, ok := variants[name]
+		if !ok {
+			log.Fatalf("unknown variant %q", name)
+		}
+		log.Printf("Running %s against %s%s", name, base, path)
+		results = append(results, runLoad(ctx, name, base+path, cfg, *pid))
+	}
+	printReport(cfg, results)
+}
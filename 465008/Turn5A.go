@@ -0,0 +1,497 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var tmpl = template.Must(template.New("").Parse(`
+<!DOCTYPE html>
+<html>
+<head>
+	<title>Dynamic HTML Update</title>
+</head>
+<body>
+	<h1 id="title">{{.Title}}</h1>
+	<p id="message">{{.Message}}</p>
+	<p><small id="transport"></small></p>
+	<input type="text" id="message-input" placeholder="Enter your message">
+	<button id="send-button">Send</button>
+	<script>
+		var lastEventID = null;
+		var ws = null;
+
+		function show(data) {
+			document.getElementById("title").textContent = data.Title;
+			document.getElementById("message").textContent = data.Message;
+			if (data.ID) {
+				lastEventID = data.ID;
+			}
+		}
+
+		// Reconnects resume from the last update seen, so nothing sent
+		// while disconnected is lost.
+		function connectWebSocket() {
+			var url = "ws://" + location.host + "/ws";
+			if (lastEventID !== null) {
+				url += "?lastEventID=" + lastEventID;
+			}
+			ws = new WebSocket(url);
+			ws.onmessage = function(event) { show(JSON.parse(event.data)); };
+			ws.onclose = function() { setTimeout(connectWebSocket, 1000); };
+			document.getElementById("transport").textContent = "via WebSocket";
+		}
+
+		// EventSource reconnects by itself, sending Last-Event-ID.
+		function connectSSE() {
+			var es = new EventSource("/events");
+			es.onmessage = function(event) { show(JSON.parse(event.data)); };
+			es.addEventListener("resync", function(event) { show(JSON.parse(event.data)); });
+			document.getElementById("transport").textContent = "via server-sent events";
+		}
+
+		if (window.WebSocket && location.search.indexOf("sse") < 0) {
+			connectWebSocket();
+		} else {
+			connectSSE();
+		}
+
+		document.getElementById("send-button").addEventListener("click", function() {
+			var message = document.getElementById("message-input").value;
+			if (message.length == 0) {
+				return;
+			}
+			var u = {
+				Title: "Message Received",
+				Message: "From: " + document.location.href + "\nMessage: " + message
+			};
+			if (ws && ws.readyState === WebSocket.OPEN) {
+				ws.send(JSON.stringify(u));
+			} else {
+				fetch("/notify", {method: "POST", body: JSON.stringify(u)});
+			}
+			document.getElementById("message-input").value = "";
+		});
+	</script>
+</body>
+</html>
+`))
+
+// update is one notification. Stored updates have IDs starting at 1;
+// notices that only concern one client, such as a resync, have ID 0.
+type update struct {
+	ID      uint64 `json:"ID,omitempty"`
+	Title   string `json:"Title"`
+	Message string `json:"Message"`
+}
+
+const (
+	storeSize = 1024 // updates kept for catching up
+	sendSize  = 64   // per-client queue
+	// A client whose queue reaches this is too slow and is disconnected;
+	// it catches up from the store when it reconnects.
+	highWater = sendSize * 3 / 4
+
+	writeWait    = 10 * time.Second
+	pongWait     = 60 * time.Second
+	pingPeriod   = (pongWait * 9) / 10
+	sseKeepalive = 15 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true }, // Allow all origins for simplicity
+}
+
+// Store keeps the most recent updates in a ring and assigns their IDs.
+type Store struct {
+	mu    sync.Mutex
+	buf   []update
+	start int // index of the oldest update
+	n     int
+	last  uint64 // ID of the newest update
+}
+
+func NewStore(size int) *Store {
+	return &Store{buf: make([]update, size)}
+}
+
+// Append assigns u the next ID and stores it, evicting the oldest update
+// if the store is full.
+func (s *Store) Append(u update) update {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last++
+	u.ID = s.last
+	if s.n < len(s.buf) {
+		s.buf[(s.start+s.n)%len(s.buf)] = u
+		s.n++
+	} else {
+		s.buf[s.start] = u
+		s.start = (s.start + 1) % len(s.buf)
+	}
+	return u
+}
+
+// Since returns the updates after id. If some of them were already
+// evicted, or id is from the future (say, from before a restart), it
+// returns everything kept and false: the client must resync.
+func (s *Store) Since(id uint64) ([]update, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldest := s.last - uint64(s.n) + 1
+	if id > s.last || id+1 < oldest {
+		return s.slice(0), false
+	}
+	return s.slice(int(id + 1 - oldest)), true
+}
+
+// Last returns the ID of the newest update, 0 if there is none.
+func (s *Store) Last() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// slice copies the kept updates from the i-th oldest on.
+func (s *Store) slice(i int) []update {
+	out := make([]update, 0, s.n-i)
+	for ; i < s.n; i++ {
+		out = append(out, s.buf[(s.start+i)%len(s.buf)])
+	}
+	return out
+}
+
+// Client is one subscriber, whatever its transport.
+type Client struct {
+	Send        chan update
+	lastEventID uint64 // last ID delivered, owned by the transport's writer
+
+	done   chan struct{}
+	once   sync.Once
+	reason string
+}
+
+// Disconnect asks the client's transport to hang up. Only the first
+// reason is kept.
+func (c *Client) Disconnect(reason string) {
+	c.once.Do(func() {
+		c.reason = reason
+		close(c.done)
+	})
+}
+
+// Done is closed once the client is disconnected; Reason is valid then.
+func (c *Client) Done() <-chan struct{} { return c.done }
+func (c *Client) Reason() string        { return c.reason }
+
+// deliver is called by the transport's writer for everything it sends. It
+// drops updates the client already has, since catch-up and live delivery
+// may overlap.
+func (c *Client) deliver(u update, send func(update) error) error {
+	if u.ID != 0 && u.ID <= c.lastEventID {
+		return nil
+	}
+	if err := send(u); err != nil {
+		return err
+	}
+	if u.ID != 0 {
+		c.lastEventID = u.ID
+	}
+	return nil
+}
+
+// Hub fans updates out to clients. Publishing and subscribing share one
+// lock, so a new client gets each update exactly once: either in its
+// catch-up or through Send.
+type Hub struct {
+	mu      sync.Mutex
+	store   *Store
+	clients map[*Client]struct{}
+}
+
+func NewHub(store *Store) *Hub {
+	return &Hub{store: store, clients: make(map[*Client]struct{})}
+}
+
+// Publish stores u and queues it for every client. Clients whose queue
+// reaches highWater are disconnected.
+func (h *Hub) Publish(u update) update {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	u = h.store.Append(u)
+	for c := range h.clients {
+		select {
+		case c.Send <- u:
+			if len(c.Send) < highWater {
+				continue
+			}
+		default:
+		}
+		log.Printf("Disconnecting slow client (%d updates queued)", len(c.Send))
+		delete(h.clients, c)
+		c.Disconnect("too slow: reconnect to catch up")
+	}
+	return u
+}
+
+// Subscribe registers a client. With resume, the updates after lastID
+// are returned for the client to send first; ok is false if some were
+// lost and the client needs a resync. Without resume the client only
+// gets updates published from now on.
+func (h *Hub) Subscribe(lastID uint64, resume bool) (c *Client, missed []update, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c = &Client{Send: make(chan update, sendSize), done: make(chan struct{})}
+	ok = true
+	if resume {
+		missed, ok = h.store.Since(lastID)
+		c.lastEventID = lastID
+		if !ok {
+			c.lastEventID = 0
+		}
+	} else {
+		c.lastEventID = h.store.Last()
+	}
+	h.clients[c] = struct{}{}
+	return c, missed, ok
+}
+
+func (h *Hub) Unsubscribe(c *Client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+	c.Disconnect("closed")
+}
+
+var resyncNotice = update{Title: "Resync", Message: "Some updates were missed; showing everything still available."}
+
+// lastEventID reads the client's cursor from the Last-Event-ID header,
+// which EventSource sends on reconnect, or the lastEventID parameter.
+func lastEventID(r *http.Request) (id uint64, ok bool, err error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("lastEventID")
+	}
+	if s == "" {
+		return 0, false, nil
+	}
+	id, err = strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("bad last event ID %q", s)
+	}
+	return id, true, nil
+}
+
+type server struct {
+	hub *Hub
+}
+
+func (s *server) serveHome(w http.ResponseWriter, r *http.Request) {
+	err := tmpl.Execute(w, update{Title: "Welcome", Message: "Connecting..."})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *server) serveWS(w http.ResponseWriter, r *http.Request) {
+	lastID, resume, err := lastEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+	defer conn.Close()
+
+	client, missed, ok := s.hub.Subscribe(lastID, resume)
+	defer s.hub.Unsubscribe(client)
+
+	// Hang up as soon as the client is disconnected, even while the
+	// writer below is blocked on a slow connection.
+	go func() {
+		<-client.Done()
+		msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, client.Reason())
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		conn.Close()
+	}()
+	go s.readMessages(client, conn)
+
+	send := func(u update) error {
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		return conn.WriteJSON(u)
+	}
+	if !ok {
+		missed = append([]update{resyncNotice}, missed...)
+	}
+	if !resume {
+		missed = []update{{Title: "Connected", Message: "WebSocket connection established."}}
+	}
+	for _, u := range missed {
+		if err := client.deliver(u, send); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case u := <-client.Send:
+			if err := client.deliver(u, send); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-client.Done():
+			return
+		}
+	}
+}
+
+// readMessages publishes what the client sends until the connection fails.
+func (s *server) readMessages(client *Client, conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		var message update
+		if err := conn.ReadJSON(&message); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println("Error reading message from client:", err)
+			}
+			client.Disconnect("read failed")
+			return
+		}
+		message.ID = 0
+		s.hub.Publish(message)
+	}
+}
+
+// serveSSE streams updates as server-sent events, for clients that cannot
+// upgrade to WebSocket.
+func (s *server) serveSSE(w http.ResponseWriter, r *http.Request) {
+	lastID, resume, err := lastEventID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // keep proxies from buffering the stream
+
+	client, missed, ok := s.hub.Subscribe(lastID, resume)
+	defer s.hub.Unsubscribe(client)
+
+	write := func(format string, args ...any) error {
+		rc.SetWriteDeadline(time.Now().Add(writeWait))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	// Stored updates carry an id, so EventSource reconnects from the last
+	// one; notices go out as their own event type.
+	send := func(u update) error {
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if u.ID == 0 {
+			return write("event: resync\ndata: %s\n\n", data)
+		}
+		return write("id: %d\ndata: %s\n\n", u.ID, data)
+	}
+
+	if err := write("retry: 2000\n\n"); err != nil {
+		return
+	}
+	if !ok {
+		missed = append([]update{resyncNotice}, missed...)
+	}
+	for _, u := range missed {
+		if err := client.deliver(u, send); err != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(sseKeepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case u := <-client.Send:
+			if err := client.deliver(u, send); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := write(": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-client.Done():
+			write("event: disconnect\ndata: %s\n\n", client.Reason())
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveNotify publishes a POSTed update, for clients on SSE.
+func (s *server) serveNotify(w http.ResponseWriter, r *http.Request) {
+	var u update
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&u); err != nil {
+		http.Error(w, "invalid update: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	u.ID = 0
+	u = s.hub.Publish(u)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// Sends periodic updates to all connected clients
+func sendUpdates(hub *Hub, every time.Duration) {
+	for range time.Tick(every) {
+		hub.Publish(update{Title: "Server Time", Message: time.Now().Format(time.TimeOnly)})
+	}
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "listen address")
+	tick := flag.Duration("tick", 10*time.Second, "interval between server time updates (0 to disable)")
+	flag.Parse()
+
+	s := &server{hub: NewHub(NewStore(storeSize))}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.serveHome)
+	mux.HandleFunc("GET /ws", s.serveWS)
+	mux.HandleFunc("GET /events", s.serveSSE)
+	mux.HandleFunc("POST /notify", s.serveNotify)
+
+	if *tick > 0 {
+		go sendUpdates(s.hub, *tick)
+	}
+
+	log.Printf("Listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}